@@ -13,13 +13,34 @@ var (
 	compLevel      int
 	followSymlinks bool
 	passphrase     string
+	readRate       string
+	writeRate      string
+	rateBurst      string
+	lowPriority    bool
+	maxLoad        float64
+	onChange       string
+	snapshotDir    string
+
+	mountPassphrase string
 )
 
 var backupCmd = &cobra.Command{
 	Use:   "backup",
 	Short: "Archive and compress a path",
 	Run: func(cmd *cobra.Command, args []string) {
-		if err := internal.Backup(input, output, compLevel, followSymlinks, passphrase); err != nil {
+		throttle, err := setupThrottle()
+		if err != nil {
+			fmt.Printf("Backup failed: %v\n", err)
+			return
+		}
+		policy, err := internal.ParseChangePolicy(onChange)
+		if err != nil {
+			fmt.Printf("Backup failed: %v\n", err)
+			return
+		}
+		changed, err := internal.Backup(input, output, compLevel, followSymlinks, passphrase, throttle, policy, snapshotDir)
+		printChangedFiles(changed)
+		if err != nil {
 			fmt.Printf("Backup failed: %v\n", err)
 		} else {
 			fmt.Println("Backup completed successfully")
@@ -27,11 +48,62 @@ var backupCmd = &cobra.Command{
 	},
 }
 
+// backupMountCmd exposes an archive as a read-only filesystem
+var backupMountCmd = &cobra.Command{
+	Use:   "mount [archive] [mountpoint]",
+	Short: "Mount a backup archive read-only via FUSE",
+	Long:  "Example: admin-cli backup mount ./backup.tar.zst /mnt/backup -p 'secret'",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := internal.MountArchive(args[0], args[1], mountPassphrase); err != nil {
+			fmt.Printf("Mount failed: %v\n", err)
+		}
+	},
+}
+
+// printChangedFiles reports the files that were modified while the backup read them
+func printChangedFiles(changed []internal.ChangedFile) {
+	if len(changed) == 0 {
+		return
+	}
+	fmt.Printf("%d file(s) changed during backup:\n", len(changed))
+	for _, c := range changed {
+		fmt.Printf(" - %s: %s (%s)\n", c.Path, c.Reason, c.Action)
+	}
+}
+
+// setupThrottle builds the backup throttle from the rate and priority flags
+func setupThrottle() (*internal.Throttle, error) {
+	var rates [3]int64
+	for i, value := range []string{readRate, writeRate, rateBurst} {
+		if value == "" {
+			continue
+		}
+		n, err := internal.ParseByteSize(value)
+		if err != nil {
+			return nil, err
+		}
+		rates[i] = n
+	}
+	return internal.NewThrottle(rates[0], rates[1], rates[2], lowPriority, maxLoad)
+}
+
 func init() {
 	backupCmd.Flags().StringVarP(&input, "input", "i", ".", "Backup input path")
 	backupCmd.Flags().StringVarP(&output, "output", "o", "./backup.tar.zst", "Backup output path")
 	backupCmd.Flags().IntVarP(&compLevel, "compression-level", "c", 3, "Compression level (higher means better compression, slower speed)")
 	backupCmd.Flags().BoolVarP(&followSymlinks, "follow-symlinks", "f", false, "Follow symbolic links when archiving")
 	backupCmd.Flags().StringVarP(&passphrase, "passphrase", "p", "", "Age recipient passphrase")
+	backupCmd.Flags().StringVar(&readRate, "read-rate", "", "Limit reading source files to this many bytes/sec (e.g. 20M)")
+	backupCmd.Flags().StringVar(&writeRate, "write-rate", "", "Limit writing the archive to this many bytes/sec (e.g. 10M)")
+	backupCmd.Flags().StringVar(&rateBurst, "burst", "", "Bytes allowed at once above the rate limits (default one second worth)")
+	backupCmd.Flags().BoolVar(&lowPriority, "low-priority", false, "Run with lowest CPU priority and idle I/O class (like nice/ionice)")
+	backupCmd.Flags().StringVar(&onChange, "on-change", "fail", "What to do with files modified while being read: fail, skip (keep as read), retry (archive a stable copy again) or snapshot (archive a copy of every file, doubling reads and needing room for the largest file in --snapshot-dir)")
+	backupCmd.Flags().StringVar(&snapshotDir, "snapshot-dir", "", "Directory for the private copies of the snapshot and retry policies (default $TMPDIR or /tmp)")
+	backupCmd.Flags().Float64Var(&maxLoad, "max-load", 0, "Pause while the 1-minute load average exceeds this value, Linux only (0 disables)")
+
+	backupMountCmd.Flags().StringVarP(&mountPassphrase, "passphrase", "p", "", "Age recipient passphrase")
+	backupCmd.AddCommand(backupMountCmd)
+
 	rootCmd.AddCommand(backupCmd)
 }
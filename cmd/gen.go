@@ -0,0 +1,250 @@
+package cmd
+
+import (
+	"admin-cli/internal"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	genCount      int
+	genSize       int
+	genPrefix     string
+	genEncoding   string
+	genUUIDv7     bool
+	genOut        string
+	genForce      bool
+	genComment    string
+	genPassphrase string
+	genHosts      []string
+	genCommonName string
+	genDays       int
+	genKeyType    string
+	genCA         bool
+	genCertOut    string
+	genKeyOut     string
+)
+
+var genCmd = &cobra.Command{
+	Use:   "gen",
+	Short: "Generate tokens, secrets, UUIDs and keys",
+	Long: `Generate API tokens, random secrets, UUIDs, age and SSH keys and self-signed TLS
+certificates for bootstrapping services. Passwords are generated by 'genpass'.`,
+}
+
+var genTokenCmd = &cobra.Command{
+	Use:   "token",
+	Short: "Generate API tokens",
+	Long: `Generate API tokens of base62 characters, like adm_3xK9... with --prefix adm. A prefix
+makes tokens easy to recognise and to find with secret scanners.`,
+	Args:          cobra.NoArgs,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return printGenerated(func() (string, error) { return internal.GenerateToken(genPrefix, genSize) })
+	},
+}
+
+var genSecretCmd = &cobra.Command{
+	Use:   "secret",
+	Short: "Generate random secrets in hex, base64, base64url or base58",
+	Long: `Generate random secrets, such as session or encryption keys, of --size bytes.
+
+Encodings:
+  hex        lowercase hexadecimal
+  base64     standard base64 with padding
+  base64url  URL safe base64 without padding
+  base58     Bitcoin alphabet, without the look-alike characters 0, O, I and l`,
+	Args:          cobra.NoArgs,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return printGenerated(func() (string, error) { return internal.RandomSecret(genSize, genEncoding) })
+	},
+}
+
+var genUUIDCmd = &cobra.Command{
+	Use:   "uuid",
+	Short: "Generate random (v4) or time ordered (v7) UUIDs",
+	Long: `Generate random version 4 UUIDs, or with --v7 version 7 UUIDs. Version 7 UUIDs start
+with the creation time and sort in creation order, which suits database keys.`,
+	Args:          cobra.NoArgs,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return printGenerated(func() (string, error) {
+			newUUID := internal.NewUUIDv4
+			if genUUIDv7 {
+				newUUID = func() (internal.UUID, error) { return internal.NewUUIDv7(time.Now()) }
+			}
+			u, err := newUUID()
+			return u.String(), err
+		})
+	},
+}
+
+var genAgeCmd = &cobra.Command{
+	Use:   "age",
+	Short: "Generate an age X25519 key pair",
+	Long: `Generate an age X25519 identity in the format of age-keygen. The identity is written to
+--output, or printed when none is given; the public key (recipient) is printed to stderr.`,
+	Args:          cobra.NoArgs,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := checkOverwrite(genOut); err != nil {
+			return err
+		}
+		identity, recipient, err := internal.GenerateAgeKey()
+		if err != nil {
+			return err
+		}
+		if genOut == "" {
+			fmt.Print(string(identity))
+		} else if err := os.WriteFile(genOut, identity, 0600); err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, "Public key:", recipient)
+		return nil
+	},
+}
+
+var genSSHCmd = &cobra.Command{
+	Use:   "ssh",
+	Short: "Generate an Ed25519 SSH key pair",
+	Long: `Generate an Ed25519 SSH key pair in OpenSSH format. Like ssh-keygen, the private key is
+written to --output and the public key to the same path with .pub appended. Without --output
+both are printed. The private key is encrypted with --passphrase; without one it is stored
+unencrypted.`,
+	Args:          cobra.NoArgs,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if genOut != "" {
+			if err := checkOverwrite(genOut, genOut+".pub"); err != nil {
+				return err
+			}
+		}
+		private, public, err := internal.GenerateSSHKey(genComment, genPassphrase)
+		if err != nil {
+			return fmt.Errorf("error generating keys: %v", err)
+		}
+		if genOut == "" {
+			fmt.Print(string(private), string(public))
+			return nil
+		}
+		if err := os.WriteFile(genOut, private, 0600); err != nil {
+			return err
+		}
+		if err := os.WriteFile(genOut+".pub", public, 0644); err != nil {
+			return err
+		}
+		if genPassphrase == "" {
+			fmt.Fprintln(os.Stderr, "Warning: the private key is not encrypted, keep it safe.")
+		}
+		fmt.Printf("Private key written to %s, public key to %s.pub.\n", genOut, genOut)
+		return nil
+	},
+}
+
+var genTLSCmd = &cobra.Command{
+	Use:   "tls",
+	Short: "Generate a self-signed TLS certificate and its key",
+	Long: `Generate a self-signed TLS server certificate for the --host names and IP addresses, with
+an ECDSA P-256 key unless --key-type says otherwise. The certificate and the PKCS #8 private
+key are written PEM encoded to --cert and --key.`,
+	Args:          cobra.NoArgs,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if genDays < 1 {
+			return fmt.Errorf("days must be at least 1")
+		}
+		if err := checkOverwrite(genCertOut, genKeyOut); err != nil {
+			return err
+		}
+		cert, key, err := internal.GenerateSelfSignedCert(internal.CertOptions{
+			Hosts:      genHosts,
+			CommonName: genCommonName,
+			ValidFor:   time.Duration(genDays) * 24 * time.Hour,
+			KeyType:    strings.ToLower(genKeyType),
+			IsCA:       genCA,
+		})
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(genKeyOut, key, 0600); err != nil {
+			return err
+		}
+		if err := os.WriteFile(genCertOut, cert, 0644); err != nil {
+			return err
+		}
+		fmt.Printf("Certificate written to %s, private key to %s.\n", genCertOut, genKeyOut)
+		return nil
+	},
+}
+
+// printGenerated prints --count values of generate, one per line
+func printGenerated(generate func() (string, error)) error {
+	if genCount < 1 {
+		return fmt.Errorf("count must be at least 1")
+	}
+	for i := 0; i < genCount; i++ {
+		s, err := generate()
+		if err != nil {
+			return err
+		}
+		fmt.Println(s)
+	}
+	return nil
+}
+
+// checkOverwrite fails when one of the paths exists, unless --force is given
+func checkOverwrite(paths ...string) error {
+	if genForce {
+		return nil
+	}
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err == nil {
+			return fmt.Errorf("%s already exists, use --force to overwrite it", path)
+		}
+	}
+	return nil
+}
+
+func init() {
+	for _, c := range []*cobra.Command{genTokenCmd, genSecretCmd, genUUIDCmd} {
+		c.Flags().IntVarP(&genCount, "count", "n", 1, "Number of values to generate")
+	}
+	for _, c := range []*cobra.Command{genTokenCmd, genSecretCmd} {
+		c.Flags().IntVar(&genSize, "size", internal.DefaultSecretSize, "Random bytes in each value")
+	}
+	genTokenCmd.Flags().StringVar(&genPrefix, "prefix", "", "Prefix of the tokens, lowercase letters and digits")
+	genSecretCmd.Flags().StringVarP(&genEncoding, "encoding", "e", "hex", "Encoding: "+strings.Join(internal.SecretEncodings, ", "))
+	genUUIDCmd.Flags().BoolVar(&genUUIDv7, "v7", false, "Time ordered version 7 UUIDs instead of random version 4")
+
+	for _, c := range []*cobra.Command{genAgeCmd, genSSHCmd, genTLSCmd} {
+		c.Flags().BoolVar(&genForce, "force", false, "Overwrite existing files")
+	}
+	genAgeCmd.Flags().StringVarP(&genOut, "output", "o", "", "Identity file to create")
+	genSSHCmd.Flags().StringVarP(&genOut, "output", "o", "", "Private key file to create, the public key gets .pub appended")
+	genSSHCmd.Flags().StringVarP(&genComment, "comment", "C", "", "Comment of the key, such as user@host")
+	genSSHCmd.Flags().StringVar(&genPassphrase, "passphrase", "", "Passphrase encrypting the private key")
+	genTLSCmd.Flags().StringSliceVar(&genHosts, "host", nil, "DNS name or IP address the certificate is for, repeat or separate with commas")
+	genTLSCmd.Flags().StringVar(&genCommonName, "cn", "", "Subject common name, the first host by default")
+	genTLSCmd.Flags().IntVar(&genDays, "days", 365, "Days the certificate is valid for")
+	genTLSCmd.Flags().StringVar(&genKeyType, "key-type", internal.KeyTypeECDSA, "Key type: ecdsa (P-256), ed25519 or rsa (3072 bits)")
+	genTLSCmd.Flags().BoolVar(&genCA, "ca", false, "Allow the certificate to sign other certificates")
+	genTLSCmd.Flags().StringVar(&genCertOut, "cert", "cert.pem", "Certificate file to create")
+	genTLSCmd.Flags().StringVar(&genKeyOut, "key", "key.pem", "Private key file to create")
+
+	genCmd.AddCommand(genTokenCmd, genSecretCmd, genUUIDCmd, genAgeCmd, genSSHCmd, genTLSCmd)
+	rootCmd.AddCommand(genCmd)
+}
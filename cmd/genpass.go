@@ -2,23 +2,352 @@ package cmd
 
 import (
 	"admin-cli/internal"
+	"bufio"
 	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
 
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 )
 
-var length int
+// maxCheckAttempts bounds how often genpass draws again for --min-entropy and --breach-db
+const maxCheckAttempts = 1000
+
+var (
+	length        int
+	passCount     int
+	passMode      string
+	wordCount     int
+	wordSeparator string
+	capitalize    bool
+	digitCount    int
+	minEntropy    float64
+	breachDBPath  string
+
+	policyPath      string
+	policyFlags     internal.PasswordPolicy
+	policyFlagNames = []string{"require", "min-lower", "min-upper", "min-digits", "min-symbols",
+		"charset", "exclude", "no-ambiguous", "max-repeat", "ban"}
+)
 
 var genPassCmd = &cobra.Command{
 	Use:   "genpass",
 	Short: "Generate a password",
-	Run: func(cmd *cobra.Command, args []string) {
-		pwd, _ := internal.GeneratePassword(length)
-		fmt.Println("Generated Password:", pwd)
+	Long: `Generate a password.
+
+Modes:
+  chars          random characters from letters, digits and symbols (--length)
+  words          diceware passphrase from the EFF large wordlist (--words, --separator,
+                 --capitalize, --digits)
+  pronounceable  alternating consonants and vowels (--length)
+
+Passwords of the chars mode can follow a policy given by flags or by a JSON file (--policy),
+with flags taking precedence over the file:
+
+  {"length": 16, "require": ["lower", "upper", "digit"], "min_symbols": 2,
+   "no_ambiguous": true, "max_repeat": 2, "banned": ["admin", "password"]}
+
+Every password meeting the policy is equally likely to be generated.
+
+The entropy of the generator is reported in bits, a lower bound that applies to every
+password generated with the same options. With --min-entropy passwords are also
+estimated like 'genpass check' does and drawn again while the estimate is lower. With
+--breach-db passwords found in a local Have I Been Pwned list are drawn again. Once a
+candidate is drawn again, the passwords left are fewer and the entropy is an upper bound.`,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		mode, err := internal.ParsePasswordMode(passMode)
+		if err != nil {
+			return err
+		}
+		if mode != internal.ModeChars && (policyPath != "" || policyFlagsSet(cmd)) {
+			return fmt.Errorf("password policies only apply to the chars mode")
+		}
+		if passCount < 1 {
+			return fmt.Errorf("count must be at least 1")
+		}
+
+		var generate func() (string, float64, error)
+		switch mode {
+		case internal.ModeWords:
+			opts := internal.PassphraseOptions{
+				Words:      wordCount,
+				Separator:  wordSeparator,
+				Capitalize: capitalize,
+				Digits:     digitCount,
+			}
+			generate = func() (string, float64, error) { return internal.GeneratePassphrase(opts) }
+		case internal.ModePronounceable:
+			generate = func() (string, float64, error) { return internal.GeneratePronounceable(length) }
+		default:
+			policy, err := passwordPolicy(cmd)
+			if err != nil {
+				return err
+			}
+			if policy != nil {
+				generate = func() (string, float64, error) { return internal.GeneratePolicyPassword(*policy) }
+			} else {
+				generate = func() (string, float64, error) {
+					pwd, err := internal.GeneratePassword(length)
+					return pwd, internal.PasswordEntropy(length), err
+				}
+			}
+		}
+
+		// Candidates failing a check are drawn again
+		var checks []func(pwd string) (bool, error)
+		if minEntropy > 0 {
+			checks = append(checks, func(pwd string) (bool, error) {
+				return internal.EstimateStrength(pwd).Entropy() >= minEntropy, nil
+			})
+		}
+		if breachDBPath != "" {
+			db, err := internal.OpenBreachDB(breachDBPath)
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+			checks = append(checks, func(pwd string) (bool, error) {
+				count, err := db.Lookup(pwd)
+				return count == 0, err
+			})
+		}
+		rejected := false // Whether a check narrowed the passwords that can be generated
+		if len(checks) > 0 {
+			draw := generate
+			generate = func() (string, float64, error) {
+			attempts:
+				for attempt := 0; attempt < maxCheckAttempts; attempt++ {
+					pwd, entropy, err := draw()
+					if err != nil {
+						return "", 0, err
+					}
+					for _, check := range checks {
+						ok, err := check(pwd)
+						if err != nil {
+							return "", 0, err
+						}
+						if !ok {
+							rejected = true
+							continue attempts
+						}
+					}
+					return pwd, entropy, nil
+				}
+				return "", 0, fmt.Errorf("no password passed the checks in %d attempts, make the passwords longer", maxCheckAttempts)
+			}
+		}
+
+		var entropy float64
+		for i := 0; i < passCount; i++ {
+			var pwd string
+			if pwd, entropy, err = generate(); err != nil {
+				return err
+			}
+			fmt.Println("Generated Password:", pwd)
+		}
+		if rejected {
+			fmt.Printf("Entropy: at most %.1f bits per password, as candidates failing the checks were drawn again\n", entropy)
+		} else {
+			fmt.Printf("Entropy: %.1f bits per password\n", entropy)
+		}
+		return nil
 	},
 }
 
+var genPassCheckCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Estimate the strength of a password read from stdin",
+	Long: `Estimate the strength of a password read from stdin.
+
+Passwords are never taken as arguments, which would leave them in the shell history and
+process list. On a terminal the password is read without echo; otherwise every line of
+stdin is checked.
+
+The estimate follows zxcvbn: the password is split into common passwords, dictionary words
+and names (also reversed, capitalized or with l33t substitutions), keyboard patterns,
+repeats, sequences, years and dates, and the split needing the fewest guesses is taken.
+Crack times are given for online attacks and for offline attacks on slow and fast hashes.
+
+With --breach-db the password is also looked up in a local copy of the Have I Been Pwned
+Pwned Passwords list, the SHA-1 version ordered by hash. Nothing is sent over the network.`,
+	Args:          cobra.NoArgs,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var db *internal.BreachDB
+		if breachDBPath != "" {
+			var err error
+			if db, err = internal.OpenBreachDB(breachDBPath); err != nil {
+				return err
+			}
+			defer db.Close()
+		}
+		passwords, err := readPasswords(os.Stdin)
+		if err != nil {
+			return err
+		}
+		for i, pwd := range passwords {
+			if i > 0 {
+				fmt.Println()
+			}
+			printStrength(internal.EstimateStrength(pwd))
+			if db != nil {
+				count, err := db.Lookup(pwd)
+				if err != nil {
+					return fmt.Errorf("failed to search %s: %v", breachDBPath, err)
+				}
+				if count > 0 {
+					fmt.Printf("Breached: yes, seen %d times in breaches. Do not use this password.\n", count)
+				} else {
+					fmt.Println("Breached: not found in the breach database")
+				}
+			}
+		}
+		return nil
+	},
+}
+
+// readPasswords prompts for a password without echo on a terminal and reads one password
+// per line otherwise
+func readPasswords(stdin *os.File) ([]string, error) {
+	if fd := int(stdin.Fd()); term.IsTerminal(fd) {
+		fmt.Fprint(os.Stderr, "Password: ")
+		pwd, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read password: %v", err)
+		}
+		return []string{string(pwd)}, nil
+	}
+
+	var passwords []string
+	scanner := bufio.NewScanner(stdin)
+	for scanner.Scan() {
+		if pwd := strings.TrimSuffix(scanner.Text(), "\r"); pwd != "" {
+			passwords = append(passwords, pwd)
+		}
+	}
+	if err := scanner.Err(); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read passwords: %v", err)
+	}
+	if len(passwords) == 0 {
+		return nil, fmt.Errorf("no password on stdin")
+	}
+	return passwords, nil
+}
+
+// printStrength prints the estimate, crack times, feedback and the patterns found
+func printStrength(r internal.StrengthResult) {
+	fmt.Printf("Score: %d/4 (%s)\n", r.Score, r.ScoreName())
+	fmt.Printf("Guesses: 10^%.1f\n", math.Log10(r.Guesses))
+	fmt.Printf("Estimated entropy: %.1f bits\n", r.Entropy())
+	fmt.Println("Crack time:")
+	for _, t := range r.CrackTimes {
+		fmt.Printf("  %-33s %s\n", t.Scenario, internal.FormatCrackTime(t.Seconds))
+	}
+	if r.Warning != "" {
+		fmt.Println("Warning:", r.Warning)
+	}
+	if len(r.Suggestions) > 0 {
+		fmt.Println("Suggestions:")
+		for _, s := range r.Suggestions {
+			fmt.Println("  -", s)
+		}
+	}
+	fmt.Println("Patterns:")
+	for _, m := range r.Sequence {
+		fmt.Printf("  %-10s %-20q %s\n", m.Pattern, m.Token, m.Describe())
+	}
+}
+
+// policyFlagsSet reports whether any password policy flag was given
+func policyFlagsSet(cmd *cobra.Command) bool {
+	for _, name := range policyFlagNames {
+		if cmd.Flags().Changed(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// passwordPolicy returns the policy from --policy and the policy flags, or nil without either
+func passwordPolicy(cmd *cobra.Command) (*internal.PasswordPolicy, error) {
+	if policyPath == "" && !policyFlagsSet(cmd) {
+		return nil, nil
+	}
+	var policy internal.PasswordPolicy
+	if policyPath != "" {
+		var err error
+		if policy, err = internal.LoadPasswordPolicy(policyPath); err != nil {
+			return nil, err
+		}
+	}
+
+	flags := cmd.Flags()
+	if policy.Length == 0 || flags.Changed("length") {
+		policy.Length = length
+	}
+	if flags.Changed("require") {
+		policy.Require = policyFlags.Require
+	}
+	if flags.Changed("min-lower") {
+		policy.MinLower = policyFlags.MinLower
+	}
+	if flags.Changed("min-upper") {
+		policy.MinUpper = policyFlags.MinUpper
+	}
+	if flags.Changed("min-digits") {
+		policy.MinDigits = policyFlags.MinDigits
+	}
+	if flags.Changed("min-symbols") {
+		policy.MinSymbols = policyFlags.MinSymbols
+	}
+	if flags.Changed("charset") {
+		policy.Charset = policyFlags.Charset
+	}
+	if flags.Changed("exclude") {
+		policy.Exclude = policyFlags.Exclude
+	}
+	if flags.Changed("no-ambiguous") {
+		policy.NoAmbiguous = policyFlags.NoAmbiguous
+	}
+	if flags.Changed("max-repeat") {
+		policy.MaxRepeat = policyFlags.MaxRepeat
+	}
+	if flags.Changed("ban") {
+		policy.Banned = policyFlags.Banned
+	}
+	return &policy, nil
+}
+
 func init() {
 	genPassCmd.Flags().IntVarP(&length, "length", "l", 12, "Length of the password")
+	genPassCmd.Flags().IntVarP(&passCount, "count", "c", 1, "Number of passwords to generate")
+	genPassCmd.Flags().StringVarP(&passMode, "mode", "m", "chars", "Password mode: chars, words or pronounceable")
+	genPassCmd.Flags().IntVarP(&wordCount, "words", "w", 6, "Number of words in a passphrase")
+	genPassCmd.Flags().StringVar(&wordSeparator, "separator", "-", "Separator between passphrase words")
+	genPassCmd.Flags().BoolVar(&capitalize, "capitalize", false, "Capitalize every passphrase word")
+	genPassCmd.Flags().IntVar(&digitCount, "digits", 0, "Random digits to add to passphrase words")
+	genPassCmd.Flags().StringVar(&policyPath, "policy", "", "JSON file with the password policy")
+	genPassCmd.Flags().StringSliceVar(&policyFlags.Require, "require", nil, "Character classes to include at least once: lower, upper, digit, symbol")
+	genPassCmd.Flags().IntVar(&policyFlags.MinLower, "min-lower", 0, "Minimum number of lower case letters")
+	genPassCmd.Flags().IntVar(&policyFlags.MinUpper, "min-upper", 0, "Minimum number of upper case letters")
+	genPassCmd.Flags().IntVar(&policyFlags.MinDigits, "min-digits", 0, "Minimum number of digits")
+	genPassCmd.Flags().IntVar(&policyFlags.MinSymbols, "min-symbols", 0, "Minimum number of symbols")
+	genPassCmd.Flags().StringVar(&policyFlags.Charset, "charset", "", "Characters to draw from instead of letters, digits and !@#$%^&*()")
+	genPassCmd.Flags().StringVar(&policyFlags.Exclude, "exclude", "", "Characters to leave out")
+	genPassCmd.Flags().BoolVar(&policyFlags.NoAmbiguous, "no-ambiguous", false, "Leave out the look-alike characters 0O1lI")
+	genPassCmd.Flags().IntVar(&policyFlags.MaxRepeat, "max-repeat", 0, "Maximum run of the same character, 0 for no limit")
+	genPassCmd.Flags().StringSliceVar(&policyFlags.Banned, "ban", nil, "Substrings the password may not contain, ignoring case")
+	genPassCmd.Flags().Float64Var(&minEntropy, "min-entropy", 0, "Draw again until the estimated entropy of the password reaches this many bits")
+	for _, c := range []*cobra.Command{genPassCmd, genPassCheckCmd} {
+		c.Flags().StringVar(&breachDBPath, "breach-db", "", "Have I Been Pwned SHA-1 file (ordered by hash) to look passwords up in")
+	}
+	genPassCmd.AddCommand(genPassCheckCmd)
 	rootCmd.AddCommand(genPassCmd)
 }
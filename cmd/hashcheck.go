@@ -1,11 +1,16 @@
 package cmd
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
-	"sync"
+	"strings"
+	"syscall"
+	"time"
 
 	"admin-cli/internal"
 
@@ -13,77 +18,857 @@ import (
 )
 
 var (
-	dirPath        string
+	dirPaths       []string
+	hashPassphrase string
 	maxConcurrency int
+	dedupeAction   string
+	keepRule       string
+	keepPriority   []string
+	dryRun         bool
+	undoLog        string
+	manifestPath   string
+	manifestFormat string
+	verifyQuiet    bool
+	ignoreNew      bool
+	hashAlgos      []string
+	noHashCache    bool
+	hashCachePath  string
+	watchDebounce  time.Duration
+	includeGlobs   []string
+	excludeGlobs   []string
+	minSize        string
+	maxSize        string
+	maxDepth       int
+	oneFileSystem  bool
+	followLinks    bool
+	skipHidden     bool
+	keepGoing      bool
+	outputFormat   string
+	fuzzyMatch     bool
+	similarity     int
+	readStrategy   string
+	readBuffer     string
+	deviceReads    int
+	cpuWorkers     int
+	signKeyPath    string
+	signaturePath  string
+	keyPassphrase  string
+	pubKeyPath     string
+	secretKeyOut   string
+	publicKeyOut   string
+	keygenForce    bool
 )
 
 var hashCheckCmd = &cobra.Command{
 	Use:   "hashcheck",
-	Short: "Compute and check file hashes for collisions in a directory using concurrency",
+	Short: "Find duplicate files in a directory by comparing sizes and content hashes concurrently",
+	Long: `Find duplicate files in a directory by comparing sizes and content hashes concurrently.
+
+--dir may be given several times and may name .tar, .tar.zst, .tar.gz and .zip archives,
+including age encrypted backups (give the passphrase with --passphrase). The files inside an
+archive are hashed while it is read, without extracting it, and are shown as
+"archive.tar.zst!/path/in/archive". Duplicates inside archives can only be reported.
+
+With --fuzzy, files that are similar but not identical are clustered and reported after the
+duplicates: JPEG, PNG and GIF images by their dHash and pHash perceptual hashes, so re-encoded
+or resized photos match, and other files by an ssdeep compatible piecewise hash, so slightly
+edited documents match. Files inside archives are not compared by similarity.
+
+Reading and hashing run as separate stages: --routines files are read at once, at most
+--device-reads of them from the same disk, while --cpu-workers chunks are hashed at once. By
+default a spinning disk is read one file at a time, so its head does not seek between files;
+on Linux the disk type is read from sysfs, elsewhere every disk is treated as solid state.
+With the default --io-strategy auto, files of 64 MiB and more are memory mapped and hashed
+from the mapping, smaller ones are read ahead into --buffer-size buffers while the previous
+buffer is hashed.`,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return configureHashIO()
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		action, err := internal.ParseDedupeAction(dedupeAction)
+		if err != nil {
+			return err
+		}
+		keep, err := internal.ParseKeepRule(keepRule)
+		if err != nil {
+			return err
+		}
+		algos, err := internal.ParseHashAlgorithms(hashAlgos)
+		if err != nil {
+			return err
+		}
+		output, err := internal.ParseOutputFormat(outputFormat)
+		if err != nil {
+			return err
+		}
+		if output != internal.OutputText && action != internal.ActionReport {
+			return fmt.Errorf("--output %s only works with --action report", output)
+		}
+		if similarity < 1 || similarity > 100 {
+			return fmt.Errorf("--similarity must be between 1 and 100")
+		}
+
+		// Archives can only be reported on, their contents cannot be replaced
+		var files []internal.ScannedFile
+		var walkErrs []internal.FileError
+		for _, root := range dirPaths {
+			if action != internal.ActionReport && internal.IsHashableArchive(root) {
+				return fmt.Errorf("--action %s cannot change files inside %s", action, root)
+			}
+			found, skipped, err := scanFiles(root, algos[:1])
+			if err != nil {
+				return fmt.Errorf("error walking the directory: %v", err)
+			}
+			files = append(files, found...)
+			walkErrs = append(walkErrs, skipped...)
+		}
+
+		cache, err := openHashCache()
+		if err != nil {
+			return err
+		}
+		defer cache.Close()
+
+		// Only files sharing size and partial hashes are fully hashed
+		sets, errs := internal.FindDuplicates(files, maxConcurrency, algos[0], cache)
+
+		// Near duplicates are only reported, the actions apply to identical files
+		var similar []internal.SimilarSet
+		var similarErrs []internal.FileError
+		if fuzzyMatch {
+			similar, similarErrs = internal.FindSimilar(files, maxConcurrency, similarity, sets, cache)
+			similarErrs = withoutFailed(similarErrs, errs)
+		}
+
+		if output != internal.OutputText {
+			report := internal.NewDuplicateReport(algos[0], sets, append(walkErrs, errs...))
+			if fuzzyMatch {
+				report.AddSimilar(similar, similarErrs)
+			}
+			if err := internal.WriteDuplicateReport(os.Stdout, report, output); err != nil {
+				return fmt.Errorf("error writing report: %v", err)
+			}
+			return nil
+		}
+		printSkipped(walkErrs)
+		for _, e := range append(errs, similarErrs...) {
+			fmt.Printf("Error computing hash for file %s: %v\n", e.Path, e.Err)
+		}
+		if hits, misses := cache.Stats(); hits > 0 {
+			fmt.Printf("Hash cache: %d of %d files unchanged since the last scan.\n", hits, hits+misses)
+		}
+
+		// Report the files with identical contents
+		internal.PrintDuplicateSets(sets)
+		if fuzzyMatch {
+			fmt.Println()
+			internal.PrintSimilarSets(similar)
+		}
+
+		// Replace or remove the redundant copies if requested
+		logPath, err := undoLogFile()
+		if err != nil && action != internal.ActionReport {
+			return err
+		}
+		reclaimed, err := internal.Deduplicate(sets, internal.DedupeOptions{
+			Action:   action,
+			Keep:     keep,
+			Priority: keepPriority,
+			DryRun:   dryRun,
+			UndoLog:  logPath,
+		})
+		if action != internal.ActionReport {
+			verb := "Reclaimed"
+			if dryRun {
+				verb = "Would reclaim"
+			}
+			fmt.Printf("%s %s.\n", verb, internal.FormatByteSize(reclaimed))
+		}
+		if err != nil {
+			return fmt.Errorf("error deduplicating files: %v", err)
+		}
+		return nil
+	},
+}
+
+// hashCheckUndoCmd reverts a previous deduplication
+var hashCheckUndoCmd = &cobra.Command{
+	Use:   "undo [undo-log]",
+	Short: "Restore the files replaced or deleted by a deduplication run",
+	Long: `Restore the files recorded in undo-log, by default the log deduplication writes to in the
+user cache directory. Files that are no longer links to the kept file were restored or changed
+since and are left alone. Restored files are removed from the log, so undo can be run again.`,
+	Args: cobra.MaximumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		hashes := make(map[[32]byte][]string) // Map of hashes (SHA-256 produces a 32-byte hash) to file paths
-		mu := &sync.Mutex{}                   // Mutex to protect shared state
-
-		// Buffered channel to send file paths to workers
-		fileChan := make(chan string, maxConcurrency)
-
-		// WaitGroup to track when all workers are done
-		var wg sync.WaitGroup
-
-		// Start workers based on maxConcurrency
-		for i := 0; i < maxConcurrency; i++ {
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-				for path := range fileChan {
-					hash, err := internal.ComputeFileHash(path)
-					if err != nil {
-						fmt.Printf("Error computing hash for file %s: %v\n", path, err)
-						continue
-					}
-
-					// Store the file path in the map by its hash
-					mu.Lock() // Protect the map with a mutex since it is shared across go routines
-					hashes[hash] = append(hashes[hash], path)
-					mu.Unlock()
+		logPath, err := undoLogFile()
+		if len(args) == 1 {
+			logPath, err = args[0], nil
+		}
+		if err != nil {
+			fmt.Println("Undo failed:", err)
+			return
+		}
+		if err := internal.UndoDedupe(logPath, dryRun); err != nil {
+			fmt.Println("Undo failed:", err)
+		}
+	},
+}
+
+// hashCheckGenerateCmd writes a checksum manifest of a directory
+var hashCheckGenerateCmd = &cobra.Command{
+	Use:   "generate",
+	Short: "Write a checksum manifest of every file in a directory",
+	Long: `Write the hash of every file under --dir to a manifest, computing every algorithm
+given with --algo in a single read of each file.
+
+The sha256sum and bsd formats can also be checked with 'sha256sum -c' (or md5sum, b2sum etc.
+for the matching algorithm) from the directory. The sha256sum format holds a single algorithm.
+
+With --sign the manifest is also signed, so 'hashcheck verify --pubkey' can detect changes to
+it. Minisign keys, like those of 'hashcheck keygen', give prehashed signatures that minisign -V
+verifies, signify keys give signatures that signify -V verifies and SSH keys give signatures
+that 'ssh-keygen -Y verify -n file' verifies.`,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, err := internal.ParseManifestFormat(manifestFormat)
+		if err != nil {
+			return err
+		}
+		algos, err := internal.ParseHashAlgorithms(hashAlgos)
+		if err != nil {
+			return err
+		}
+		if format == internal.FormatSHA256Sum && len(algos) > 1 {
+			return fmt.Errorf("the %s format holds a single algorithm, use --format bsd or json", format)
+		}
+		root, err := singleDir()
+		if err != nil {
+			return err
+		}
+
+		// Load the key first, a wrong passphrase should not waste a full scan
+		var key *internal.SigningKey
+		sigPath := signaturePath
+		if signKeyPath != "" {
+			if key, err = internal.LoadSigningKey(signKeyPath, keyPassphrase); err != nil {
+				return fmt.Errorf("error loading signing key: %v", err)
+			}
+			if sigPath == "" {
+				if manifestPath == "-" {
+					return fmt.Errorf("--signature is required when the manifest is written to stdout")
 				}
-			}()
+				sigPath = manifestPath + internal.SignatureExtension(key.Format)
+			}
+		}
+
+		files, skipped, err := scanFiles(root, algos)
+		if err != nil {
+			return fmt.Errorf("error walking the directory: %v", err)
 		}
+		printSkipped(skipped)
+		files = withoutFile(withoutFile(files, manifestPath), sigPath)
 
-		// Walk the directory and send file paths to the workers
-		err := filepath.Walk(dirPath, func(path string, info os.FileInfo, err error) error {
+		cache, err := openHashCache()
+		if err != nil {
+			return err
+		}
+		defer cache.Close()
+
+		manifest, errs := internal.GenerateManifest(root, files, maxConcurrency, algos, cache)
+		for _, e := range errs {
+			fmt.Fprintf(os.Stderr, "Error computing hash for file %s: %v\n", e.Path, e.Err)
+		}
+
+		// The signature covers exactly the bytes written
+		var buf bytes.Buffer
+		if err := internal.WriteManifest(&buf, manifest, format); err != nil {
+			return fmt.Errorf("error writing manifest: %v", err)
+		}
+		if manifestPath == "-" {
+			_, err = os.Stdout.Write(buf.Bytes())
+		} else {
+			err = os.WriteFile(manifestPath, buf.Bytes(), 0644)
+		}
+		if err != nil {
+			return fmt.Errorf("error writing manifest: %v", err)
+		}
+		if key != nil {
+			name := filepath.Base(strings.TrimSuffix(sigPath, internal.SignatureExtension(key.Format)))
+			comment := fmt.Sprintf("timestamp:%d\tfile:%s\thashed", time.Now().Unix(), name)
+			sig, err := key.Sign(buf.Bytes(), comment)
 			if err != nil {
-				return fmt.Errorf("error accessing file %s: %v", path, err)
+				return fmt.Errorf("error signing manifest: %v", err)
+			}
+			if err := os.WriteFile(sigPath, sig, 0644); err != nil {
+				return fmt.Errorf("error writing signature: %v", err)
+			}
+		}
+		if len(errs) > 0 {
+			return fmt.Errorf("%d files could not be hashed", len(errs))
+		}
+		return nil
+	},
+}
+
+// hashCheckVerifyCmd checks a directory against a checksum manifest
+var hashCheckVerifyCmd = &cobra.Command{
+	Use:   "verify [manifest]",
+	Short: "Check the files in a directory against a checksum manifest",
+	Long: `Re-hash the files listed in a manifest, relative to --dir, and report each one as
+OK, FAILED, MISSING or NEW (present in the directory but not in the manifest).
+Exits with a non-zero status if any file is not OK.
+
+Every hash recorded in BSD and JSON manifests is checked. Lines in sha256sum format carry no
+algorithm name; it is guessed from the digest length unless --algo is given.
+
+Files whose size, timestamps and inode are unchanged since they were last hashed are not read
+again. Use --no-cache to re-read every file, e.g. to detect silent disk corruption.
+
+--dir may also name an archive, whose files are checked against the manifest as if it had
+been extracted.
+
+With --pubkey the signature of the manifest (by default the manifest path followed by .minisig
+or .sig) is checked first, and no file is hashed unless it is valid. The public key may be a
+minisign or signify key, or SSH keys in authorized_keys or allowed_signers format.`,
+	Args:          cobra.ExactArgs(1),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		sumAlgo := ""
+		if cmd.Flags().Changed("algo") {
+			algos, err := internal.ParseHashAlgorithms(hashAlgos)
+			if err != nil {
+				return err
+			}
+			sumAlgo = algos[0]
+		}
+		output, err := internal.ParseOutputFormat(outputFormat)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			return fmt.Errorf("error reading manifest: %v", err)
+		}
+		sigPath := ""
+		if pubKeyPath != "" {
+			info := os.Stdout
+			if output != internal.OutputText {
+				info = os.Stderr // Keep the report alone on stdout
+			}
+			if sigPath, err = checkManifestSignature(args[0], data, info); err != nil {
+				return err
+			}
+		}
+		manifest, err := internal.ReadManifest(bytes.NewReader(data), sumAlgo)
+		if err != nil {
+			return fmt.Errorf("error reading manifest: %v", err)
+		}
+
+		root, err := singleDir()
+		if err != nil {
+			return err
+		}
+
+		// Files inside archives are hashed while scanning, so archives are always scanned
+		var files []internal.ScannedFile
+		var skipped []internal.FileError
+		if !ignoreNew || internal.IsHashableArchive(root) {
+			algos := manifest.Algorithms
+			if len(algos) == 0 {
+				algos = []string{internal.DefaultHashAlgorithm}
+			}
+			if files, skipped, err = scanFiles(root, algos); err != nil {
+				return fmt.Errorf("error walking the directory: %v", err)
+			}
+			files = withoutFile(withoutFile(files, args[0]), sigPath)
+		}
+
+		cache, err := openHashCache()
+		if err != nil {
+			return err
+		}
+		defer cache.Close()
+
+		results := internal.VerifyManifest(root, manifest, files, maxConcurrency, cache)
+		if ignoreNew {
+			results = withoutNew(results)
+		}
+		summary := internal.SummarizeVerify(results)
+		if output != internal.OutputText {
+			if err := internal.WriteVerifyReport(os.Stdout, results, skipped, output); err != nil {
+				return fmt.Errorf("error writing report: %v", err)
 			}
-			// Ignore directories
-			if info.IsDir() {
-				return nil
+			if !summary.Passed() {
+				return fmt.Errorf("verification failed")
 			}
-			// Send file path to the channel for processing
-			fileChan <- path
 			return nil
-		})
+		}
 
-		// Close the channel when all file paths have been sent
-		close(fileChan)
+		printSkipped(skipped)
+		for _, r := range results {
+			switch {
+			case r.Status == internal.StatusOK && verifyQuiet:
+			case r.Err != nil:
+				fmt.Printf("%s: %s (%v)\n", r.Path, r.Status, r.Err)
+			default:
+				fmt.Printf("%s: %s\n", r.Path, r.Status)
+			}
+		}
 
-		// Wait for all workers to finish
-		wg.Wait()
+		if !summary.Passed() {
+			return fmt.Errorf("verification failed: %d failed, %d missing, %d new", summary.Failed, summary.Missing, summary.New)
+		}
+		fmt.Printf("All %d files OK.\n", summary.OK)
+		return nil
+	},
+}
 
+// hashCheckCompareCmd reports the differences between two directory trees
+var hashCheckCompareCmd = &cobra.Command{
+	Use:   "compare [dirA] [dirB]",
+	Short: "Compare two directory trees by content hash",
+	Long: `Hash the files of both trees concurrently and report files only in A, only in B,
+files at the same path with different contents and files moved or renamed to another path
+with the same contents. Either tree may be an archive, e.g. to check a backup against the
+directory it was made from. Exits with a non-zero status if the trees differ.`,
+	Args:          cobra.ExactArgs(2),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		algos, err := internal.ParseHashAlgorithms(hashAlgos)
 		if err != nil {
-			fmt.Println("Error walking the directory:", err)
-			return
+			return err
+		}
+		output, err := internal.ParseOutputFormat(outputFormat)
+		if err != nil {
+			return err
+		}
+
+		filesA, skippedA, err := scanFiles(args[0], algos[:1])
+		if err != nil {
+			return fmt.Errorf("error walking %s: %v", args[0], err)
+		}
+		filesB, skippedB, err := scanFiles(args[1], algos[:1])
+		if err != nil {
+			return fmt.Errorf("error walking %s: %v", args[1], err)
+		}
+		skipped := append(skippedA, skippedB...)
+
+		cache, err := openHashCache()
+		if err != nil {
+			return err
+		}
+		defer cache.Close()
+
+		results, summary, errs := internal.CompareTrees(args[0], args[1], filesA, filesB, maxConcurrency, algos[0], cache)
+		if output != internal.OutputText {
+			if err := internal.WriteCompareReport(os.Stdout, results, summary, append(skipped, errs...), output); err != nil {
+				return fmt.Errorf("error writing report: %v", err)
+			}
+		} else {
+			printSkipped(skipped)
+			for _, e := range errs {
+				fmt.Printf("Error computing hash for file %s: %v\n", e.Path, e.Err)
+			}
+			for _, r := range results {
+				switch r.Status {
+				case internal.CompareOnlyA:
+					fmt.Printf("Only in %s: %s\n", args[0], r.PathA)
+				case internal.CompareOnlyB:
+					fmt.Printf("Only in %s: %s\n", args[1], r.PathB)
+				case internal.CompareDifferent:
+					fmt.Printf("Different: %s\n", r.PathA)
+				case internal.CompareMoved:
+					fmt.Printf("Moved: %s -> %s\n", r.PathA, r.PathB)
+				}
+			}
+			fmt.Printf("%d identical, %d only in %s, %d only in %s, %d different, %d moved.\n",
+				summary.Identical, summary.OnlyA, args[0], summary.OnlyB, args[1], summary.Different, summary.Moved)
+		}
+
+		if !summary.Matched() || len(errs) > 0 {
+			return fmt.Errorf("the trees differ")
+		}
+		return nil
+	},
+}
+
+// hashCheckWatchCmd reports files whose contents change
+var hashCheckWatchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Watch a directory and report files whose contents change or become duplicates",
+	Long: `Hash every file under --dir, then watch the tree for created, written, renamed and
+deleted files. Changed files are re-hashed once they have been quiet for --debounce, and a
+line is printed for every file that was created, deleted or whose contents changed, and for
+every file that now has the same contents as another. The filters (--include, --exclude,
+--min-size, --max-size, --max-depth, -x, -L and --skip-hidden) also apply to files created
+while watching. Stop with Ctrl+C.`,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		algos, err := internal.ParseHashAlgorithms(hashAlgos)
+		if err != nil {
+			return err
+		}
+		cache, err := openHashCache()
+		if err != nil {
+			return err
+		}
+		defer cache.Close()
+
+		root, err := singleDir()
+		if err != nil {
+			return err
+		}
+		if internal.IsHashableArchive(root) {
+			return fmt.Errorf("cannot watch archive %s, only directories", root)
+		}
+		opts, err := walkOptions()
+		if err != nil {
+			return err
+		}
+		watcher, errs, err := internal.NewWatcher(root, maxConcurrency, algos[0], cache, watchDebounce, opts)
+		if err != nil {
+			return err
+		}
+		defer watcher.Close()
+		for _, e := range errs {
+			fmt.Printf("Error computing hash for file %s: %v\n", e.Path, e.Err)
+		}
+		fmt.Printf("Watching %d files in %s\n", watcher.Files(), root)
+
+		// Stop watching on shutdown signal
+		stop := make(chan struct{})
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		go func() {
+			<-quit
+			close(stop)
+		}()
+
+		return watcher.Run(stop, printWatchEvent)
+	},
+}
+
+// printWatchEvent prints a single change reported by the watcher
+func printWatchEvent(e internal.WatchEvent) {
+	stamp := e.Time.Format(time.RFC3339)
+	switch e.Kind {
+	case internal.WatchModified:
+		fmt.Printf("%s %s %s (%s -> %s)\n", stamp, e.Kind, e.Path, e.OldHash, e.NewHash)
+	case internal.WatchCreated:
+		fmt.Printf("%s %s %s (%s)\n", stamp, e.Kind, e.Path, e.NewHash)
+	case internal.WatchDeleted:
+		fmt.Printf("%s %s %s (was %s)\n", stamp, e.Kind, e.Path, e.OldHash)
+	case internal.WatchDuplicate:
+		fmt.Printf("%s %s %s has the same contents as %s\n", stamp, e.Kind, e.Path, strings.Join(e.Duplicates, ", "))
+	default:
+		fmt.Printf("%s %s %s: %v\n", stamp, e.Kind, e.Path, e.Err)
+	}
+}
+
+// checkManifestSignature verifies the signature of a manifest against --pubkey and returns the signature path
+func checkManifestSignature(manifest string, data []byte, info io.Writer) (string, error) {
+	sigPath := signaturePath
+	if sigPath == "" {
+		sigPath = manifest + internal.SignatureExtension(internal.SigMinisign)
+		if _, err := os.Stat(sigPath); err != nil {
+			sigPath = manifest + internal.SignatureExtension(internal.SigSignify)
 		}
+	}
+	sig, err := os.ReadFile(sigPath)
+	if err != nil {
+		return "", fmt.Errorf("error reading signature: %v", err)
+	}
+	pub, err := os.ReadFile(pubKeyPath)
+	if err != nil {
+		return "", fmt.Errorf("error reading public key: %v", err)
+	}
+	comment, err := internal.VerifySignature(data, sig, pub)
+	if err != nil {
+		return "", fmt.Errorf("manifest signature is not valid, no files were checked: %v", err)
+	}
+	fmt.Fprintf(info, "Signature of %s verified with %s.\n", manifest, pubKeyPath)
+	if comment != "" {
+		fmt.Fprintf(info, "Trusted comment: %s\n", comment)
+	}
+	return sigPath, nil
+}
 
-		// Check for collisions (files with the same hash)
-		internal.PrintCollisions(hashes)
+// hashCheckKeygenCmd creates a key pair for signing manifests
+var hashCheckKeygenCmd = &cobra.Command{
+	Use:   "keygen",
+	Short: "Create an Ed25519 key pair for signing manifests",
+	Long: `Create an Ed25519 key pair in minisign format for 'hashcheck generate --sign'. Signatures
+made with it can also be checked with 'minisign -V', but not with 'signify -V', which does not
+accept the prehashed signatures of minisign. The secret key is encrypted with --key-passphrase;
+without one it is stored unencrypted.`,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if !keygenForce {
+			for _, path := range []string{secretKeyOut, publicKeyOut} {
+				if _, err := os.Stat(path); err == nil {
+					return fmt.Errorf("%s already exists, use --force to overwrite it", path)
+				}
+			}
+		}
+		pub, sec, err := internal.GenerateSigningKeys(keyPassphrase)
+		if err != nil {
+			return fmt.Errorf("error generating keys: %v", err)
+		}
+		if err := os.WriteFile(secretKeyOut, sec, 0600); err != nil {
+			return err
+		}
+		if err := os.WriteFile(publicKeyOut, pub, 0644); err != nil {
+			return err
+		}
+		if keyPassphrase == "" {
+			fmt.Fprintln(os.Stderr, "Warning: the secret key is not encrypted, keep it safe.")
+		}
+		fmt.Printf("Secret key written to %s, public key to %s.\n", secretKeyOut, publicKeyOut)
+		return nil
 	},
 }
 
+// hashCheckCacheCmd groups the hash cache maintenance commands
+var hashCheckCacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage the cache of file hashes kept between runs",
+}
+
+// hashCheckCacheCompactCmd drops outdated entries from the hash cache
+var hashCheckCacheCompactCmd = &cobra.Command{
+	Use:   "compact",
+	Short: "Remove entries for deleted or changed files from the hash cache",
+	Run: func(cmd *cobra.Command, args []string) {
+		path, err := hashCacheFile()
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+		kept, dropped, err := internal.CompactHashCache(path)
+		if err != nil {
+			fmt.Println("Compaction failed:", err)
+			return
+		}
+		fmt.Printf("Kept %d entries, removed %d outdated entries from %s.\n", kept, dropped, path)
+	},
+}
+
+// hashCacheFile returns the cache file selected with --cache or the default location
+func hashCacheFile() (string, error) {
+	if hashCachePath != "" {
+		return hashCachePath, nil
+	}
+	path, err := internal.DefaultHashCachePath()
+	if err != nil {
+		return "", fmt.Errorf("error locating the hash cache, use --cache: %v", err)
+	}
+	return path, nil
+}
+
+// undoLogFile returns the undo log selected with --undo-log or the default location
+func undoLogFile() (string, error) {
+	if undoLog != "" {
+		return undoLog, nil
+	}
+	path, err := internal.DefaultUndoLogPath()
+	if err != nil {
+		return "", fmt.Errorf("error locating the undo log, use --undo-log: %v", err)
+	}
+	return path, nil
+}
+
+// openHashCache opens the hash cache, or returns nil if it is disabled with --no-cache
+func openHashCache() (*internal.HashCache, error) {
+	if noHashCache {
+		return nil, nil
+	}
+	path, err := hashCacheFile()
+	if err != nil {
+		return nil, err
+	}
+	return internal.OpenHashCache(path)
+}
+
+// singleDir returns the directory of commands that work on a single tree
+func singleDir() (string, error) {
+	if len(dirPaths) != 1 {
+		return "", fmt.Errorf("this command takes a single --dir")
+	}
+	return dirPaths[0], nil
+}
+
+// withoutFailed removes the errors for files that already failed before
+func withoutFailed(errs, failed []internal.FileError) []internal.FileError {
+	seen := make(map[string]bool, len(failed))
+	for _, e := range failed {
+		seen[e.Path] = true
+	}
+	var kept []internal.FileError
+	for _, e := range errs {
+		if !seen[e.Path] {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
+
+// withoutNew removes the files missing from the manifest from the results
+func withoutNew(results []internal.VerifyResult) []internal.VerifyResult {
+	kept := results[:0]
+	for _, r := range results {
+		if r.Status != internal.StatusNew {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
+
+// withoutFile removes the given file, such as the manifest itself, from the scanned files
+func withoutFile(files []internal.ScannedFile, path string) []internal.ScannedFile {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return files
+	}
+	kept := files[:0]
+	for _, f := range files {
+		if p, err := filepath.Abs(f.Path); err != nil || p != abs {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
+
+// scanFiles walks the directory and returns every regular file passing the traversal flags.
+// With --keep-going, files that cannot be accessed are skipped and returned as errors. The
+// files inside an archive are hashed with the given algorithms while it is read.
+func scanFiles(root string, algos []string) ([]internal.ScannedFile, []internal.FileError, error) {
+	opts, err := walkOptions()
+	if err != nil {
+		return nil, nil, err
+	}
+	if info, err := os.Stat(root); err == nil && info.Mode().IsRegular() && internal.IsHashableArchive(root) {
+		files, err := internal.ScanArchive(root, hashPassphrase, algos, opts)
+		return files, nil, err
+	}
+	return internal.WalkFiles(root, opts)
+}
+
+// printSkipped reports the files that could not be accessed while scanning
+func printSkipped(errs []internal.FileError) {
+	for _, e := range errs {
+		fmt.Fprintf(os.Stderr, "Skipping %s: %v\n", e.Path, e.Err)
+	}
+}
+
+// configureHashIO applies the read strategy flags
+func configureHashIO() error {
+	opts := internal.DefaultHashIOOptions()
+	strategy, err := internal.ParseReadStrategy(readStrategy)
+	if err != nil {
+		return err
+	}
+	opts.Strategy = strategy
+	size, err := internal.ParseByteSize(readBuffer)
+	if err != nil {
+		return fmt.Errorf("invalid --buffer-size: %v", err)
+	}
+	opts.BufferSize = int(size)
+	opts.DeviceReads = deviceReads
+	opts.CPUWorkers = cpuWorkers
+	return internal.ConfigureHashIO(opts)
+}
+
+// walkOptions builds the traversal options from the flags
+func walkOptions() (internal.WalkOptions, error) {
+	opts := internal.WalkOptions{
+		Include:         includeGlobs,
+		Exclude:         excludeGlobs,
+		MaxDepth:        maxDepth,
+		OneFileSystem:   oneFileSystem,
+		FollowSymlinks:  followLinks,
+		SkipHidden:      skipHidden,
+		ContinueOnError: keepGoing,
+	}
+	var err error
+	if minSize != "" {
+		if opts.MinSize, err = internal.ParseByteSize(minSize); err != nil {
+			return opts, fmt.Errorf("invalid --min-size: %v", err)
+		}
+	}
+	if maxSize != "" {
+		if opts.MaxSize, err = internal.ParseByteSize(maxSize); err != nil {
+			return opts, fmt.Errorf("invalid --max-size: %v", err)
+		}
+	}
+	return opts, nil
+}
+
 func init() {
 	// Dynamically set default concurrency based on available CPU cores
 	defaultConcurrency := runtime.NumCPU() // Set default to number of CPUs
-	hashCheckCmd.Flags().StringVarP(&dirPath, "dir", "d", ".", "Directory to scan for file hash collisions")
-	hashCheckCmd.Flags().IntVarP(&maxConcurrency, "routines", "r", defaultConcurrency, "Number of concurrent workers to process files")
+	hashCheckCmd.PersistentFlags().StringSliceVarP(&dirPaths, "dir", "d", []string{"."}, "Directories or archives (.tar, .tar.zst, .tar.gz, .zip, optionally age encrypted) to scan")
+	hashCheckCmd.PersistentFlags().StringVarP(&hashPassphrase, "passphrase", "p", "", "Passphrase of age encrypted archives")
+	hashCheckCmd.PersistentFlags().IntVarP(&maxConcurrency, "routines", "r", defaultConcurrency, "Number of concurrent workers to process files")
+	hashCheckCmd.PersistentFlags().StringVar(&readStrategy, "io-strategy", string(internal.ReadAuto), "How files are read: auto (mmap large files), buffered, mmap or simple")
+	hashCheckCmd.PersistentFlags().StringVar(&readBuffer, "buffer-size", "1M", "Size of the read buffers")
+	hashCheckCmd.PersistentFlags().IntVar(&deviceReads, "device-reads", 0, "Files read at once from the same disk, 0 for one on spinning disks and no limit otherwise")
+	hashCheckCmd.PersistentFlags().IntVar(&cpuWorkers, "cpu-workers", defaultConcurrency, "Chunks hashed at once")
+	hashCheckCmd.PersistentFlags().StringSliceVar(&hashAlgos, "algo", []string{internal.DefaultHashAlgorithm},
+		"Hash algorithms: "+strings.Join(internal.HashAlgorithmNames(), ", ")+" (duplicate search uses the first)")
+	hashCheckCmd.PersistentFlags().BoolVar(&noHashCache, "no-cache", false, "Read every file instead of reusing hashes of unchanged files")
+	hashCheckCmd.PersistentFlags().StringVar(&hashCachePath, "cache", "", "Hash cache file (default in the user cache directory)")
+	hashCheckCmd.PersistentFlags().StringSliceVar(&includeGlobs, "include", []string{}, "Only hash files whose name or relative path matches one of these globs")
+	hashCheckCmd.PersistentFlags().StringSliceVar(&excludeGlobs, "exclude", []string{}, "Skip files and directories whose name or relative path matches one of these globs")
+	hashCheckCmd.PersistentFlags().StringVar(&minSize, "min-size", "", "Skip files smaller than this size (e.g. 4K, 10MB)")
+	hashCheckCmd.PersistentFlags().StringVar(&maxSize, "max-size", "", "Skip files larger than this size (e.g. 1G)")
+	hashCheckCmd.PersistentFlags().IntVar(&maxDepth, "max-depth", -1, "Directory levels to descend below --dir, -1 for no limit")
+	hashCheckCmd.PersistentFlags().BoolVarP(&oneFileSystem, "one-file-system", "x", false, "Do not descend into directories on other filesystems")
+	hashCheckCmd.PersistentFlags().BoolVarP(&followLinks, "follow", "L", false, "Follow symlinks, skipping loops and files reached twice")
+	hashCheckCmd.PersistentFlags().BoolVar(&skipHidden, "skip-hidden", false, "Skip files and directories whose name starts with a dot")
+	hashCheckCmd.PersistentFlags().BoolVar(&keepGoing, "keep-going", false, "Report files that cannot be read and continue instead of stopping")
+	hashCheckCmd.Flags().StringVarP(&outputFormat, "output", "o", "text", "Result format: text, json, csv or ndjson")
+	hashCheckCmd.Flags().BoolVar(&fuzzyMatch, "fuzzy", false, "Also report similar files: images by perceptual hash, other files by piecewise hash")
+	hashCheckCmd.Flags().IntVar(&similarity, "similarity", internal.DefaultSimilarity, "Similarity in percent (1-100) from which --fuzzy reports files as similar")
+	hashCheckCmd.Flags().StringVar(&dedupeAction, "action", "report", "What to do with duplicates: report, hardlink, reflink or delete")
+	hashCheckCmd.Flags().StringVar(&keepRule, "keep", "shortest", "Which file of a duplicate set to keep: oldest, newest, shortest or priority")
+	hashCheckCmd.Flags().StringSliceVar(&keepPriority, "priority", []string{}, "Directories to keep files from, in order of preference (with --keep priority)")
+	hashCheckCmd.Flags().StringVar(&undoLog, "undo-log", "", "File recording replaced and deleted files for 'hashcheck undo' (default in the user cache directory)")
+	for _, c := range []*cobra.Command{hashCheckCmd, hashCheckUndoCmd} {
+		c.Flags().BoolVarP(&dryRun, "dry-run", "n", false, "Show what would be changed without changing anything")
+	}
+
+	hashCheckGenerateCmd.Flags().StringVarP(&manifestPath, "manifest", "m", "-", "File to write the manifest to, - for stdout")
+	hashCheckGenerateCmd.Flags().StringVar(&manifestFormat, "format", "sha256sum", "Manifest format: sha256sum, bsd or json")
+	hashCheckVerifyCmd.Flags().BoolVarP(&verifyQuiet, "quiet", "q", false, "Only print files that are not OK")
+	hashCheckVerifyCmd.Flags().StringVarP(&outputFormat, "output", "o", "text", "Result format: text, json, csv or ndjson")
+	hashCheckCompareCmd.Flags().StringVarP(&outputFormat, "output", "o", "text", "Result format: text, json, csv or ndjson")
+	hashCheckVerifyCmd.Flags().BoolVar(&ignoreNew, "ignore-new", false, "Do not report files missing from the manifest")
+	hashCheckGenerateCmd.Flags().StringVar(&signKeyPath, "sign", "", "Sign the manifest with this minisign, signify or SSH secret key")
+	hashCheckGenerateCmd.Flags().StringVar(&signaturePath, "signature", "", "Signature file (default the manifest path followed by .minisig or .sig)")
+	hashCheckGenerateCmd.Flags().StringVar(&keyPassphrase, "key-passphrase", "", "Passphrase of the secret key")
+	hashCheckVerifyCmd.Flags().StringVar(&pubKeyPath, "pubkey", "", "Require a valid signature of the manifest by this public key")
+	hashCheckVerifyCmd.Flags().StringVar(&signaturePath, "signature", "", "Signature file (default the manifest path followed by .minisig or .sig)")
+	hashCheckKeygenCmd.Flags().StringVarP(&secretKeyOut, "secret-key", "s", "hashcheck.key", "Secret key file to create")
+	hashCheckKeygenCmd.Flags().StringVarP(&publicKeyOut, "public-key", "k", "hashcheck.pub", "Public key file to create")
+	hashCheckKeygenCmd.Flags().StringVar(&keyPassphrase, "key-passphrase", "", "Passphrase encrypting the secret key")
+	hashCheckKeygenCmd.Flags().BoolVar(&keygenForce, "force", false, "Overwrite existing key files")
+
+	hashCheckCmd.AddCommand(hashCheckUndoCmd)
+	hashCheckCmd.AddCommand(hashCheckGenerateCmd)
+	hashCheckCmd.AddCommand(hashCheckVerifyCmd)
+	hashCheckCmd.AddCommand(hashCheckKeygenCmd)
+	hashCheckWatchCmd.Flags().DurationVar(&watchDebounce, "debounce", 500*time.Millisecond, "How long a file must be quiet before it is re-hashed")
+
+	hashCheckCmd.AddCommand(hashCheckCompareCmd)
+	hashCheckCmd.AddCommand(hashCheckWatchCmd)
+	hashCheckCacheCmd.AddCommand(hashCheckCacheCompactCmd)
+	hashCheckCmd.AddCommand(hashCheckCacheCmd)
 	rootCmd.AddCommand(hashCheckCmd)
 }
@@ -8,19 +8,21 @@ import (
 )
 
 var (
-	httpAddress   string
-	httpPort      int
-	httpPaths     []string
-	httpUploadDir string
-	httplogFile   string
+	httpAddress           string
+	httpPort              int
+	httpPaths             []string
+	httpUploadDir         string
+	httplogFile           string
+	httpArchivePassphrase string
 )
 
 var httpServeCmd = &cobra.Command{
 	Use:   "httpserver",
 	Short: "Serve a list of paths over HTTP, defaulting to the current directory",
+	Long:  "Paths may also be .tar.zst archives created by backup, which are served as browsable listings.",
 	RunE: func(cmd *cobra.Command, args []string) error {
 		// Create a new HTTP server instance
-		server, err := http.NewServer(httpAddress, httpPort, httpPaths, httpUploadDir, httplogFile)
+		server, err := http.NewServer(httpAddress, httpPort, httpPaths, httpUploadDir, httplogFile, httpArchivePassphrase)
 		if err != nil {
 			return fmt.Errorf("error starting HTTP server: %v", err)
 		}
@@ -39,6 +41,7 @@ func init() {
 	httpServeCmd.Flags().StringSliceVarP(&httpPaths, "path", "P", []string{"."}, "Paths to serve")
 	httpServeCmd.Flags().StringVarP(&httpUploadDir, "upload-dir", "u", "", "Directory for file uploads")
 	httpServeCmd.Flags().StringVar(&httplogFile, "log", "", "Log file to write to")
+	httpServeCmd.Flags().StringVarP(&httpArchivePassphrase, "passphrase", "p", "", "Age passphrase for encrypted backup archives given as paths")
 
 	// Add the serve command to the root command
 	rootCmd.AddCommand(httpServeCmd)
@@ -0,0 +1,178 @@
+package cmd
+
+import (
+	"admin-cli/internal"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	otpHOTP       bool
+	otpIssuer     string
+	otpAccount    string
+	otpAlgorithm  string
+	otpDigits     int
+	otpPeriod     int
+	otpCounter    uint64
+	otpSecretSize int
+	otpNoQR       bool
+	otpInvertQR   bool
+	otpAt         string
+)
+
+var genPassOTPCmd = &cobra.Command{
+	Use:   "otp",
+	Short: "Provision TOTP/HOTP two-factor secrets and compute their codes",
+}
+
+var genPassOTPNewCmd = &cobra.Command{
+	Use:   "new",
+	Short: "Generate a TOTP or HOTP secret with its otpauth:// URI and QR code",
+	Long: `Generate a TOTP (or with --hotp an HOTP) secret.
+
+The secret is printed in base32 with an otpauth:// URI and a QR code of the URI, which
+authenticator apps can scan. The QR code is drawn for terminals with light text on a dark
+background; use --invert-qr on light terminals.`,
+	Args:          cobra.NoArgs,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		otpType := internal.OTPTypeTOTP
+		if otpHOTP {
+			otpType = internal.OTPTypeHOTP
+		}
+		if otpAccount == "" {
+			return fmt.Errorf("--account is required, it names the key in authenticator apps")
+		}
+		if strings.Contains(otpIssuer, ":") || strings.Contains(otpAccount, ":") {
+			return fmt.Errorf("issuer and account cannot contain a colon")
+		}
+		key, err := internal.NewOTPKey(otpType, otpSecretSize)
+		if err != nil {
+			return err
+		}
+		key.Issuer, key.Account = otpIssuer, otpAccount
+		key.Algorithm, key.Digits, key.Period, key.Counter = strings.ToUpper(otpAlgorithm), otpDigits, otpPeriod, otpCounter
+		if _, err := key.Code(time.Now()); err != nil {
+			return err // Invalid parameters
+		}
+
+		fmt.Println("Secret:", key.EncodedSecret())
+		fmt.Println("URI:", key.URI())
+		if !otpNoQR {
+			qr, err := internal.TerminalQRCode(key.URI(), otpInvertQR)
+			if err != nil {
+				return err
+			}
+			fmt.Print(qr)
+		}
+		return nil
+	},
+}
+
+var genPassOTPCodeCmd = &cobra.Command{
+	Use:   "code",
+	Short: "Compute the current TOTP or an HOTP code of a secret read from stdin",
+	Long: `Compute the TOTP code (RFC 6238) or with --hotp the HOTP code (RFC 4226) of a secret.
+
+The base32 secret or an otpauth:// URI is read from stdin, never from arguments; on a
+terminal it is read without echo. Parameters of a URI are used unless flags are given.`,
+	Args:          cobra.NoArgs,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		input, err := readPasswords(os.Stdin)
+		if err != nil {
+			return err
+		}
+		key, err := otpKeyFromInput(cmd, input[0])
+		if err != nil {
+			return err
+		}
+
+		at := time.Now()
+		if otpAt != "" {
+			if at, err = time.Parse(time.RFC3339, otpAt); err != nil {
+				return fmt.Errorf("invalid --at time, want RFC 3339 like 2024-05-01T12:00:00Z: %v", err)
+			}
+		}
+		code, err := key.Code(at)
+		if err != nil {
+			return err
+		}
+		if key.Type == internal.OTPTypeHOTP {
+			fmt.Printf("%s (counter %d)\n", code, key.Counter)
+		} else {
+			period := int64(key.Period)
+			fmt.Printf("%s (valid for %d more seconds)\n", code, period-at.Unix()%period)
+		}
+		return nil
+	},
+}
+
+// otpKeyFromInput reads a base32 secret or otpauth URI and applies the parameter flags given
+func otpKeyFromInput(cmd *cobra.Command, input string) (internal.OTPKey, error) {
+	var key internal.OTPKey
+	if strings.HasPrefix(strings.TrimSpace(input), "otpauth://") {
+		var err error
+		if key, err = internal.ParseOTPURI(input); err != nil {
+			return key, err
+		}
+	} else {
+		secret, err := internal.DecodeOTPSecret(input)
+		if err != nil {
+			return key, err
+		}
+		key = internal.OTPKey{
+			Type:      internal.OTPTypeTOTP,
+			Secret:    secret,
+			Algorithm: internal.DefaultOTPAlgorithm,
+			Digits:    internal.DefaultOTPDigits,
+			Period:    internal.DefaultOTPPeriod,
+		}
+	}
+
+	flags := cmd.Flags()
+	if flags.Changed("hotp") {
+		key.Type = internal.OTPTypeTOTP
+		if otpHOTP {
+			key.Type = internal.OTPTypeHOTP
+		}
+	}
+	if flags.Changed("algorithm") {
+		key.Algorithm = strings.ToUpper(otpAlgorithm)
+	}
+	if flags.Changed("digits") {
+		key.Digits = otpDigits
+	}
+	if flags.Changed("period") {
+		key.Period = otpPeriod
+	}
+	if flags.Changed("counter") {
+		key.Counter = otpCounter
+	}
+	return key, nil
+}
+
+func init() {
+	for _, c := range []*cobra.Command{genPassOTPNewCmd, genPassOTPCodeCmd} {
+		c.Flags().BoolVar(&otpHOTP, "hotp", false, "Counter based HOTP instead of time based TOTP")
+		c.Flags().StringVar(&otpAlgorithm, "algorithm", internal.DefaultOTPAlgorithm, "HMAC algorithm: SHA1, SHA256 or SHA512")
+		c.Flags().IntVar(&otpDigits, "digits", internal.DefaultOTPDigits, "Number of digits of the codes")
+		c.Flags().IntVar(&otpPeriod, "period", internal.DefaultOTPPeriod, "Seconds each TOTP code is valid for")
+		c.Flags().Uint64Var(&otpCounter, "counter", 0, "HOTP counter")
+	}
+	genPassOTPNewCmd.Flags().StringVar(&otpIssuer, "issuer", "", "Service the key is for, shown in authenticator apps")
+	genPassOTPNewCmd.Flags().StringVar(&otpAccount, "account", "", "Account the key is for, such as a user name or email address")
+	genPassOTPNewCmd.Flags().IntVar(&otpSecretSize, "secret-size", internal.DefaultOTPSecretSize, "Size of the secret in bytes")
+	genPassOTPNewCmd.Flags().BoolVar(&otpNoQR, "no-qr", false, "Do not print the QR code")
+	genPassOTPNewCmd.Flags().BoolVar(&otpInvertQR, "invert-qr", false, "Draw the QR code for terminals with dark text on a light background")
+	genPassOTPCodeCmd.Flags().StringVar(&otpAt, "at", "", "Compute the TOTP code for this RFC 3339 time instead of now")
+
+	genPassOTPCmd.AddCommand(genPassOTPNewCmd, genPassOTPCodeCmd)
+	genPassCmd.AddCommand(genPassOTPCmd)
+}
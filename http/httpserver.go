@@ -1,15 +1,20 @@
 package http
 
 import (
+	"admin-cli/internal"
+	"archive/tar"
 	"context"
 	"fmt"
 	"html/template"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"path"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 )
@@ -18,10 +23,12 @@ import (
 type Server struct {
 	httpServer *http.Server
 	logger     *log.Logger
+	dirs       []Dir // Served directories and archives, whose archive indexes are closed on shutdown
 }
 
 // NewServer initializes a new server with the given configuration.
-func NewServer(address string, port int, servePaths []string, uploadDir string, logFile string) (*Server, error) {
+// Serve paths may be directories or backup archives; archivePassphrase decrypts encrypted archives.
+func NewServer(address string, port int, servePaths []string, uploadDir string, logFile string, archivePassphrase string) (*Server, error) {
 	// Set up the logger.
 	logger, err := setupLogger(logFile)
 	if err != nil {
@@ -34,7 +41,7 @@ func NewServer(address string, port int, servePaths []string, uploadDir string,
 	// Configure directories to serve, if provided.
 	var dirs []Dir
 	if len(servePaths) > 0 {
-		dirs, err = setupDirs(servePaths, logger)
+		dirs, err = setupDirs(servePaths, archivePassphrase, logger)
 		if err != nil {
 			return nil, err
 		}
@@ -42,11 +49,23 @@ func NewServer(address string, port int, servePaths []string, uploadDir string,
 		// Parse the index template.
 		indexTmpl, err := parseIndexTemplate()
 		if err != nil {
+			closeArchives(dirs)
 			return nil, err
 		}
 
-		// Register file servers for each directory.
+		// Parse the archive listing template.
+		archiveTmpl, err := parseArchiveTemplate()
+		if err != nil {
+			closeArchives(dirs)
+			return nil, err
+		}
+
+		// Register file servers for each directory or archive.
 		for _, d := range dirs {
+			if d.Archive != nil {
+				registerArchiveServer(mux, d, archiveTmpl, logger)
+				continue
+			}
 			registerFileServer(mux, d, logger)
 		}
 
@@ -58,6 +77,7 @@ func NewServer(address string, port int, servePaths []string, uploadDir string,
 	if uploadDir != "" {
 		// Ensure the upload directory exists.
 		if err := os.MkdirAll(uploadDir, 0755); err != nil {
+			closeArchives(dirs)
 			return nil, fmt.Errorf("error creating upload directory: %v", err)
 		}
 		mux.HandleFunc("/upload", createUploadHandler(uploadDir, logger))
@@ -73,13 +93,14 @@ func NewServer(address string, port int, servePaths []string, uploadDir string,
 		Handler: handler,
 	}
 
-	return &Server{httpServer: httpSrv, logger: logger}, nil
+	return &Server{httpServer: httpSrv, logger: logger, dirs: dirs}, nil
 }
 
 // Dir represents a directory to be served.
 type Dir struct {
-	URL  string
-	Path string
+	URL     string
+	Path    string
+	Archive *internal.ArchiveIndex // Set when Path is a backup archive instead of a directory
 }
 
 // setupLogger configures a logger writing to a file or stdout.
@@ -104,8 +125,8 @@ func setupLogger(logFile string) (*log.Logger, error) {
 	return log.New(out, "", log.LstdFlags), nil
 }
 
-// setupDirs validates and prepares directories to serve.
-func setupDirs(paths []string, logger *log.Logger) ([]Dir, error) {
+// setupDirs validates and prepares directories and backup archives to serve.
+func setupDirs(paths []string, archivePassphrase string, logger *log.Logger) ([]Dir, error) {
 	var dirs []Dir
 	usedURLs := make(map[string]struct{})
 	for _, path := range paths {
@@ -115,11 +136,20 @@ func setupDirs(paths []string, logger *log.Logger) ([]Dir, error) {
 			continue
 		}
 		info, err := os.Stat(absPath)
-		if err != nil || !info.IsDir() {
+		if err != nil || (!info.IsDir() && !isArchivePath(absPath)) {
 			logger.Printf("Skipping invalid directory %s", absPath)
 			continue
 		}
 
+		var archive *internal.ArchiveIndex
+		if !info.IsDir() {
+			archive, err = internal.IndexArchive(absPath, archivePassphrase)
+			if err != nil {
+				logger.Printf("Skipping invalid archive %s: %v", absPath, err)
+				continue
+			}
+		}
+
 		base := filepath.Base(absPath)
 		urlPath := "/" + base + "/"
 		suffix := 0
@@ -131,11 +161,25 @@ func setupDirs(paths []string, logger *log.Logger) ([]Dir, error) {
 			urlPath = fmt.Sprintf("/%s-%d/", base, suffix)
 		}
 		usedURLs[urlPath] = struct{}{}
-		dirs = append(dirs, Dir{URL: urlPath, Path: absPath})
+		dirs = append(dirs, Dir{URL: urlPath, Path: absPath, Archive: archive})
 	}
 	return dirs, nil
 }
 
+// closeArchives releases the open streams of the backup archives among dirs.
+func closeArchives(dirs []Dir) {
+	for _, d := range dirs {
+		if d.Archive != nil {
+			d.Archive.Close()
+		}
+	}
+}
+
+// isArchivePath reports whether the path looks like an archive created by backup.
+func isArchivePath(path string) bool {
+	return strings.HasSuffix(path, ".tar.zst") || strings.HasSuffix(path, ".tzst")
+}
+
 // parseIndexTemplate creates and returns the index page template.
 func parseIndexTemplate() (*template.Template, error) {
 	tmpl, err := template.New("index").Parse(`
@@ -153,6 +197,100 @@ func parseIndexTemplate() (*template.Template, error) {
 	return tmpl, nil
 }
 
+// archiveListing is the data rendered by the archive listing template.
+type archiveListing struct {
+	Archive string
+	Dir     string
+	Entries []archiveListingEntry
+}
+
+// archiveListingEntry is a single row of an archive listing.
+type archiveListingEntry struct {
+	Name    string
+	Href    string
+	Size    int64
+	Mode    string
+	ModTime string
+}
+
+// parseArchiveTemplate creates and returns the archive listing template.
+func parseArchiveTemplate() (*template.Template, error) {
+	tmpl, err := template.New("archive").Parse(`
+        <html><body>
+        <h1>{{.Archive}}:/{{.Dir}}</h1>
+        <table>
+            <tr><th>Mode</th><th>Size</th><th>Modified</th><th>Name</th></tr>
+            <tr><td></td><td></td><td></td><td><a href="../">../</a></td></tr>
+            {{range .Entries}}
+            <tr><td>{{.Mode}}</td><td>{{.Size}}</td><td>{{.ModTime}}</td><td>{{if .Href}}<a href="{{.Href}}">{{.Name}}</a>{{else}}{{.Name}}{{end}}</td></tr>
+            {{end}}
+        </table>
+        </body></html>`)
+	if err != nil {
+		return nil, fmt.Errorf("error creating archive template: %v", err)
+	}
+	return tmpl, nil
+}
+
+// registerArchiveServer sets up a handler browsing and streaming the entries of a backup archive.
+func registerArchiveServer(mux *http.ServeMux, d Dir, tmpl *template.Template, logger *log.Logger) {
+	mux.Handle(d.URL, http.StripPrefix(d.URL, createArchiveHandler(d, tmpl, logger)))
+	logger.Printf("Serving archive %s at %s", d.Path, d.URL)
+}
+
+// createArchiveHandler returns a handler serving a listing for directories and the contents for files.
+func createArchiveHandler(d Dir, tmpl *template.Template, logger *log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entry, ok := d.Archive.Lookup(r.URL.Path)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		if !entry.IsDir() {
+			if entry.Header.Typeflag != tar.TypeReg {
+				http.Error(w, "Unsupported entry type", http.StatusNotFound)
+				return
+			}
+			http.ServeContent(w, r, path.Base(entry.Name), entry.Header.ModTime, d.Archive.Open(entry))
+			return
+		}
+
+		// Directories need a trailing slash for relative links to resolve
+		if r.URL.Path != "" && !strings.HasSuffix(r.URL.Path, "/") {
+			http.Redirect(w, r, path.Base(r.URL.Path)+"/", http.StatusMovedPermanently)
+			return
+		}
+
+		listing := archiveListing{Archive: filepath.Base(d.Path), Dir: entry.Name}
+		for _, e := range d.Archive.List(entry.Name) {
+			name := path.Base(e.Name)
+			item := archiveListingEntry{
+				Name:    name,
+				Size:    e.Header.Size,
+				Mode:    e.Header.FileInfo().Mode().String(),
+				ModTime: e.Header.ModTime.Format(time.RFC3339),
+			}
+			switch e.Header.Typeflag {
+			case tar.TypeDir:
+				item.Name += "/"
+				item.Href = (&url.URL{Path: name + "/"}).String()
+			case tar.TypeReg:
+				item.Href = (&url.URL{Path: name}).String()
+			case tar.TypeSymlink:
+				item.Name += " -> " + e.Header.Linkname
+			}
+			listing.Entries = append(listing.Entries, item)
+		}
+
+		w.Header().Set("Content-Type", "text/html")
+		if err := tmpl.Execute(w, listing); err != nil {
+			logger.Printf("Error executing template: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+	}
+}
+
 // registerFileServer sets up a file server for a directory.
 func registerFileServer(mux *http.ServeMux, d Dir, logger *log.Logger) {
 	fileServer := http.FileServer(http.Dir(d.Path))
@@ -273,5 +411,7 @@ func (s *Server) Start() error {
 	// Perform graceful shutdown.
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	return s.httpServer.Shutdown(ctx)
+	err := s.httpServer.Shutdown(ctx)
+	closeArchives(s.dirs)
+	return err
 }
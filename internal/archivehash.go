@@ -0,0 +1,310 @@
+package internal
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+)
+
+// ArchiveSeparator joins the path of an archive and the path of a file inside it,
+// e.g. "backup.tar.zst!/etc/hosts"
+const ArchiveSeparator = "!/"
+
+// hashArchiveSuffixes are the archive types whose contents can be hashed, optionally age encrypted
+var hashArchiveSuffixes = []string{".tar", ".tar.zst", ".tzst", ".tar.gz", ".tgz", ".zip"}
+
+// Magic numbers of the compression formats, detected instead of trusting the file name
+var (
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	gzipMagic = []byte{0x1f, 0x8b}
+	zipMagic  = []byte("PK\x03\x04")
+)
+
+// IsHashableArchive reports whether the file name looks like an archive whose contents can be hashed
+func IsHashableArchive(path string) bool {
+	name := strings.TrimSuffix(strings.ToLower(path), ".age")
+	for _, suffix := range hashArchiveSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// partialHashName is the name under which the partial hash of an algorithm is stored
+func partialHashName(algo string) string {
+	return algo + ":partial"
+}
+
+// ScanArchive hashes every regular file inside an archive in a single pass without extracting it.
+// Files are named with ArchiveSeparator and carry their full and partial hashes for each algorithm.
+// The walk options filter the files by their path inside the archive.
+func ScanArchive(archivePath, passphrase string, algos []string, opts WalkOptions) ([]ScannedFile, error) {
+	in, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	br := bufio.NewReader(in)
+	var r io.Reader = br
+	encrypted := IsAgeEncrypted(br)
+	if encrypted {
+		if passphrase == "" {
+			return nil, fmt.Errorf("archive %s is encrypted, a passphrase is required", archivePath)
+		}
+		if r, err = decryptReader(br, passphrase); err != nil {
+			return nil, fmt.Errorf("error decrypting %s: %v", archivePath, err)
+		}
+		br = bufio.NewReader(r)
+	}
+
+	w := &walker{opts: opts, root: archivePath}
+	head, _ := br.Peek(4)
+	switch {
+	case bytes.HasPrefix(head, zipMagic):
+		if !encrypted {
+			return w.scanZip(in, archivePath, algos)
+		}
+		// Zip needs random access, so the decrypted archive is spooled to a temporary file
+		tmp, err := os.CreateTemp("", "admin-cli-archive-*.zip")
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+		if _, err := io.Copy(tmp, br); err != nil {
+			return nil, fmt.Errorf("error decrypting %s: %v", archivePath, err)
+		}
+		return w.scanZip(tmp, archivePath, algos)
+
+	case bytes.HasPrefix(head, zstdMagic):
+		decoder, err := setupDecompressor(br)
+		if err != nil {
+			return nil, err
+		}
+		defer decoder.Close()
+		return w.scanTar(tar.NewReader(decoder), archivePath, algos)
+
+	case bytes.HasPrefix(head, gzipMagic):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		return w.scanTar(tar.NewReader(gz), archivePath, algos)
+
+	default:
+		return w.scanTar(tar.NewReader(br), archivePath, algos)
+	}
+}
+
+// scanTar hashes the regular files of a tar stream
+func (w *walker) scanTar(tr *tar.Reader, archivePath string, algos []string) ([]ScannedFile, error) {
+	var files []ScannedFile
+	seen := make(map[string]int) // Later entries replace earlier ones of the same name, as when extracting
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %v", archivePath, err)
+		}
+		name := cleanArchiveName(header.Name)
+		if !header.FileInfo().Mode().IsRegular() || !w.allowsMember(name, header.Size) {
+			continue
+		}
+		hashes, err := hashStream(tr, header.Size, algos)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s in %s: %v", name, archivePath, err)
+		}
+		file := ScannedFile{Path: archivePath + ArchiveSeparator + name, Size: header.Size, Hashes: hashes}
+		if i, ok := seen[name]; ok {
+			files[i] = file
+			continue
+		}
+		seen[name] = len(files)
+		files = append(files, file)
+	}
+}
+
+// scanZip hashes the regular files of a zip archive
+func (w *walker) scanZip(f *os.File, archivePath string, algos []string) ([]ScannedFile, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	zr, err := zip.NewReader(f, info.Size())
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", archivePath, err)
+	}
+
+	var files []ScannedFile
+	for _, zf := range zr.File {
+		name := cleanArchiveName(zf.Name)
+		size := int64(zf.UncompressedSize64)
+		if !zf.Mode().IsRegular() || !w.allowsMember(name, size) {
+			continue
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s in %s: %v", name, archivePath, err)
+		}
+		hashes, err := hashStream(rc, size, algos)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s in %s: %v", name, archivePath, err)
+		}
+		files = append(files, ScannedFile{Path: archivePath + ArchiveSeparator + name, Size: size, Hashes: hashes})
+	}
+	return files, nil
+}
+
+// allowsMember applies the walk options to a file inside an archive
+func (w *walker) allowsMember(name string, size int64) bool {
+	parts := strings.Split(name, "/")
+	if w.opts.MaxDepth >= 0 && len(parts)-1 > w.opts.MaxDepth {
+		return false
+	}
+	for i, part := range parts {
+		if w.opts.SkipHidden && strings.HasPrefix(part, ".") {
+			return false
+		}
+		// Excluding a directory excludes everything below it
+		if i < len(parts)-1 && w.excluded(w.root+ArchiveSeparator+strings.Join(parts[:i+1], "/")) {
+			return false
+		}
+	}
+	path := w.root + ArchiveSeparator + name
+	if w.excluded(path) || !w.included(path) {
+		return false
+	}
+	return size >= w.opts.MinSize && (w.opts.MaxSize <= 0 || size <= w.opts.MaxSize)
+}
+
+// hashStream reads size bytes and returns the full and partial hashes for every algorithm,
+// matching ComputeFileHashes and ComputePartialHash on an extracted copy
+func hashStream(r io.Reader, size int64, algos []string) (map[string]string, error) {
+	hashers := make([]hash.Hash, len(algos))
+	writers := make([]io.Writer, len(algos))
+	for i, algo := range algos {
+		hashers[i] = newHash(algo)
+		writers[i] = hashers[i]
+	}
+	ends := &endsWriter{size: size}
+	if _, err := io.CopyN(io.MultiWriter(append(writers, ends)...), r, size); err != nil {
+		return nil, err
+	}
+
+	hashes := make(map[string]string, 2*len(algos))
+	for i, algo := range algos {
+		hashes[algo] = hex.EncodeToString(hashers[i].Sum(nil))
+		if size <= 2*partialHashSize {
+			hashes[partialHashName(algo)] = hashes[algo]
+		} else {
+			h := newHash(algo)
+			h.Write(ends.buf)
+			hashes[partialHashName(algo)] = hex.EncodeToString(h.Sum(nil))
+		}
+	}
+	return hashes, nil
+}
+
+// endsWriter keeps the first and last partialHashSize bytes of a stream of known size
+type endsWriter struct {
+	size int64
+	off  int64
+	buf  []byte
+}
+
+func (e *endsWriter) Write(p []byte) (int, error) {
+	start, end := e.off, e.off+int64(len(p))
+	if start < partialHashSize {
+		e.buf = append(e.buf, p[:min(end, partialHashSize)-start]...)
+	}
+	// Only used for streams longer than both ends, so the ranges never overlap
+	if tail := max(e.size-partialHashSize, partialHashSize); end > tail {
+		e.buf = append(e.buf, p[max(start, tail)-start:]...)
+	}
+	e.off = end
+	return len(p), nil
+}
+
+// fileHasher hashes scanned files, using the hashes computed while scanning archives
+// and falling back to the cache for files on disk
+type fileHasher struct {
+	cache    *HashCache
+	scanned  map[string]map[string]string
+	archives []string // Archives whose files only exist in scanned
+}
+
+// newFileHasher collects the hashes computed while scanning
+func newFileHasher(files []ScannedFile, cache *HashCache) *fileHasher {
+	h := &fileHasher{cache: cache, scanned: make(map[string]map[string]string)}
+	for _, f := range files {
+		if f.Hashes != nil {
+			h.scanned[f.Path] = f.Hashes
+		}
+	}
+	return h
+}
+
+// fileHashes returns the hashes of a file, see HashCache.FileHashes
+func (h *fileHasher) fileHashes(path string, algos []string) (map[string]string, error) {
+	known, ok := h.scanned[path]
+	if !ok {
+		if h.inArchive(path) {
+			return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+		}
+		return h.cache.FileHashes(path, algos)
+	}
+	hashes := make(map[string]string, len(algos))
+	for _, algo := range algos {
+		if hashes[algo] = known[algo]; hashes[algo] == "" {
+			return nil, fmt.Errorf("no %s hash was computed while reading the archive", algo)
+		}
+	}
+	return hashes, nil
+}
+
+// fileHash returns a single hash of a file
+func (h *fileHasher) fileHash(path, algo string) (string, error) {
+	hashes, err := h.fileHashes(path, []string{algo})
+	if err != nil {
+		return "", err
+	}
+	return hashes[algo], nil
+}
+
+// partialHash returns the partial hash of a file, see ComputePartialHash
+func (h *fileHasher) partialHash(path string, size int64, algo string) (string, error) {
+	if known, ok := h.scanned[path]; ok {
+		return known[partialHashName(algo)], nil
+	}
+	return h.cache.PartialHash(path, size, algo)
+}
+
+// inArchive reports whether a path names a file inside one of the archives
+func (h *fileHasher) inArchive(path string) bool {
+	for _, a := range h.archives {
+		if strings.HasPrefix(path, a+ArchiveSeparator) {
+			return true
+		}
+	}
+	return false
+}
+
+// isArchiveRoot reports whether root is an archive file rather than a directory
+func isArchiveRoot(root string) bool {
+	info, err := os.Stat(root)
+	return err == nil && info.Mode().IsRegular() && IsHashableArchive(root)
+}
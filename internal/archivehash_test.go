@@ -0,0 +1,154 @@
+package internal
+
+import (
+	"archive/zip"
+	"bytes"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeHashTree creates a small tree with one file larger than both partial hash ends
+func writeHashTree(t *testing.T) string {
+	t.Helper()
+	srcDir := t.TempDir()
+	large := make([]byte, 3*partialHashSize+123)
+	rand.New(rand.NewSource(1)).Read(large)
+	files := map[string][]byte{
+		"small.txt":     []byte("hello"),
+		"dir/large.bin": large,
+		"dir/empty":     nil,
+	}
+	for name, content := range files {
+		path := filepath.Join(srcDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+	return srcDir
+}
+
+// writeTestZip stores the files of a directory in a zip archive
+func writeTestZip(t *testing.T, srcDir, archive string) {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	err := filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(srcDir, path)
+		w, err := zw.Create(filepath.ToSlash(rel))
+		if err != nil {
+			return err
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(content)
+		return err
+	})
+	if err == nil {
+		err = zw.Close()
+	}
+	if err == nil {
+		err = os.WriteFile(archive, buf.Bytes(), 0644)
+	}
+	if err != nil {
+		t.Fatalf("Failed to write zip archive: %v", err)
+	}
+}
+
+// TestScanArchive checks that files hashed inside zip and encrypted tar.zst archives
+// get the same hashes as the files on disk
+func TestScanArchive(t *testing.T) {
+	srcDir := writeHashTree(t)
+	outDir := t.TempDir()
+	zipPath := filepath.Join(outDir, "files.zip")
+	writeTestZip(t, srcDir, zipPath)
+	agePath := filepath.Join(outDir, "backup.tar.zst.age")
+	if _, err := Backup(srcDir, agePath, 3, false, "secret", nil, ChangeFail, ""); err != nil {
+		t.Fatalf("Backup() err = %v; want nil", err)
+	}
+
+	algos := []string{"sha256", "xxhash"}
+	opts := WalkOptions{MaxDepth: -1}
+	for _, archive := range []string{zipPath, agePath} {
+		files, err := ScanArchive(archive, "secret", algos, opts)
+		if err != nil {
+			t.Fatalf("ScanArchive(%s) err = %v; want nil", archive, err)
+		}
+		if len(files) != 3 {
+			t.Fatalf("ScanArchive(%s) found %d files; want 3", archive, len(files))
+		}
+		for _, f := range files {
+			name := strings.TrimPrefix(f.Path, archive+ArchiveSeparator)
+			path := filepath.Join(srcDir, filepath.FromSlash(name))
+			want, err := ComputeFileHashes(path, algos)
+			if err != nil {
+				t.Fatalf("ComputeFileHashes(%s) err = %v; want nil", path, err)
+			}
+			for _, algo := range algos {
+				if f.Hashes[algo] != want[algo] {
+					t.Errorf("%s %s hash = %s; want %s", f.Path, algo, f.Hashes[algo], want[algo])
+				}
+				partial, err := ComputePartialHash(path, f.Size, algo)
+				if err != nil {
+					t.Fatalf("ComputePartialHash(%s) err = %v; want nil", path, err)
+				}
+				if got := f.Hashes[partialHashName(algo)]; got != partial {
+					t.Errorf("%s %s partial hash = %s; want %s", f.Path, algo, got, partial)
+				}
+			}
+		}
+	}
+
+	if _, err := ScanArchive(agePath, "", algos, opts); err == nil {
+		t.Errorf("ScanArchive() without passphrase err = nil; want error")
+	}
+	files, err := ScanArchive(zipPath, "", algos, WalkOptions{MaxDepth: -1, Exclude: []string{"dir"}})
+	if err != nil || len(files) != 1 {
+		t.Errorf("ScanArchive() excluding dir = %v, %v; want only small.txt", files, err)
+	}
+}
+
+// TestVerifyManifestArchive checks a manifest of a directory against an archive of it
+func TestVerifyManifestArchive(t *testing.T) {
+	srcDir := writeHashTree(t)
+	zipPath := filepath.Join(t.TempDir(), "files.zip")
+	writeTestZip(t, srcDir, zipPath)
+	srcFiles, _, err := WalkFiles(srcDir, WalkOptions{MaxDepth: -1})
+	if err != nil {
+		t.Fatalf("WalkFiles() err = %v; want nil", err)
+	}
+	m, errs := GenerateManifest(srcDir, srcFiles, 2, []string{"sha256"}, nil)
+	if len(errs) != 0 {
+		t.Fatalf("GenerateManifest() errs = %v; want none", errs)
+	}
+	m.Files = append(m.Files, ManifestEntry{Path: "gone", Hashes: map[string]string{"sha256": strings.Repeat("0", 64)}})
+
+	files, err := ScanArchive(zipPath, "", m.Algorithms, WalkOptions{MaxDepth: -1})
+	if err != nil {
+		t.Fatalf("ScanArchive() err = %v; want nil", err)
+	}
+	got := make(map[string]VerifyStatus)
+	for _, r := range VerifyManifest(zipPath, m, files, 2, nil) {
+		got[r.Path] = r.Status
+	}
+	want := map[string]VerifyStatus{
+		"small.txt":     StatusOK,
+		"dir/large.bin": StatusOK,
+		"dir/empty":     StatusOK,
+		"gone":          StatusMissing,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("VerifyManifest() = %v; want %v", got, want)
+	}
+}
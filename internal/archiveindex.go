@@ -0,0 +1,373 @@
+package internal
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ageHeader is the first line of every age-encrypted file.
+var ageHeader = []byte("age-encryption.org/v1\n")
+
+// maxArchiveCursors limits how many open decompression streams an index keeps around.
+const maxArchiveCursors = 4
+
+// ArchiveEntry describes a single member of a backup archive.
+type ArchiveEntry struct {
+	Name    string      // Cleaned, slash separated path inside the archive ("" for the root)
+	Header  *tar.Header // Original tar header (synthesized for implicit directories)
+	ordinal int         // Position of the entry in the tar stream, -1 if synthesized
+}
+
+// IsDir reports whether the entry is a directory.
+func (e *ArchiveEntry) IsDir() bool {
+	return e.Header.Typeflag == tar.TypeDir
+}
+
+// ArchiveIndex maps the members of a backup archive to their position in the tar stream,
+// so that single entries can be read without decompressing the whole archive each time.
+type ArchiveIndex struct {
+	Path       string
+	passphrase string
+	entries    map[string]*ArchiveEntry
+	children   map[string][]*ArchiveEntry
+
+	mu      sync.Mutex
+	cursors []*archiveCursor
+	closed  bool // Set by Close, cursors still in use are closed when they are released
+}
+
+// archiveCursor is an open decompression stream positioned somewhere inside the archive.
+type archiveCursor struct {
+	closers []io.Closer
+	tr      *tar.Reader
+	ordinal int   // Ordinal of the current tar entry, -1 before the first one
+	pos     int64 // Read position inside the current entry
+}
+
+// IndexArchive reads the archive once and records every entry it contains.
+// The passphrase is only used when the archive is age-encrypted.
+func IndexArchive(archivePath, passphrase string) (*ArchiveIndex, error) {
+	idx := &ArchiveIndex{
+		Path:       archivePath,
+		passphrase: passphrase,
+		entries:    make(map[string]*ArchiveEntry),
+		children:   make(map[string][]*ArchiveEntry),
+	}
+
+	cur, err := idx.openCursor()
+	if err != nil {
+		return nil, err
+	}
+	defer cur.close()
+
+	root := &ArchiveEntry{Header: &tar.Header{Typeflag: tar.TypeDir, Mode: 0755}, ordinal: -1}
+	idx.entries[""] = root
+
+	for {
+		header, err := cur.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error indexing archive %s: %v", archivePath, err)
+		}
+		name := cleanArchiveName(header.Name)
+		if name == "" {
+			// The walk root is stored as "."; keep its metadata for the mount point
+			if header.Typeflag == tar.TypeDir {
+				root.Header = header
+			}
+			continue
+		}
+		idx.add(&ArchiveEntry{Name: name, Header: header, ordinal: cur.ordinal})
+	}
+
+	for _, list := range idx.children {
+		sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	}
+	idx.setImplicitModTimes(root)
+	return idx, nil
+}
+
+// setImplicitModTimes gives directories missing from the archive the modification time of
+// their newest child, so that listings are the same on every mount. It returns the
+// modification time of dir.
+func (idx *ArchiveIndex) setImplicitModTimes(dir *ArchiveEntry) time.Time {
+	var newest time.Time
+	for _, e := range idx.children[dir.Name] {
+		modTime := e.Header.ModTime
+		if e.IsDir() {
+			modTime = idx.setImplicitModTimes(e)
+		}
+		if modTime.After(newest) {
+			newest = modTime
+		}
+	}
+	if dir.Header.ModTime.IsZero() {
+		dir.Header.ModTime = newest
+	}
+	return dir.Header.ModTime
+}
+
+// add records an entry and synthesizes any parent directories missing from the archive.
+func (idx *ArchiveIndex) add(e *ArchiveEntry) {
+	if existing, ok := idx.entries[e.Name]; ok {
+		// Later entries win, as they would when extracting
+		*existing = *e
+		return
+	}
+	idx.entries[e.Name] = e
+
+	parent := path.Dir(e.Name)
+	if parent == "." {
+		parent = ""
+	}
+	if _, ok := idx.entries[parent]; !ok {
+		idx.add(&ArchiveEntry{
+			Name:    parent,
+			Header:  &tar.Header{Name: parent, Typeflag: tar.TypeDir, Mode: 0755}, // ModTime set after indexing
+			ordinal: -1,
+		})
+	}
+	idx.children[parent] = append(idx.children[parent], e)
+}
+
+// cleanArchiveName normalizes a tar header name to a relative slash separated path.
+func cleanArchiveName(name string) string {
+	name = strings.TrimLeft(path.Clean("/"+name), "/")
+	if name == "." {
+		return ""
+	}
+	return name
+}
+
+// Lookup returns the entry stored under name.
+func (idx *ArchiveIndex) Lookup(name string) (*ArchiveEntry, bool) {
+	e, ok := idx.entries[cleanArchiveName(name)]
+	return e, ok
+}
+
+// List returns the direct children of the directory dir, sorted by name.
+func (idx *ArchiveIndex) List(dir string) []*ArchiveEntry {
+	return idx.children[cleanArchiveName(dir)]
+}
+
+// Walk calls fn for every entry in the archive, parents before their children.
+func (idx *ArchiveIndex) Walk(fn func(e *ArchiveEntry) error) error {
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		for _, e := range idx.children[dir] {
+			if err := fn(e); err != nil {
+				return err
+			}
+			if e.IsDir() {
+				if err := walk(e.Name); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return walk("")
+}
+
+// Open returns a reader for the contents of a regular file entry.
+func (idx *ArchiveIndex) Open(e *ArchiveEntry) *io.SectionReader {
+	return io.NewSectionReader(entryReaderAt{idx: idx, entry: e}, 0, e.Header.Size)
+}
+
+// entryReaderAt adapts ArchiveIndex.ReadAt to io.ReaderAt for a single entry.
+type entryReaderAt struct {
+	idx   *ArchiveIndex
+	entry *ArchiveEntry
+}
+
+func (r entryReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	return r.idx.ReadAt(r.entry, p, off)
+}
+
+// ReadAt reads len(p) bytes of the entry's contents starting at off.
+// Sequential reads reuse an already positioned stream instead of starting over.
+func (idx *ArchiveIndex) ReadAt(e *ArchiveEntry, p []byte, off int64) (int, error) {
+	if e.ordinal < 0 || e.Header.Typeflag != tar.TypeReg {
+		return 0, fmt.Errorf("%s is not a regular file", e.Name)
+	}
+	if off >= e.Header.Size {
+		return 0, io.EOF
+	}
+
+	cur, err := idx.acquireCursor(e.ordinal, off)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := cur.readAt(e.ordinal, p, off)
+	if err != nil && err != io.EOF {
+		// The stream is in an unknown state, don't reuse it
+		cur.close()
+		return n, err
+	}
+	idx.releaseCursor(cur)
+	return n, err
+}
+
+// acquireCursor takes the cursor closest to (but not past) the requested position,
+// or opens a new one at the start of the archive.
+func (idx *ArchiveIndex) acquireCursor(ordinal int, off int64) (*archiveCursor, error) {
+	idx.mu.Lock()
+	best := -1
+	for i, c := range idx.cursors {
+		if c.ordinal > ordinal || (c.ordinal == ordinal && c.pos > off) {
+			continue
+		}
+		if best < 0 || c.ordinal > idx.cursors[best].ordinal ||
+			(c.ordinal == idx.cursors[best].ordinal && c.pos > idx.cursors[best].pos) {
+			best = i
+		}
+	}
+	if best >= 0 {
+		cur := idx.cursors[best]
+		idx.cursors = append(idx.cursors[:best], idx.cursors[best+1:]...)
+		idx.mu.Unlock()
+		return cur, nil
+	}
+	idx.mu.Unlock()
+	return idx.openCursor()
+}
+
+// releaseCursor hands a cursor back to the pool, closing the oldest one if the pool is full.
+func (idx *ArchiveIndex) releaseCursor(cur *archiveCursor) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.closed {
+		cur.close()
+		return
+	}
+	idx.cursors = append(idx.cursors, cur)
+	if len(idx.cursors) > maxArchiveCursors {
+		idx.cursors[0].close()
+		idx.cursors = idx.cursors[1:]
+	}
+}
+
+// Close releases all open decompression streams. Reads still in progress close their
+// stream when they finish.
+func (idx *ArchiveIndex) Close() error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.closed = true
+	for _, c := range idx.cursors {
+		c.close()
+	}
+	idx.cursors = nil
+	return nil
+}
+
+// openCursor opens a fresh stream positioned before the first tar entry.
+func (idx *ArchiveIndex) openCursor() (*archiveCursor, error) {
+	r, closers, err := OpenArchiveStream(idx.Path, idx.passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return &archiveCursor{closers: closers, tr: setupTarReader(r), ordinal: -1}, nil
+}
+
+// next advances the cursor to the following tar entry.
+func (c *archiveCursor) next() (*tar.Header, error) {
+	header, err := c.tr.Next()
+	if err != nil {
+		return nil, err
+	}
+	c.ordinal++
+	c.pos = 0
+	return header, nil
+}
+
+// readAt moves the cursor forward to the given entry and offset and reads from there.
+func (c *archiveCursor) readAt(ordinal int, p []byte, off int64) (int, error) {
+	for c.ordinal < ordinal {
+		if _, err := c.next(); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+	}
+	if off > c.pos {
+		skipped, err := io.CopyN(io.Discard, c.tr, off-c.pos)
+		c.pos += skipped
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := io.ReadFull(c.tr, p)
+	c.pos += int64(n)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// close releases the file and decoder backing the cursor.
+func (c *archiveCursor) close() {
+	for i := len(c.closers) - 1; i >= 0; i-- {
+		c.closers[i].Close()
+	}
+}
+
+// OpenArchiveStream opens a backup archive and returns the decompressed tar stream.
+// Age encryption is detected from the file header; the returned closers must be
+// closed by the caller in reverse order.
+func OpenArchiveStream(archivePath, passphrase string) (io.Reader, []io.Closer, error) {
+	in, err := openSourceFile(archivePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	br := bufio.NewReader(in)
+	var reader io.Reader = br
+	if IsAgeEncrypted(br) {
+		if passphrase == "" {
+			in.Close()
+			return nil, nil, fmt.Errorf("archive %s is encrypted, a passphrase is required", archivePath)
+		}
+		reader, err = decryptReader(br, passphrase)
+		if err != nil {
+			in.Close()
+			return nil, nil, err
+		}
+	}
+
+	decoder, err := setupDecompressor(reader)
+	if err != nil {
+		in.Close()
+		return nil, nil, err
+	}
+	return decoder, []io.Closer{in, decoderCloser{decoder}}, nil
+}
+
+// IsAgeEncrypted reports whether the buffered stream starts with an age header.
+func IsAgeEncrypted(br *bufio.Reader) bool {
+	head, _ := br.Peek(len(ageHeader))
+	return bytes.Equal(head, ageHeader)
+}
+
+// decoderCloser adapts zstd.Decoder, whose Close has no return value, to io.Closer.
+type decoderCloser struct {
+	d *zstd.Decoder
+}
+
+func (c decoderCloser) Close() error {
+	c.d.Close()
+	return nil
+}
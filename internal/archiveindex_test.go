@@ -0,0 +1,117 @@
+package internal
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// TestArchiveIndex checks that entries of an encrypted backup can be listed and read back
+func TestArchiveIndex(t *testing.T) {
+	srcDir := t.TempDir()
+	big := bytes.Repeat([]byte("0123456789abcdef"), 64*1024) // 1 MiB, larger than a single read
+	files := map[string][]byte{
+		"a.txt":          []byte("first file"),
+		"sub/b.txt":      []byte("second file"),
+		"sub/deep/c.bin": big,
+	}
+	for name, content := range files {
+		path := filepath.Join(srcDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	archive := filepath.Join(t.TempDir(), "backup.tar.zst")
+	if _, err := Backup(srcDir, archive, 3, false, "secret", nil, ChangeFail, ""); err != nil {
+		t.Fatalf("Backup() err = %v; want nil", err)
+	}
+
+	if _, err := IndexArchive(archive, ""); err == nil {
+		t.Error("IndexArchive() without passphrase err = nil; want error for encrypted archive")
+	}
+
+	idx, err := IndexArchive(archive, "secret")
+	if err != nil {
+		t.Fatalf("IndexArchive() err = %v; want nil", err)
+	}
+	defer idx.Close()
+
+	if got := len(idx.List("sub")); got != 2 {
+		t.Errorf("List(sub) returned %d entries; want 2", got)
+	}
+
+	for name, content := range files {
+		e, ok := idx.Lookup(name)
+		if !ok {
+			t.Fatalf("Lookup(%s) not found", name)
+		}
+		got, err := io.ReadAll(idx.Open(e))
+		if err != nil {
+			t.Fatalf("reading %s: %v", name, err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Errorf("contents of %s do not match the original", name)
+		}
+	}
+
+	// Reading backwards inside an entry must rewind to a fresh stream
+	e, _ := idx.Lookup("sub/deep/c.bin")
+	buf := make([]byte, 16)
+	for _, off := range []int64{512 * 1024, 16, 1024*1024 - 16} {
+		if _, err := idx.ReadAt(e, buf, off); err != nil {
+			t.Fatalf("ReadAt(%d) err = %v; want nil", off, err)
+		}
+		if !bytes.Equal(buf, big[off:off+16]) {
+			t.Errorf("ReadAt(%d) = %q; want %q", off, buf, big[off:off+16])
+		}
+	}
+}
+
+// TestArchiveIndexImplicitDirs checks that directories missing from an archive take the
+// modification time of their newest child
+func TestArchiveIndexImplicitDirs(t *testing.T) {
+	older := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	newer := older.Add(time.Hour)
+
+	archive := filepath.Join(t.TempDir(), "implicit.tar.zst")
+	f, err := os.Create(archive)
+	if err != nil {
+		t.Fatalf("Failed to create archive: %v", err)
+	}
+	zw, _ := zstd.NewWriter(f)
+	tw := tar.NewWriter(zw)
+	for _, file := range []struct {
+		name    string
+		modTime time.Time
+	}{{"x/y/old.txt", older}, {"x/y/new.txt", newer}, {"x/z.txt", older}} {
+		tw.WriteHeader(&tar.Header{Name: file.name, Typeflag: tar.TypeReg, Mode: 0644, ModTime: file.modTime})
+	}
+	tw.Close()
+	zw.Close()
+	f.Close()
+
+	idx, err := IndexArchive(archive, "")
+	if err != nil {
+		t.Fatalf("IndexArchive() err = %v; want nil", err)
+	}
+	defer idx.Close()
+	for _, name := range []string{"", "x", "x/y"} {
+		e, ok := idx.Lookup(name)
+		if !ok {
+			t.Fatalf("Lookup(%q) not found", name)
+		}
+		if !e.IsDir() || !e.Header.ModTime.Equal(newer) {
+			t.Errorf("Lookup(%q) modification time = %v; want %v", name, e.Header.ModTime, newer)
+		}
+	}
+}
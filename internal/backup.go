@@ -11,7 +11,24 @@ import (
 )
 
 // Backup creates a compressed and optionally encrypted tar archive of the source path.
-func Backup(srcPath, destFile string, compLevel int, followSymlinks bool, passphrase string) error {
+// A non-nil throttle limits the read/write rates and system load caused by the backup.
+// onChange decides what happens to files modified while they are read; every such file
+// is returned, even when the backup fails. Private copies taken by the snapshot and retry
+// policies are written to snapshotDir, or to the default temporary directory if it is empty.
+func Backup(srcPath, destFile string, compLevel int, followSymlinks bool, passphrase string, throttle *Throttle, onChange ChangePolicy, snapshotDir string) ([]ChangedFile, error) {
+	a := &archiver{throttle: throttle, onChange: onChange, snapshotDir: snapshotDir}
+	err := a.backup(srcPath, destFile, compLevel, followSymlinks, passphrase)
+	return a.changed, err
+}
+
+// backup writes the archive; it is split from Backup so the change report survives early returns.
+func (a *archiver) backup(srcPath, destFile string, compLevel int, followSymlinks bool, passphrase string) error {
+	throttle := a.throttle
+
+	// Lower the process priority before doing any I/O
+	if err := throttle.apply(); err != nil {
+		return err
+	}
 
 	// Create the destination file
 	out, err := CreateDestinationFile(destFile)
@@ -20,11 +37,14 @@ func Backup(srcPath, destFile string, compLevel int, followSymlinks bool, passph
 	}
 	defer out.Close()
 
+	// Limit the rate at which the archive is written
+	archiveWriter := throttle.writer(out)
+
 	// Setup the writer for the compressor based on encryption
 	var compressorWriter io.Writer
 	if passphrase != "" {
 		// chain compressorWriter to the encoder io.Writer to create encrypted destination file
-		encryptor, err := setupEncryptor(out, passphrase)
+		encryptor, err := setupEncryptor(archiveWriter, passphrase)
 		if err != nil {
 			return err
 		}
@@ -32,7 +52,7 @@ func Backup(srcPath, destFile string, compLevel int, followSymlinks bool, passph
 		compressorWriter = encryptor
 	} else {
 		// output encoder to create destination file
-		compressorWriter = out
+		compressorWriter = archiveWriter
 	}
 
 	// Setup the zstd compressor
@@ -55,17 +75,34 @@ func Backup(srcPath, destFile string, compLevel int, followSymlinks bool, passph
 		return err
 	}
 
+	a.tw, a.raw, a.statFunc, a.basePath = tw, encoder, statFunc, srcPath
+
 	// Recursively walk directories and archive files
 	if fi.IsDir() {
 		return filepath.Walk(srcPath, func(file string, _ os.FileInfo, err error) error {
 			if err != nil {
 				return err
 			}
-			return archiveFile(tw, file, statFunc, srcPath)
+			// Back off before each file while the system is overloaded
+			throttle.waitForLoad()
+			return a.archiveFile(file)
 		})
 	}
 
-	return archiveFile(tw, srcPath, statFunc, filepath.Dir(srcPath))
+	a.basePath = filepath.Dir(srcPath)
+	return a.archiveFile(srcPath)
+}
+
+// archiver holds the state shared by every file added to a backup archive.
+type archiver struct {
+	tw          *tar.Writer
+	raw         io.Writer // Stream underneath tw, for entries archive/tar cannot encode itself
+	statFunc    func(name string) (os.FileInfo, error)
+	basePath    string
+	throttle    *Throttle
+	onChange    ChangePolicy
+	snapshotDir string // Where private copies of files are taken
+	changed     []ChangedFile
 }
 
 // CreateDestinationFile creates the destination file for the backup.
@@ -92,9 +129,9 @@ func getStatFunc(followSymlinks bool) func(name string) (os.FileInfo, error) {
 }
 
 // archiveFile adds a file to the tar archive.
-func archiveFile(tw *tar.Writer, file string, statFunc func(name string) (os.FileInfo, error), basePath string) error {
+func (a *archiver) archiveFile(file string) error {
 	// Get file info to store in the tar header Metadata(permissions, filetype, filename, relative path)
-	fileInfo, err := statFunc(file)
+	fileInfo, err := a.statFunc(file)
 	if err != nil {
 		return err
 	}
@@ -106,22 +143,40 @@ func archiveFile(tw *tar.Writer, file string, statFunc func(name string) (os.Fil
 	}
 
 	// Compute relative path for the header name
-	relPath, err := filepath.Rel(basePath, file)
+	relPath, err := filepath.Rel(a.basePath, file)
 	if err != nil {
 		return err
 	}
 	header.Name = relPath
 
-	// Write the header to the tar archive
-	if err := tw.WriteHeader(header); err != nil {
-		return err
-	}
-
 	// Skip copying content from non-regular files (e.g, directories, symlinks)
 	if !fileInfo.Mode().IsRegular() {
-		return nil
+		return a.tw.WriteHeader(header)
+	}
+
+	// The snapshot policy archives a private copy instead of the file itself
+	if a.onChange == ChangeSnapshot {
+		return a.archiveSnapshot(header, file)
 	}
 
+	err = a.archiveContents(header, file, a.throttle)
+	if err != nil && err != errFileShrank {
+		return err
+	}
+	reason := a.changeReason(file, fileInfo)
+	if reason == "" && err == errFileShrank {
+		reason = "file shrank while being read"
+	}
+	if reason != "" {
+		return a.handleChange(header, file, reason)
+	}
+	return nil
+}
+
+// archiveContents writes the header and the contents of a regular file to the tar archive.
+// The number of bytes copied is taken from the header, so a file that grows is cut off
+// and one that shrinks returns errFileShrank.
+func (a *archiver) archiveContents(header *tar.Header, file string, throttle *Throttle) error {
 	// Open the file and copy its contents to the tar writer
 	f, err := os.Open(file)
 	if err != nil {
@@ -129,8 +184,21 @@ func archiveFile(tw *tar.Writer, file string, statFunc func(name string) (os.Fil
 	}
 	defer f.Close()
 
-	_, err = io.Copy(tw, f)
-	return err
+	// Files with holes only store their data segments
+	segments, err := sparseSegments(f, header.Size)
+	if err != nil {
+		return err
+	}
+	if isSparse(segments, header.Size) {
+		return a.archiveSparseFile(header, f, segments, throttle)
+	}
+
+	// Write the header to the tar archive
+	if err := a.tw.WriteHeader(header); err != nil {
+		return err
+	}
+
+	return copyContents(a.tw, throttle.reader(f), header.Size)
 }
 
 // setupEncryptor creates an Age encryptor with a given passphrase.
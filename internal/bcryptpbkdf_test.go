@@ -0,0 +1,29 @@
+package internal
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// TestBcryptPBKDF checks keys against vectors of the OpenBSD reference implementation
+func TestBcryptPBKDF(t *testing.T) {
+	tests := []struct {
+		password, salt string
+		rounds         int
+		want           string
+	}{
+		{"password", "salt", 12, "1ae42c05d487bc02f64921a4ebe4ea93bcacfe135fda99974c06b7b01fae149a"},
+		{"passwordy\x00PASSWORD\x00", "salty\x00SALT\x00", 3, "7f310bd3e78c3280c59ce4595211a2928e8d4ec744c1ed2efc9f764e3388e0ad"},
+		{"секретное слово", "посолить немножко", 8, "8df43fc6fe131fc47f0c9e39224bd94c70b6fcc8ee8135faddf61156e6cb2733ea765f315a3e1e4afc35bf8687d189254c1e05a6fe80c0617f9183d67260d6a115c6c94e3603e2303fbb43a76a64523ffda686b1d4518543"},
+	}
+	for _, tt := range tests {
+		want, _ := hex.DecodeString(tt.want)
+		got, err := bcryptPBKDF([]byte(tt.password), []byte(tt.salt), tt.rounds, len(want))
+		if err != nil {
+			t.Fatalf("bcryptPBKDF(%q) err = %v; want nil", tt.password, err)
+		}
+		if hex.EncodeToString(got) != tt.want {
+			t.Errorf("bcryptPBKDF(%q, %q, %d) = %x; want %s", tt.password, tt.salt, tt.rounds, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+// breachChunk is how much is read around each probe of the binary search. HIBP lines are
+// a 40 digit hash, a colon and a count, well below this.
+const breachChunk = 256
+
+// BreachDB looks up passwords in a local copy of the Have I Been Pwned Pwned Passwords
+// SHA-1 list: lines of "HASH:COUNT" sorted by hash, as downloaded by the "ordered by hash"
+// link or the haveibeenpwned-downloader tool. The file is searched in place, so lookups
+// take a few dozen reads even for the full list of tens of gigabytes.
+type BreachDB struct {
+	file *os.File
+	size int64
+}
+
+// OpenBreachDB opens a Pwned Passwords SHA-1 file and checks its first line
+func OpenBreachDB(path string) (*BreachDB, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	db := &BreachDB{file: file, size: info.Size()}
+
+	first, err := db.lineAt(0)
+	if err == nil && first == nil {
+		err = fmt.Errorf("file is empty")
+	}
+	if err == nil {
+		_, _, err = parseBreachLine(first)
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s is not a Pwned Passwords SHA-1 file: %v", path, err)
+	}
+	return db, nil
+}
+
+// Close closes the file
+func (db *BreachDB) Close() error {
+	return db.file.Close()
+}
+
+// Lookup returns how often the password was seen in breaches, 0 if it never was
+func (db *BreachDB) Lookup(password string) (int, error) {
+	sum := sha1.Sum([]byte(password))
+	return db.LookupHash(hex.EncodeToString(sum[:]))
+}
+
+// LookupHash returns the breach count of a hex encoded SHA-1 hash, 0 if it is not listed
+func (db *BreachDB) LookupHash(hash string) (int, error) {
+	target := bytes.ToUpper([]byte(hash))
+
+	// Find the first line whose hash is not below the target. The line starting at or after
+	// an offset only moves forward as the offset grows, so offsets can be searched directly.
+	lo, hi := int64(0), db.size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		line, err := db.lineAt(mid)
+		if err != nil {
+			return 0, err
+		}
+		if line == nil {
+			hi = mid // Past the last line
+			continue
+		}
+		lineHash, _, err := parseBreachLine(line)
+		if err != nil {
+			return 0, err
+		}
+		if bytes.Compare(lineHash, target) >= 0 {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+
+	line, err := db.lineAt(lo)
+	if err != nil || line == nil {
+		return 0, err
+	}
+	lineHash, count, err := parseBreachLine(line)
+	if err != nil || !bytes.Equal(lineHash, target) {
+		return 0, err
+	}
+	return count, nil
+}
+
+// lineAt returns the first line starting at or after off without its line ending, or nil
+// past the last line
+func (db *BreachDB) lineAt(off int64) ([]byte, error) {
+	start := off
+	if off > 0 {
+		start = off - 1 // A newline just before off means a line starts at off
+	}
+	buf := make([]byte, breachChunk)
+	for {
+		n, err := db.file.ReadAt(buf, start)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		data := buf[:n]
+
+		lineStart := 0
+		if off > 0 {
+			i := bytes.IndexByte(data, '\n')
+			if i < 0 {
+				if int64(n) < int64(len(buf)) {
+					return nil, nil // No further line
+				}
+				start += int64(n)
+				off = start + 1
+				continue
+			}
+			lineStart = i + 1
+		}
+		rest := data[lineStart:]
+		if end := bytes.IndexByte(rest, '\n'); end >= 0 {
+			return bytes.TrimRight(rest[:end], "\r"), nil
+		}
+		if int64(n) < int64(len(buf)) {
+			// The last line without a line ending
+			if len(bytes.TrimSpace(rest)) == 0 {
+				return nil, nil
+			}
+			return bytes.TrimRight(rest, "\r"), nil
+		}
+		buf = make([]byte, 2*len(buf)) // A longer line than expected
+	}
+}
+
+// parseBreachLine splits a "HASH:COUNT" line. Lines without a count are counted once.
+func parseBreachLine(line []byte) ([]byte, int, error) {
+	hash, countText, hasCount := bytes.Cut(line, []byte(":"))
+	if len(hash) != 2*sha1.Size {
+		return nil, 0, fmt.Errorf("line %q does not start with a SHA-1 hash", line)
+	}
+	if _, err := hex.Decode(make([]byte, sha1.Size), hash); err != nil {
+		return nil, 0, fmt.Errorf("line %q does not start with a SHA-1 hash", line)
+	}
+	count := 1
+	if hasCount {
+		n, err := strconv.Atoi(string(bytes.TrimSpace(countText)))
+		if err != nil {
+			return nil, 0, fmt.Errorf("line %q has an invalid count", line)
+		}
+		count = n
+	}
+	return bytes.ToUpper(hash), count, nil
+}
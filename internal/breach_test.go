@@ -0,0 +1,78 @@
+package internal
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// writeBreachDB writes a sorted Pwned Passwords file with the given hashes and counts
+func writeBreachDB(t *testing.T, counts map[string]int, lineEnd string) string {
+	t.Helper()
+	hashes := make([]string, 0, len(counts))
+	for hash := range counts {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+	var sb strings.Builder
+	for _, hash := range hashes {
+		fmt.Fprintf(&sb, "%s:%d%s", hash, counts[hash], lineEnd)
+	}
+	path := filepath.Join(t.TempDir(), "pwned-passwords-sha1-ordered-by-hash.txt")
+	if err := os.WriteFile(path, []byte(sb.String()), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	return path
+}
+
+// TestBreachDB checks that every listed hash is found and others are not
+func TestBreachDB(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	counts := map[string]int{"5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8": 9545824} // password
+	for len(counts) < 3000 {
+		sum := make([]byte, sha1.Size)
+		r.Read(sum)
+		counts[strings.ToUpper(hex.EncodeToString(sum))] = 1 + r.Intn(1000000)
+	}
+	// The lowest and highest possible hashes test both ends of the file
+	counts[strings.Repeat("0", 40)] = 1
+	counts[strings.Repeat("F", 40)] = 2
+
+	for _, lineEnd := range []string{"\r\n", "\n"} {
+		db, err := OpenBreachDB(writeBreachDB(t, counts, lineEnd))
+		if err != nil {
+			t.Fatalf("OpenBreachDB() err = %v; want nil", err)
+		}
+		defer db.Close()
+
+		for hash, want := range counts {
+			if got, err := db.LookupHash(strings.ToLower(hash)); err != nil || got != want {
+				t.Fatalf("LookupHash(%s) = %d, %v; want %d", hash, got, err, want)
+			}
+		}
+		if got, err := db.Lookup("password"); err != nil || got != 9545824 {
+			t.Errorf("Lookup(password) = %d, %v; want 9545824", got, err)
+		}
+		for i := 0; i < 1000; i++ {
+			pass := fmt.Sprintf("not breached %d", i)
+			if got, err := db.Lookup(pass); err != nil || got != 0 {
+				t.Fatalf("Lookup(%q) = %d, %v; want 0", pass, got, err)
+			}
+		}
+	}
+
+	// NTLM lists have 32 digit hashes
+	path := filepath.Join(t.TempDir(), "ntlm.txt")
+	if err := os.WriteFile(path, []byte("8846F7EAEE8FB117AD06BDD830B7586C:9545824\r\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if _, err := OpenBreachDB(path); err == nil {
+		t.Errorf("OpenBreachDB() of an NTLM list err = nil; want error")
+	}
+}
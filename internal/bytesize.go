@@ -0,0 +1,53 @@
+package internal
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// byteUnits maps size suffixes to their multiplier (binary units, as used by du and ls)
+var byteUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"T", 1 << 40},
+	{"G", 1 << 30},
+	{"M", 1 << 20},
+	{"K", 1 << 10},
+	{"B", 1},
+}
+
+// ParseByteSize parses sizes like "512", "64K", "10MB" or "1.5GiB" into a number of bytes.
+func ParseByteSize(s string) (int64, error) {
+	str := strings.ToUpper(strings.TrimSpace(s))
+	str = strings.TrimSuffix(str, "IB")
+	if str != "B" {
+		str = strings.TrimSuffix(str, "B")
+	}
+
+	factor := int64(1)
+	for _, unit := range byteUnits {
+		if strings.HasSuffix(str, unit.suffix) {
+			factor = unit.factor
+			str = strings.TrimSuffix(str, unit.suffix)
+			break
+		}
+	}
+
+	value, err := strconv.ParseFloat(strings.TrimSpace(str), 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(value * float64(factor)), nil
+}
+
+// FormatByteSize renders a number of bytes using the largest fitting binary unit.
+func FormatByteSize(n int64) string {
+	for _, unit := range byteUnits[:len(byteUnits)-1] {
+		if n >= unit.factor {
+			return fmt.Sprintf("%.1f %siB", float64(n)/float64(unit.factor), unit.suffix)
+		}
+	}
+	return fmt.Sprintf("%d B", n)
+}
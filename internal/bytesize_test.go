@@ -0,0 +1,31 @@
+package internal
+
+import "testing"
+
+// TestParseByteSize checks plain numbers and binary unit suffixes
+func TestParseByteSize(t *testing.T) {
+	tests := map[string]int64{
+		"512":    512,
+		"64K":    64 << 10,
+		"10MB":   10 << 20,
+		"1.5GiB": 3 << 29,
+		"2t":     2 << 40,
+		"7B":     7,
+	}
+	for input, want := range tests {
+		got, err := ParseByteSize(input)
+		if err != nil {
+			t.Errorf("ParseByteSize(%q) err = %v; want nil", input, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("ParseByteSize(%q) = %d; want %d", input, got, want)
+		}
+	}
+
+	for _, input := range []string{"", "abc", "-5M", "10X"} {
+		if _, err := ParseByteSize(input); err == nil {
+			t.Errorf("ParseByteSize(%q) err = nil; want error", input)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package internal
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// maxChangeRetries is how often the retry policy copies a changed file again before giving up.
+const maxChangeRetries = 3
+
+// errFileShrank is returned when a file ends before the size recorded in its header.
+var errFileShrank = errors.New("file shrank while being read")
+
+// ChangePolicy decides what happens to a file that changes while it is being archived.
+// Files are archived directly and re-checked afterwards; an entry written to the archive
+// cannot be taken back, so only the snapshot policy and retries read a private copy.
+type ChangePolicy string
+
+const (
+	ChangeFail     ChangePolicy = "fail"     // Abort the backup
+	ChangeSkip     ChangePolicy = "skip"     // Keep the file as read, warn and go on
+	ChangeRetry    ChangePolicy = "retry"    // Archive a private copy again once the file is stable
+	ChangeSnapshot ChangePolicy = "snapshot" // Archive a private copy of every file, taken first
+)
+
+// ParseChangePolicy validates a change policy name.
+func ParseChangePolicy(s string) (ChangePolicy, error) {
+	switch p := ChangePolicy(s); p {
+	case ChangeFail, ChangeSkip, ChangeRetry, ChangeSnapshot:
+		return p, nil
+	default:
+		return "", fmt.Errorf("invalid change policy %q (want fail, skip, retry or snapshot)", s)
+	}
+}
+
+// ChangedFile records a file that was modified while the backup read it.
+type ChangedFile struct {
+	Path   string
+	Reason string // What changed, e.g. the size or modification time
+	Action string // What the backup did about it
+}
+
+// copyContents copies exactly n bytes. If src ends early the rest is filled with zeros so
+// the archive entry stays whole, and errFileShrank is returned.
+func copyContents(dst io.Writer, src io.Reader, n int64) error {
+	copied, err := io.CopyN(dst, src, n)
+	if err != io.EOF {
+		return err
+	}
+	if _, err := io.CopyN(dst, zeroReader{}, n-copied); err != nil {
+		return err
+	}
+	return errFileShrank
+}
+
+// zeroReader reads an endless stream of zeros.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// changeReason re-stats a file and describes how it differs from before, or returns "" if it did not change.
+func (a *archiver) changeReason(file string, before os.FileInfo) string {
+	after, err := a.statFunc(file)
+	switch {
+	case err != nil:
+		return fmt.Sprintf("file disappeared: %v", err)
+	case after.Size() != before.Size():
+		return fmt.Sprintf("size changed from %d to %d bytes", before.Size(), after.Size())
+	case !after.ModTime().Equal(before.ModTime()):
+		return "modification time changed"
+	default:
+		return ""
+	}
+}
+
+// recordChange adds a file to the change report.
+func (a *archiver) recordChange(file, reason, action string) {
+	a.changed = append(a.changed, ChangedFile{Path: file, Reason: reason, Action: action})
+}
+
+// abortChanged records a changed file and returns the error that stops the backup.
+func (a *archiver) abortChanged(file, reason string) error {
+	a.recordChange(file, reason, "backup aborted")
+	return fmt.Errorf("%s changed during backup: %s", file, reason)
+}
+
+// handleChange applies the change policy to a file that changed while it was archived
+// directly. Retries add the stable copy as a later entry of the same name, which replaces
+// the first one when the archive is restored.
+func (a *archiver) handleChange(header *tar.Header, file, reason string) error {
+	switch a.onChange {
+	case ChangeSkip:
+		a.recordChange(file, reason, "archived as read, contents may be inconsistent")
+		return nil
+	case ChangeRetry:
+		for attempt := 2; attempt <= 1+maxChangeRetries; attempt++ {
+			before, err := a.statFunc(file)
+			if err != nil {
+				return err
+			}
+			snapshot, err := snapshotFile(file, before.Size(), a.snapshotDir, a.throttle)
+			if err != nil {
+				return err
+			}
+			if a.changeReason(file, before) == "" {
+				a.recordChange(file, reason, fmt.Sprintf("archived again after %d attempts", attempt))
+				err := a.archiveCopy(header, before, snapshot)
+				os.Remove(snapshot)
+				return err
+			}
+			os.Remove(snapshot)
+		}
+		a.recordChange(file, reason, fmt.Sprintf("archived as read after %d attempts, contents may be inconsistent", 1+maxChangeRetries))
+		return nil
+	default:
+		return a.abortChanged(file, reason)
+	}
+}
+
+// archiveSnapshot copies a file to a private location and archives the copy, so the
+// archived contents are those of a single read even when the file changed meanwhile.
+func (a *archiver) archiveSnapshot(header *tar.Header, file string) error {
+	before, err := a.statFunc(file)
+	if err != nil {
+		return err
+	}
+	snapshot, err := snapshotFile(file, before.Size(), a.snapshotDir, a.throttle)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(snapshot)
+	if reason := a.changeReason(file, before); reason != "" {
+		a.recordChange(file, reason, "archived copy taken while changing")
+	}
+	return a.archiveCopy(header, before, snapshot)
+}
+
+// archiveCopy archives a snapshot under the name and metadata of the original file.
+func (a *archiver) archiveCopy(header *tar.Header, before os.FileInfo, snapshot string) error {
+	info, err := os.Stat(snapshot)
+	if err != nil {
+		return err
+	}
+	header.Size = info.Size()
+	header.ModTime = before.ModTime()
+	// The private copy cannot change, and its reads were already throttled
+	return a.archiveContents(header, snapshot, nil)
+}
+
+// snapshotFile copies a file into a temporary file in dir, keeping holes, and returns its
+// path. An empty dir means the default directory for temporary files ($TMPDIR).
+func snapshotFile(file string, size int64, dir string, throttle *Throttle) (string, error) {
+	src, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	dst, err := os.CreateTemp(dir, "admin-cli-snapshot-*")
+	if err != nil {
+		return "", err
+	}
+	defer dst.Close()
+
+	if err := copySnapshot(dst, src, size, throttle); err != nil {
+		os.Remove(dst.Name())
+		return "", err
+	}
+	return dst.Name(), nil
+}
+
+// copySnapshot copies the data segments of src, or all of it if it has no holes,
+// in which case data appended after size is copied too.
+func copySnapshot(dst, src *os.File, size int64, throttle *Throttle) error {
+	segments, err := sparseSegments(src, size)
+	if err != nil {
+		return err
+	}
+	if !isSparse(segments, size) {
+		_, err := io.Copy(dst, throttle.reader(src))
+		return err
+	}
+
+	for _, s := range segments {
+		if _, err := src.Seek(s.Offset, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := dst.Seek(s.Offset, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(dst, throttle.reader(src), s.Length); err != nil {
+			if err == io.EOF {
+				break // Shrank while copying, the stat check reports it
+			}
+			return err
+		}
+	}
+	return dst.Truncate(size)
+}
@@ -0,0 +1,106 @@
+package internal
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// changingFileInfo reports a new modification time on every stat, as if the file kept being written
+type changingFileInfo struct {
+	os.FileInfo
+	mtime time.Time
+}
+
+func (fi changingFileInfo) ModTime() time.Time { return fi.mtime }
+
+// TestArchiveFileChangePolicies checks every policy against a file that changes on the first
+// stats, or on every stat when changes is 0
+func TestArchiveFileChangePolicies(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "busy.log")
+	if err := os.WriteFile(file, []byte("log line\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	tests := []struct {
+		policy  ChangePolicy
+		changes int
+		wantErr bool
+		entries int
+		action  string
+	}{
+		{ChangeFail, 0, true, 1, "backup aborted"},
+		{ChangeSkip, 0, false, 1, "archived as read, contents may be inconsistent"},
+		{ChangeRetry, 3, false, 2, "archived again after 2 attempts"},
+		{ChangeRetry, 0, false, 1, "archived as read after 4 attempts, contents may be inconsistent"},
+		{ChangeSnapshot, 0, false, 1, "archived copy taken while changing"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		stats := 0
+		a := &archiver{
+			tw:          tw,
+			raw:         &buf,
+			basePath:    dir,
+			onChange:    tt.policy,
+			snapshotDir: t.TempDir(),
+			statFunc: func(name string) (os.FileInfo, error) {
+				fi, err := os.Lstat(name)
+				if err != nil {
+					return nil, err
+				}
+				if stats++; tt.changes == 0 || stats <= tt.changes {
+					return changingFileInfo{fi, fi.ModTime().Add(time.Duration(stats) * time.Second)}, nil
+				}
+				return changingFileInfo{fi, fi.ModTime().Add(time.Duration(tt.changes) * time.Second)}, nil
+			},
+		}
+
+		err := a.archiveFile(file)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: archiveFile() err = %v; want error %v", tt.policy, err, tt.wantErr)
+		}
+		if len(a.changed) != 1 || a.changed[0].Action != tt.action {
+			t.Errorf("%s: changed = %+v; want one file with action %q", tt.policy, a.changed, tt.action)
+		}
+		tw.Close()
+
+		// Every entry is complete and holds the file, retries add a later entry replacing the first
+		tr := tar.NewReader(&buf)
+		entries := 0
+		for {
+			if _, err := tr.Next(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("%s: reading archive err = %v; want nil", tt.policy, err)
+			}
+			if data, _ := io.ReadAll(tr); string(data) != "log line\n" {
+				t.Errorf("%s: archived contents = %q; want the file", tt.policy, data)
+			}
+			entries++
+		}
+		if entries != tt.entries {
+			t.Errorf("%s: archive has %d entries; want %d", tt.policy, entries, tt.entries)
+		}
+		if snapshots, _ := os.ReadDir(a.snapshotDir); len(snapshots) != 0 {
+			t.Errorf("%s: %d private copies left behind; want 0", tt.policy, len(snapshots))
+		}
+	}
+}
+
+// TestCopyContentsShrank checks that a file ending early is padded to the size of its entry
+func TestCopyContentsShrank(t *testing.T) {
+	var buf bytes.Buffer
+	if err := copyContents(&buf, bytes.NewReader([]byte("abc")), 8); err != errFileShrank {
+		t.Errorf("copyContents() err = %v; want errFileShrank", err)
+	}
+	if want := "abc\x00\x00\x00\x00\x00"; buf.String() != want {
+		t.Errorf("copyContents() wrote %q; want %q", buf.String(), want)
+	}
+}
@@ -0,0 +1,132 @@
+package internal
+
+import (
+	"sort"
+)
+
+// CompareStatus is how a file differs between two trees
+type CompareStatus string
+
+const (
+	CompareOnlyA     CompareStatus = "ONLY_IN_A" // Only present in the first tree
+	CompareOnlyB     CompareStatus = "ONLY_IN_B" // Only present in the second tree
+	CompareDifferent CompareStatus = "DIFFERENT" // Present in both with different contents
+	CompareMoved     CompareStatus = "MOVED"     // Same contents at a different path
+)
+
+// CompareResult is a difference between two trees. Paths are slash separated and relative to the roots.
+type CompareResult struct {
+	Status CompareStatus
+	PathA  string // Empty for files only in the second tree
+	PathB  string // Empty for files only in the first tree
+	HashA  string
+	HashB  string
+}
+
+// CompareSummary counts the differences between two trees
+type CompareSummary struct {
+	Identical int
+	OnlyA     int
+	OnlyB     int
+	Different int
+	Moved     int
+}
+
+// Matched reports whether both trees hold the same files at the same paths
+func (s CompareSummary) Matched() bool {
+	return s.OnlyA+s.OnlyB+s.Different+s.Moved == 0
+}
+
+// CompareTrees hashes the files of both trees with one worker pool and reports the differences.
+// A file only in one tree whose contents appear only in the other tree is reported as moved.
+func CompareTrees(rootA, rootB string, filesA, filesB []ScannedFile, workers int, algo string, cache *HashCache) ([]CompareResult, CompareSummary, []FileError) {
+	all := append(append([]ScannedFile{}, filesA...), filesB...)
+	var paths []string
+	for _, f := range all {
+		paths = append(paths, f.Path)
+	}
+	hasher := newFileHasher(all, cache)
+	hashes, errs := HashFiles(paths, workers, func(path string) (string, error) {
+		return hasher.fileHash(path, algo)
+	})
+	sortFileErrors(errs)
+
+	// Files that failed to hash are left out rather than reported as missing
+	relHashes := func(root string, files []ScannedFile) map[string]string {
+		m := make(map[string]string, len(files))
+		for _, f := range files {
+			if hash, ok := hashes[f.Path]; ok {
+				m[manifestPath(root, f.Path)] = hash
+			}
+		}
+		return m
+	}
+	a, b := relHashes(rootA, filesA), relHashes(rootB, filesB)
+
+	var results []CompareResult
+	var summary CompareSummary
+	var onlyA, onlyB []string
+	for _, rel := range sortedKeys(a) {
+		hashB, ok := b[rel]
+		switch {
+		case !ok:
+			onlyA = append(onlyA, rel)
+		case hashB == a[rel]:
+			summary.Identical++
+		default:
+			results = append(results, CompareResult{Status: CompareDifferent, PathA: rel, PathB: rel, HashA: a[rel], HashB: hashB})
+			summary.Different++
+		}
+	}
+	for _, rel := range sortedKeys(b) {
+		if _, ok := a[rel]; !ok {
+			onlyB = append(onlyB, rel)
+		}
+	}
+
+	// Pair up files that only exist in one tree by their contents, in path order
+	movedTo := make(map[string][]string)
+	for _, rel := range onlyB {
+		movedTo[b[rel]] = append(movedTo[b[rel]], rel)
+	}
+	moved := make(map[string]bool)
+	for _, rel := range onlyA {
+		hash := a[rel]
+		if targets := movedTo[hash]; len(targets) > 0 {
+			results = append(results, CompareResult{Status: CompareMoved, PathA: rel, PathB: targets[0], HashA: hash, HashB: hash})
+			movedTo[hash] = targets[1:]
+			moved[targets[0]] = true
+			summary.Moved++
+		} else {
+			results = append(results, CompareResult{Status: CompareOnlyA, PathA: rel, HashA: hash})
+			summary.OnlyA++
+		}
+	}
+	for _, rel := range onlyB {
+		if !moved[rel] {
+			results = append(results, CompareResult{Status: CompareOnlyB, PathB: rel, HashB: b[rel]})
+			summary.OnlyB++
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool { return results[i].sortPath() < results[j].sortPath() })
+	return results, summary, errs
+}
+
+// sortPath is the path a result is ordered by
+func (r CompareResult) sortPath() string {
+	if r.PathA != "" {
+		return r.PathA
+	}
+	return r.PathB
+}
+
+// sortedKeys returns the keys of a map in sorted order
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,57 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestCompareTrees checks that missing, changed and moved files are told apart
+func TestCompareTrees(t *testing.T) {
+	rootA, rootB := t.TempDir(), t.TempDir()
+	write := func(root, name, content string) ScannedFile {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		return ScannedFile{Path: path, Size: int64(len(content))}
+	}
+
+	filesA := []ScannedFile{
+		write(rootA, "same", "same"),
+		write(rootA, "changed", "old"),
+		write(rootA, "dir/renamed", "moved contents"),
+		write(rootA, "removed", "only in a"),
+	}
+	filesB := []ScannedFile{
+		write(rootB, "same", "same"),
+		write(rootB, "changed", "new"),
+		write(rootB, "other/renamed", "moved contents"),
+		write(rootB, "added", "only in b"),
+	}
+
+	results, summary, errs := CompareTrees(rootA, rootB, filesA, filesB, 2, "sha256", nil)
+	if len(errs) != 0 {
+		t.Fatalf("CompareTrees() errs = %v; want none", errs)
+	}
+	var got []string
+	for _, r := range results {
+		got = append(got, string(r.Status)+" "+r.PathA+" "+r.PathB)
+	}
+	want := []string{
+		"ONLY_IN_B  added",
+		"DIFFERENT changed changed",
+		"MOVED dir/renamed other/renamed",
+		"ONLY_IN_A removed ",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("CompareTrees() = %q; want %q", got, want)
+	}
+	if summary != (CompareSummary{Identical: 1, OnlyA: 1, OnlyB: 1, Different: 1, Moved: 1}) || summary.Matched() {
+		t.Errorf("CompareTrees() summary = %+v; want one of each", summary)
+	}
+}
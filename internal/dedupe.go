@@ -0,0 +1,473 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// DedupeAction is what happens to the redundant copies in a duplicate set
+type DedupeAction string
+
+const (
+	ActionReport   DedupeAction = "report"   // Only print the duplicates
+	ActionHardlink DedupeAction = "hardlink" // Replace copies with hard links to the kept file
+	ActionReflink  DedupeAction = "reflink"  // Replace copies with copy-on-write clones of the kept file
+	ActionDelete   DedupeAction = "delete"   // Remove the copies
+)
+
+// KeepRule decides which file of a duplicate set is kept
+type KeepRule string
+
+const (
+	KeepOldest   KeepRule = "oldest"   // Oldest modification time
+	KeepNewest   KeepRule = "newest"   // Newest modification time
+	KeepShortest KeepRule = "shortest" // Shortest path
+	KeepPriority KeepRule = "priority" // First file under the earliest listed priority directory
+)
+
+// DedupeOptions configures Deduplicate
+type DedupeOptions struct {
+	Action   DedupeAction
+	Keep     KeepRule
+	Priority []string // Directories in order of preference, for KeepPriority
+	DryRun   bool     // Print what would be done without changing anything
+	UndoLog  string   // File recording every change so it can be reverted
+}
+
+// UndoRecord describes a replaced or deleted file, as written to the undo log
+type UndoRecord struct {
+	Time      time.Time    `json:"time"`
+	Action    DedupeAction `json:"action"`
+	Path      string       `json:"path"`
+	Kept      string       `json:"kept"`
+	Algorithm string       `json:"algorithm"`
+	Hash      string       `json:"hash"`
+	Size      int64        `json:"size"`
+	Mode      os.FileMode  `json:"mode"`
+	ModTime   time.Time    `json:"mtime"`
+	UID       int          `json:"uid"`
+	GID       int          `json:"gid"`
+}
+
+// DefaultUndoLogPath returns the undo log in the user's cache directory, next to the hash
+// cache, so that runs from any directory share it
+func DefaultUndoLogPath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "admin-cli", "hashcheck-undo.jsonl"), nil
+}
+
+// ParseDedupeAction validates an action name
+func ParseDedupeAction(s string) (DedupeAction, error) {
+	switch a := DedupeAction(s); a {
+	case ActionReport, ActionHardlink, ActionReflink, ActionDelete:
+		return a, nil
+	default:
+		return "", fmt.Errorf("invalid action %q (want report, hardlink, reflink or delete)", s)
+	}
+}
+
+// ParseKeepRule validates a keep rule name
+func ParseKeepRule(s string) (KeepRule, error) {
+	switch k := KeepRule(s); k {
+	case KeepOldest, KeepNewest, KeepShortest, KeepPriority:
+		return k, nil
+	default:
+		return "", fmt.Errorf("invalid keep rule %q (want oldest, newest, shortest or priority)", s)
+	}
+}
+
+// Deduplicate keeps one file of every duplicate set and hardlinks, reflinks or deletes the others
+// after confirming they are identical byte for byte. It returns the number of bytes reclaimed.
+func Deduplicate(sets []DuplicateSet, opts DedupeOptions) (int64, error) {
+	if opts.Action == ActionReport || opts.Action == "" {
+		return 0, nil
+	}
+
+	var undo *os.File
+	if !opts.DryRun {
+		if err := os.MkdirAll(filepath.Dir(opts.UndoLog), 0700); err != nil {
+			return 0, fmt.Errorf("error creating undo log directory: %v", err)
+		}
+		var err error
+		undo, err = os.OpenFile(opts.UndoLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return 0, fmt.Errorf("error opening undo log: %v", err)
+		}
+		defer undo.Close()
+	}
+
+	var reclaimed int64
+	for _, set := range sets {
+		kept, err := chooseKept(set.Files, opts)
+		if err != nil {
+			fmt.Printf("Skipping duplicate set %s: %v\n", set.Hash, err)
+			continue
+		}
+		for _, path := range set.Files {
+			if path == kept {
+				continue
+			}
+			record, err := dedupeFile(path, kept, set, opts)
+			if err != nil {
+				fmt.Printf("Skipping %s: %v\n", path, err)
+				continue
+			}
+			if opts.DryRun {
+				fmt.Printf("Would %s %s (keeping %s)\n", opts.Action, path, kept)
+			} else {
+				fmt.Printf("%s %s (keeping %s)\n", actionPastTense(opts.Action), path, kept)
+				if err := json.NewEncoder(undo).Encode(record); err != nil {
+					return reclaimed, fmt.Errorf("error writing undo log: %v", err)
+				}
+			}
+			reclaimed += set.Size
+		}
+	}
+	return reclaimed, nil
+}
+
+// dedupeFile confirms path matches kept and applies the action, returning the undo record
+func dedupeFile(path, kept string, set DuplicateSet, opts DedupeOptions) (UndoRecord, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return UndoRecord{}, err
+	}
+	keptInfo, err := os.Lstat(kept)
+	if err != nil {
+		return UndoRecord{}, err
+	}
+	if os.SameFile(info, keptInfo) && opts.Action != ActionDelete {
+		return UndoRecord{}, fmt.Errorf("already linked to %s", kept)
+	}
+
+	equal, err := filesEqual(path, kept)
+	if err != nil {
+		return UndoRecord{}, err
+	}
+	if !equal {
+		return UndoRecord{}, fmt.Errorf("contents differ from %s, it may have changed since it was hashed", kept)
+	}
+
+	uid, gid := fileOwner(info)
+	record := UndoRecord{
+		Time:      time.Now(),
+		Action:    opts.Action,
+		Path:      path,
+		Kept:      kept,
+		Algorithm: set.Algorithm,
+		Hash:      set.Hash,
+		Size:      info.Size(),
+		Mode:      info.Mode(),
+		ModTime:   info.ModTime(),
+		UID:       uid,
+		GID:       gid,
+	}
+	if opts.DryRun {
+		return record, nil
+	}
+
+	switch opts.Action {
+	case ActionHardlink:
+		err = replaceFile(path, func(tmp string) error { return os.Link(kept, tmp) })
+	case ActionReflink:
+		err = replaceFile(path, func(tmp string) error { return reflinkCopy(kept, tmp, info) })
+	case ActionDelete:
+		err = os.Remove(path)
+	}
+	return record, err
+}
+
+// chooseKept picks the file to keep from a duplicate set according to the keep rule
+func chooseKept(files []string, opts DedupeOptions) (string, error) {
+	candidates := append([]string{}, files...)
+	modTimes := make(map[string]time.Time)
+	for _, f := range candidates {
+		info, err := os.Lstat(f)
+		if err != nil {
+			return "", err
+		}
+		modTimes[f] = info.ModTime()
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		switch opts.Keep {
+		case KeepOldest:
+			if !modTimes[a].Equal(modTimes[b]) {
+				return modTimes[a].Before(modTimes[b])
+			}
+		case KeepNewest:
+			if !modTimes[a].Equal(modTimes[b]) {
+				return modTimes[a].After(modTimes[b])
+			}
+		case KeepPriority:
+			if pa, pb := priorityRank(a, opts.Priority), priorityRank(b, opts.Priority); pa != pb {
+				return pa < pb
+			}
+		}
+		// Shortest path, also the tie breaker for every other rule
+		if len(a) != len(b) {
+			return len(a) < len(b)
+		}
+		return a < b
+	})
+	return candidates[0], nil
+}
+
+// priorityRank returns the index of the first priority directory containing path
+func priorityRank(path string, priority []string) int {
+	for i, dir := range priority {
+		rel, err := filepath.Rel(dir, path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return i
+		}
+	}
+	return len(priority)
+}
+
+// replaceFile atomically replaces path with a file created by create at a temporary name
+func replaceFile(path string, create func(tmp string) error) error {
+	tmp := filepath.Join(filepath.Dir(path), fmt.Sprintf(".%s.admin-cli-%d", filepath.Base(path), time.Now().UnixNano()))
+	if err := create(tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// filesEqual compares the contents of two files byte for byte
+func filesEqual(a, b string) (bool, error) {
+	fa, err := os.Open(a)
+	if err != nil {
+		return false, err
+	}
+	defer fa.Close()
+	fb, err := os.Open(b)
+	if err != nil {
+		return false, err
+	}
+	defer fb.Close()
+
+	ra, rb := bufio.NewReaderSize(fa, 1<<16), bufio.NewReaderSize(fb, 1<<16)
+	bufA, bufB := make([]byte, 1<<16), make([]byte, 1<<16)
+	for {
+		na, errA := io.ReadFull(ra, bufA)
+		nb, errB := io.ReadFull(rb, bufB)
+		if !bytes.Equal(bufA[:na], bufB[:nb]) {
+			return false, nil
+		}
+		if errA == io.EOF || errA == io.ErrUnexpectedEOF {
+			return errB == io.EOF || errB == io.ErrUnexpectedEOF, nil
+		}
+		if errA != nil {
+			return false, errA
+		}
+		if errB != nil {
+			return false, errB
+		}
+	}
+}
+
+// fileOwner returns the owner of a file, or -1 if the platform does not report it
+func fileOwner(info os.FileInfo) (uid, gid int) {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int(st.Uid), int(st.Gid)
+	}
+	return -1, -1
+}
+
+// copyFileAs writes an independent copy of src to dst with the given metadata
+func copyFileAs(src, dst string, mode os.FileMode, modTime time.Time, uid, gid int) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode.Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return restoreMetadata(dst, mode, modTime, uid, gid)
+}
+
+// restoreMetadata applies ownership, permissions and modification time to a file
+func restoreMetadata(path string, mode os.FileMode, modTime time.Time, uid, gid int) error {
+	if uid >= 0 && gid >= 0 {
+		// Only root may give files away, keep going for everyone else
+		if err := os.Lchown(path, uid, gid); err != nil && os.Geteuid() == 0 {
+			return err
+		}
+	}
+	if err := os.Chmod(path, mode.Perm()); err != nil {
+		return err
+	}
+	return os.Chtimes(path, modTime, modTime)
+}
+
+// actionPastTense describes a completed action for output
+func actionPastTense(action DedupeAction) string {
+	switch action {
+	case ActionHardlink:
+		return "Hardlinked"
+	case ActionReflink:
+		return "Reflinked"
+	default:
+		return "Deleted"
+	}
+}
+
+// UndoDedupe reverts the changes recorded in an undo log, newest first, by recreating
+// each replaced or deleted file as an independent copy of the file that was kept. Files
+// that are no longer links to the kept file were restored or changed since and are left
+// alone. Afterwards only the records that could not be restored remain in the log.
+func UndoDedupe(logPath string, dryRun bool) error {
+	f, err := os.Open(logPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var records []UndoRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var r UndoRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return fmt.Errorf("error parsing undo log: %v", err)
+		}
+		records = append(records, r)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	failed := make([]bool, len(records))
+	failures := 0
+	for i := len(records) - 1; i >= 0; i-- {
+		r := records[i]
+		if r.Action == ActionReflink {
+			// Clones share storage but are already independent files with their own metadata
+			fmt.Printf("Nothing to undo for reflinked %s\n", r.Path)
+			continue
+		}
+		if linked, err := stillDeduplicated(r); err != nil {
+			fmt.Printf("Error restoring %s: %v\n", r.Path, err)
+			failed[i] = true
+			failures++
+			continue
+		} else if !linked {
+			fmt.Printf("Skipping %s: it is no longer a link to %s, it was restored or changed since\n", r.Path, r.Kept)
+			continue
+		}
+		if dryRun {
+			fmt.Printf("Would restore %s from %s\n", r.Path, r.Kept)
+			continue
+		}
+		if err := undoRecord(r); err != nil {
+			fmt.Printf("Error restoring %s: %v\n", r.Path, err)
+			failed[i] = true
+			failures++
+			continue
+		}
+		fmt.Printf("Restored %s\n", r.Path)
+	}
+	if dryRun {
+		return nil
+	}
+
+	// Keep the failed records, so a later undo retries them but never replays the others
+	var remaining []UndoRecord
+	for i, r := range records {
+		if failed[i] {
+			remaining = append(remaining, r)
+		}
+	}
+	if err := writeUndoLog(logPath, remaining); err != nil {
+		return fmt.Errorf("error updating undo log: %v", err)
+	}
+	if failures > 0 {
+		return fmt.Errorf("%d file(s) could not be restored", failures)
+	}
+	return nil
+}
+
+// stillDeduplicated reports whether the file of a record is still as deduplication left it:
+// deleted, or a hard link to the kept file
+func stillDeduplicated(r UndoRecord) (bool, error) {
+	info, err := os.Lstat(r.Path)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	keptInfo, err := os.Lstat(r.Kept)
+	if err != nil {
+		return false, err
+	}
+	return os.SameFile(info, keptInfo), nil
+}
+
+// writeUndoLog atomically replaces the undo log with the given records
+func writeUndoLog(logPath string, records []UndoRecord) error {
+	return replaceFile(logPath, func(tmp string) error {
+		f, err := os.OpenFile(tmp, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(f)
+		for _, r := range records {
+			if err := enc.Encode(r); err != nil {
+				f.Close()
+				return err
+			}
+		}
+		return f.Close()
+	})
+}
+
+// undoRecord recreates a single file from the kept copy after checking the copy is unchanged
+func undoRecord(r UndoRecord) error {
+	if _, ok := hashAlgorithms[r.Algorithm]; !ok {
+		return fmt.Errorf("unknown hash algorithm %q in undo log", r.Algorithm)
+	}
+	hash, err := ComputeFileHash(r.Kept, r.Algorithm)
+	if err != nil {
+		return err
+	}
+	if hash != r.Hash {
+		return fmt.Errorf("kept file %s was modified since deduplication", r.Kept)
+	}
+	if _, err := os.Lstat(r.Path); os.IsNotExist(err) {
+		return copyFileAs(r.Kept, r.Path, r.Mode, r.ModTime, r.UID, r.GID)
+	}
+	return replaceFile(r.Path, func(tmp string) error {
+		return copyFileAs(r.Kept, tmp, r.Mode, r.ModTime, r.UID, r.GID)
+	})
+}
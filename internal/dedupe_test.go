@@ -0,0 +1,114 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestDeduplicateHardlinkAndUndo checks that duplicates are hardlinked and restored as independent files
+func TestDeduplicateHardlinkAndUndo(t *testing.T) {
+	dir := t.TempDir()
+	oldPath := filepath.Join(dir, "a", "old.txt")
+	newPath := filepath.Join(dir, "b", "new-copy.txt")
+	for i, path := range []string{oldPath, newPath} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte("same content"), 0640); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		mtime := time.Now().Add(time.Duration(i-2) * time.Hour)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatalf("Failed to set times: %v", err)
+		}
+	}
+
+	sets, errs := FindDuplicates([]ScannedFile{{Path: oldPath, Size: 12}, {Path: newPath, Size: 12}}, 2, "sha256", nil)
+	if len(sets) != 1 || len(errs) != 0 {
+		t.Fatalf("FindDuplicates() = %v, %v; want one set", sets, errs)
+	}
+
+	// Keep rules pick different files
+	for rule, want := range map[KeepRule]string{KeepOldest: oldPath, KeepNewest: newPath, KeepShortest: oldPath} {
+		if got, _ := chooseKept(sets[0].Files, DedupeOptions{Keep: rule}); got != want {
+			t.Errorf("chooseKept(%s) = %s; want %s", rule, got, want)
+		}
+	}
+	got, _ := chooseKept(sets[0].Files, DedupeOptions{Keep: KeepPriority, Priority: []string{filepath.Join(dir, "b")}})
+	if got != newPath {
+		t.Errorf("chooseKept(priority) = %s; want %s", got, newPath)
+	}
+
+	undoLog := filepath.Join(dir, "undo.jsonl")
+	reclaimed, err := Deduplicate(sets, DedupeOptions{Action: ActionHardlink, Keep: KeepOldest, UndoLog: undoLog})
+	if err != nil || reclaimed != 12 {
+		t.Fatalf("Deduplicate() = %d, %v; want 12, nil", reclaimed, err)
+	}
+	oldInfo, _ := os.Stat(oldPath)
+	newInfo, _ := os.Stat(newPath)
+	if !os.SameFile(oldInfo, newInfo) {
+		t.Fatal("duplicate was not replaced by a hard link")
+	}
+
+	if err := UndoDedupe(undoLog, false); err != nil {
+		t.Fatalf("UndoDedupe() err = %v; want nil", err)
+	}
+	oldInfo, _ = os.Stat(oldPath)
+	newInfo, _ = os.Stat(newPath)
+	if os.SameFile(oldInfo, newInfo) {
+		t.Error("undo left the files linked")
+	}
+	if newInfo.Mode().Perm() != 0640 || newInfo.ModTime().After(time.Now().Add(-30*time.Minute)) {
+		t.Errorf("undo did not restore metadata: mode %v, mtime %v", newInfo.Mode(), newInfo.ModTime())
+	}
+	if data, _ := os.ReadFile(newPath); string(data) != "same content" {
+		t.Errorf("restored content = %q; want %q", data, "same content")
+	}
+
+	// A second undo must not replace the restored file after it was edited
+	if err := os.WriteFile(newPath, []byte("edited since"), 0640); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := UndoDedupe(undoLog, false); err != nil {
+		t.Fatalf("second UndoDedupe() err = %v; want nil", err)
+	}
+	if data, _ := os.ReadFile(newPath); string(data) != "edited since" {
+		t.Errorf("content after a second undo = %q; want the edit kept", data)
+	}
+	if data, err := os.ReadFile(undoLog); err != nil || len(data) != 0 {
+		t.Errorf("undo log after undo = %q, %v; want it empty", data, err)
+	}
+}
+
+// TestUndoDedupeEdited checks that a file replaced after deduplication is not overwritten
+func TestUndoDedupeEdited(t *testing.T) {
+	dir := t.TempDir()
+	kept := filepath.Join(dir, "kept.txt")
+	dup := filepath.Join(dir, "dup.txt")
+	for _, path := range []string{kept, dup} {
+		if err := os.WriteFile(path, []byte("same content"), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+	sets, _ := FindDuplicates([]ScannedFile{{Path: kept, Size: 12}, {Path: dup, Size: 12}}, 2, "sha256", nil)
+	undoLog := filepath.Join(dir, "undo.jsonl")
+	if _, err := Deduplicate(sets, DedupeOptions{Action: ActionHardlink, Keep: KeepShortest, UndoLog: undoLog}); err != nil {
+		t.Fatalf("Deduplicate() err = %v; want nil", err)
+	}
+
+	// Saving with a new file, as editors do, breaks the link
+	if err := os.Remove(dup); err != nil {
+		t.Fatalf("Failed to remove file: %v", err)
+	}
+	if err := os.WriteFile(dup, []byte("new version"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := UndoDedupe(undoLog, false); err != nil {
+		t.Fatalf("UndoDedupe() err = %v; want nil", err)
+	}
+	if data, _ := os.ReadFile(dup); string(data) != "new version" {
+		t.Errorf("content after undo = %q; want the new version kept", data)
+	}
+}
@@ -0,0 +1,202 @@
+package internal
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+// partialHashSize is the number of bytes read from each end of a file to tell candidates apart
+const partialHashSize = 4096
+
+// ScannedFile is a file found while walking a directory
+type ScannedFile struct {
+	Path   string
+	Size   int64
+	Hashes map[string]string // Hashes computed while scanning, for files inside archives
+}
+
+// FileError is an error that occurred while processing a single file
+type FileError struct {
+	Path string
+	Err  error
+}
+
+func (e FileError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Path, e.Err)
+}
+
+// DuplicateSet is a group of files with identical contents
+type DuplicateSet struct {
+	Algorithm string   // Hash algorithm used to compare the files
+	Hash      string   // Hex digest of the contents
+	Size      int64    // Size of each file in the set
+	Files     []string // Sorted paths of the identical files
+}
+
+// Wasted returns the number of bytes that keeping a single copy would reclaim
+func (d DuplicateSet) Wasted() int64 {
+	return d.Size * int64(len(d.Files)-1)
+}
+
+// FindDuplicates finds files with identical contents in stages: files are grouped by size,
+// then by a hash of their first and last few KB, and only files still sharing both are
+// hashed in full with algo. Empty files are ignored since they waste no space.
+// Hashes are taken from the scan or the cache where possible, the cache may be nil.
+func FindDuplicates(files []ScannedFile, workers int, algo string, cache *HashCache) ([]DuplicateSet, []FileError) {
+	var errs []FileError
+	hasher := newFileHasher(files, cache)
+
+	// Stage 1: only files sharing their size can be duplicates
+	bySize := make(map[int64][]string)
+	for _, f := range files {
+		if f.Size > 0 {
+			bySize[f.Size] = append(bySize[f.Size], f.Path)
+		}
+	}
+	sizes := make(map[string]int64)
+	var candidates []string
+	for size, paths := range bySize {
+		if len(paths) > 1 {
+			for _, p := range paths {
+				sizes[p] = size
+			}
+			candidates = append(candidates, paths...)
+		}
+	}
+
+	// Stage 2: hash both ends of every remaining candidate
+	partial, partialErrs := HashFiles(candidates, workers, func(path string) (string, error) {
+		return hasher.partialHash(path, sizes[path], algo)
+	})
+	errs = append(errs, partialErrs...)
+
+	// Small files were hashed completely, larger ones with matching ends need a full hash
+	groups := groupBy(candidates, sizes, partial)
+	candidates = candidates[:0]
+	for key, paths := range groups {
+		if key.size > 2*partialHashSize && len(paths) > 1 {
+			candidates = append(candidates, paths...)
+			delete(groups, key)
+		}
+	}
+
+	// Stage 3: full hashes for the remaining candidates
+	full, fullErrs := HashFiles(candidates, workers, func(path string) (string, error) {
+		return hasher.fileHash(path, algo)
+	})
+	errs = append(errs, fullErrs...)
+	for key, paths := range groupBy(candidates, sizes, full) {
+		groups[key] = paths
+	}
+
+	var sets []DuplicateSet
+	for key, paths := range groups {
+		if len(paths) > 1 {
+			sort.Strings(paths)
+			sets = append(sets, DuplicateSet{Algorithm: algo, Hash: key.hash, Size: key.size, Files: paths})
+		}
+	}
+	sortDuplicateSets(sets)
+	sortFileErrors(errs)
+	return sets, errs
+}
+
+// groupKey identifies files with the same size and hash
+type groupKey struct {
+	size int64
+	hash string
+}
+
+// groupBy groups the hashed paths by size and hash, leaving out paths that failed to hash
+func groupBy(paths []string, sizes map[string]int64, hashes map[string]string) map[groupKey][]string {
+	groups := make(map[groupKey][]string)
+	for _, p := range paths {
+		if hash, ok := hashes[p]; ok {
+			key := groupKey{size: sizes[p], hash: hash}
+			groups[key] = append(groups[key], p)
+		}
+	}
+	return groups
+}
+
+// sortDuplicateSets orders sets by wasted bytes, largest first, then by hash
+func sortDuplicateSets(sets []DuplicateSet) {
+	sort.Slice(sets, func(i, j int) bool {
+		if sets[i].Wasted() != sets[j].Wasted() {
+			return sets[i].Wasted() > sets[j].Wasted()
+		}
+		return sets[i].Hash < sets[j].Hash
+	})
+}
+
+// sortFileErrors orders errors by path
+func sortFileErrors(errs []FileError) {
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Path < errs[j].Path })
+}
+
+// HashFiles computes hashFn for every path using a pool of concurrent workers
+func HashFiles[T any](paths []string, workers int, hashFn func(path string) (T, error)) (map[string]T, []FileError) {
+	hashes := make(map[string]T, len(paths))
+	var errs []FileError
+	mu := &sync.Mutex{} // Mutex to protect shared state
+
+	// Buffered channel to send file paths to workers
+	fileChan := make(chan string, workers)
+
+	// WaitGroup to track when all workers are done
+	var wg sync.WaitGroup
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range fileChan {
+				hash, err := hashFn(path)
+
+				mu.Lock() // Protect the results since they are shared across go routines
+				if err != nil {
+					errs = append(errs, FileError{Path: path, Err: err})
+				} else {
+					hashes[path] = hash
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, path := range paths {
+		fileChan <- path
+	}
+	close(fileChan)
+	wg.Wait()
+
+	return hashes, errs
+}
+
+// ComputePartialHash computes the hash of the first and last partialHashSize bytes of a file.
+// Files no larger than both ends together are hashed completely, giving the same result as ComputeFileHash.
+func ComputePartialHash(path string, size int64, algo string) (string, error) {
+	if size <= 2*partialHashSize {
+		return ComputeFileHash(path, algo)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	buf := make([]byte, 2*partialHashSize)
+	if err := hashIO.readAt(file, buf[:partialHashSize], 0); err != nil {
+		return "", err
+	}
+	if err := hashIO.readAt(file, buf[partialHashSize:], size-partialHashSize); err != nil {
+		return "", err
+	}
+	h := newHash(algo)
+	h.Write(buf)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,74 @@
+package internal
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestFindDuplicates checks that only files with identical contents end up in a set
+func TestFindDuplicates(t *testing.T) {
+	dir := t.TempDir()
+	big := bytes.Repeat([]byte("a"), 3*partialHashSize)
+	sameEnds := append([]byte{}, big...)
+	sameEnds[len(sameEnds)/2] = 'b' // Same size and ends, different middle
+
+	contents := map[string][]byte{
+		"big1":       big,
+		"big2":       big,
+		"sameEnds":   sameEnds,
+		"small1":     []byte("small"),
+		"sub/small2": []byte("small"),
+		"unique":     []byte("different size"),
+		"empty1":     {},
+		"empty2":     {},
+	}
+
+	var files []ScannedFile
+	for name, content := range contents {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		files = append(files, ScannedFile{Path: path, Size: int64(len(content))})
+	}
+	files = append(files, ScannedFile{Path: filepath.Join(dir, "missing"), Size: 5})
+
+	sets, errs := FindDuplicates(files, 4, "sha256", nil)
+	if len(errs) != 1 || errs[0].Path != filepath.Join(dir, "missing") {
+		t.Errorf("FindDuplicates() errs = %v; want one error for the missing file", errs)
+	}
+
+	want := [][]string{
+		{filepath.Join(dir, "big1"), filepath.Join(dir, "big2")},
+		{filepath.Join(dir, "small1"), filepath.Join(dir, "sub/small2")},
+	}
+	var got [][]string
+	for _, set := range sets {
+		got = append(got, set.Files)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("FindDuplicates() = %v; want %v", got, want)
+	}
+
+	if sets[0].Hash != computeHashOrFail(t, filepath.Join(dir, "big1")) {
+		t.Errorf("set hash = %s; want full hash of the contents", sets[0].Hash)
+	}
+	if sets[0].Wasted() != int64(len(big)) {
+		t.Errorf("Wasted() = %d; want %d", sets[0].Wasted(), len(big))
+	}
+}
+
+// computeHashOrFail returns the SHA-256 hash of a file, failing the test on error
+func computeHashOrFail(t *testing.T, path string) string {
+	hash, err := ComputeFileHash(path, "sha256")
+	if err != nil {
+		t.Fatalf("ComputeFileHash() err = %v; want nil", err)
+	}
+	return hash
+}
@@ -1,48 +1,55 @@
 package internal
 
-import (
-	"crypto/sha256"
-	"fmt"
-	"io"
-	"os"
-)
+import "fmt"
 
-// ComputeFileHash computes the SHA-256 hash of a file at the given path
-func ComputeFileHash(path string) ([32]byte, error) {
-	file, err := os.Open(path) // Open the file at the given path
+// ComputeFileHash computes the hash of a file at the given path and returns it as a hex string
+func ComputeFileHash(path, algo string) (string, error) {
+	hashes, err := ComputeFileHashes(path, []string{algo})
 	if err != nil {
-		return [32]byte{}, err // Return an empty array and the error if opening fails
+		return "", err
 	}
-	defer file.Close() // Ensure the file is closed when the function exits
-
-	hash := sha256.New()                           // Create a new SHA-256 hash instance
-	if _, err := io.Copy(hash, file); err != nil { // Copy file content into the hash
-		return [32]byte{}, err // Return an empty array and the error if copying fails
-	}
-
-	var result [32]byte            // Declare a variable to hold the resulting hash
-	copy(result[:], hash.Sum(nil)) // Copy the computed hash into the result variable
+	return hashes[algo], nil
+}
 
-	return result, nil // Return the hash and no error
+// ComputeFileHashes computes several hashes of a file in a single read pass, keyed by algorithm.
+// The file is read as set up by ConfigureHashIO.
+func ComputeFileHashes(path string, algos []string) (map[string]string, error) {
+	return hashIO.hashFile(path, algos)
 }
 
-// PrintCollisions prints the files that have the same hash
-func PrintCollisions(hashes map[[32]byte][]string) {
-	hasCollisions := false // Default to no collisions
+// PrintDuplicateSets prints each set of identical files with the space it wastes
+func PrintDuplicateSets(sets []DuplicateSet) {
+	if len(sets) == 0 {
+		fmt.Println("No hash collisions found.")
+		return
+	}
 
-	for hash, files := range hashes {
-		// If a hash has more than one file, it means there is a collision.
-		if len(files) > 1 {
-			hasCollisions = true // Update outer variable
-			fmt.Printf("Hash collision detected for hash %x:\n", hash)
-			for _, file := range files {
-				fmt.Println(" -", file)
-			}
-			fmt.Println() // Add a newline for readability
+	var totalWasted int64
+	for _, set := range sets {
+		fmt.Printf("Duplicate files for %s %s (%d copies of %s, %s wasted):\n",
+			set.Algorithm, set.Hash, len(set.Files), FormatByteSize(set.Size), FormatByteSize(set.Wasted()))
+		for _, file := range set.Files {
+			fmt.Println(" -", file)
 		}
+		fmt.Println() // Add a newline for readability
+		totalWasted += set.Wasted()
 	}
+	fmt.Printf("Found %d duplicate sets wasting %s in total.\n", len(sets), FormatByteSize(totalWasted))
+}
 
-	if !hasCollisions {
-		fmt.Println("No hash collisions found.")
+// PrintSimilarSets prints the clusters of similar files
+func PrintSimilarSets(sets []SimilarSet) {
+	if len(sets) == 0 {
+		fmt.Println("No similar files found.")
+		return
+	}
+
+	for _, set := range sets {
+		fmt.Printf("Similar files by %s (%d files linked at %d%% similarity or more):\n", set.Kind, len(set.Files), set.Similarity)
+		for _, file := range set.Files {
+			fmt.Println(" -", file)
+		}
+		fmt.Println()
 	}
+	fmt.Printf("Found %d sets of similar files.\n", len(sets))
 }
@@ -1,10 +1,11 @@
 package internal
 
 import (
-	"bytes"
 	"crypto/sha256"
-	"io"
+	"encoding/hex"
 	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 )
 
@@ -31,50 +32,42 @@ func TestComputeFileHash(t *testing.T) {
 	}
 
 	// compute the hash of the temporary file manually
-	expectedHash := sha256.Sum256(content)
+	sum := sha256.Sum256(content)
+	expectedHash := hex.EncodeToString(sum[:])
 
 	// Call the ComputeFileHash function under test
-	actualHash, err := ComputeFileHash(tempFile.Name())
+	actualHash, err := ComputeFileHash(tempFile.Name(), "sha256")
 	if err != nil {
 		t.Fatalf("ComputeFileHash() err = %v; want nil", err)
 	}
 
 	// compare the actual hash with the expected hash
 	if actualHash != expectedHash {
-		t.Errorf("ComputeFileHash() = %x; want %x", actualHash, expectedHash)
+		t.Errorf("ComputeFileHash() = %s; want %s", actualHash, expectedHash)
 	}
 }
 
-// TestPrintCollisions checks if collisions are correctly detected and printed
-func TestPrintCollisions(t *testing.T) {
-	hash1 := sha256.Sum256([]byte("file1_content"))
-	hash2 := sha256.Sum256([]byte("file2_content"))
-
-	// Simulate a collision by having multiple files with the same hash
-	hashes := map[[32]byte][]string{
-		hash1: {"file1", "file3"},
-		hash2: {"file2"},
+// TestComputeFileHashes checks every algorithm against known digests computed in one pass
+func TestComputeFileHashes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "abc")
+	if err := os.WriteFile(path, []byte("abc"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
 	}
 
-	// Create a pipe to capture os.Stdout output
-	r, w, _ := os.Pipe()
-	origStdout := os.Stdout
-	os.Stdout = w
-
-	// Call the function to be tested
-	PrintCollisions(hashes)
-
-	// Restore original os.Stdout
-	w.Close()
-	os.Stdout = origStdout
-
-	// Read the captured output from the pipe
-	var buf bytes.Buffer
-	io.Copy(&buf, r)
-
-	// Check if the output contains the expected message
-	expected := "Hash collision detected for hash"
-	if !bytes.Contains(buf.Bytes(), []byte(expected)) {
-		t.Errorf("Expected collision message in output, got: %s", buf.String())
+	want := map[string]string{
+		"md5":     "900150983cd24fb0d6963f7d28e17f72",
+		"sha1":    "a9993e364706816aba3e25717850c26c9cd0d89d",
+		"sha256":  "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+		"sha512":  "ddaf35a193617abacc417349ae20413112e6fa4e89a97ea20a9eeee64b55d39a2192992a274fc1a836ba3c23a3feebbd454d4423643ce80e2a9ac94fa54ca49f",
+		"blake2b": "ba80a53f981c4d0d6a2797b69f12f6e94c212f14685ac4b74b12bb6fdbffa2d17d87c5392aab792dc252d5de4533cc9518d38aa8dbf1925ab92386edd4009923",
+		"blake3":  "6437b3ac38465133ffb63b75273a8db548c558465d79db03fd359c6cd5bd9d85",
+		"xxhash":  "44bc2cf5ad770999",
+	}
+	got, err := ComputeFileHashes(path, HashAlgorithmNames())
+	if err != nil {
+		t.Fatalf("ComputeFileHashes() err = %v; want nil", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ComputeFileHashes() = %v; want %v", got, want)
 	}
 }
@@ -0,0 +1,545 @@
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	_ "image/gif" // Register decoders for image.Decode
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"math"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FuzzyKind is how the similarity of two files is measured
+type FuzzyKind string
+
+const (
+	FuzzyImage   FuzzyKind = "image"   // Perceptual hashes of the decoded pixels (dHash and pHash)
+	FuzzyContent FuzzyKind = "content" // Context triggered piecewise hash of the bytes, like ssdeep
+)
+
+// DefaultSimilarity is the similarity in percent above which files are reported as similar
+const DefaultSimilarity = 80
+
+// fuzzyHashName is the name under which fuzzy hashes are cached
+const fuzzyHashName = "fuzzy"
+
+// imageExtensions are the file types decoded for perceptual hashing
+var imageExtensions = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".gif": true}
+
+// SimilarSet is a cluster of files with similar but not necessarily identical contents
+type SimilarSet struct {
+	Kind       FuzzyKind
+	Similarity int // Lowest similarity of the pairs linking the files, in percent
+	Files      []string
+}
+
+// FindSimilar computes fuzzy hashes of the files and clusters those at least threshold percent
+// similar to another file in the cluster. Clusters of identical files already listed in one of
+// the exact duplicate sets are left out. Files inside archives cannot be read again and are skipped.
+func FindSimilar(files []ScannedFile, workers, threshold int, exact []DuplicateSet, cache *HashCache) ([]SimilarSet, []FileError) {
+	var paths []string
+	for _, f := range files {
+		if f.Size > 0 && f.Hashes == nil {
+			paths = append(paths, f.Path)
+		}
+	}
+	sums, errs := HashFiles(paths, workers, cache.FuzzyHash)
+	sortFileErrors(errs)
+
+	var images, contents []string
+	parsed := make(map[string]fuzzyHash, len(sums))
+	for _, path := range paths {
+		sum, ok := sums[path]
+		if !ok {
+			continue
+		}
+		h, err := parseFuzzyHash(sum)
+		if err != nil {
+			errs = append(errs, FileError{Path: path, Err: err})
+			continue
+		}
+		parsed[path] = h
+		if h.kind == FuzzyImage {
+			images = append(images, path)
+		} else {
+			contents = append(contents, path)
+		}
+	}
+
+	inExactSet := make(map[string]int)
+	for i, set := range exact {
+		for _, f := range set.Files {
+			inExactSet[f] = i + 1
+		}
+	}
+
+	var sets []SimilarSet
+	for _, group := range []struct {
+		kind  FuzzyKind
+		paths []string
+	}{{FuzzyImage, images}, {FuzzyContent, contents}} {
+		for _, c := range clusterSimilar(group.paths, parsed, threshold) {
+			if identical(c.files, inExactSet) {
+				continue
+			}
+			sets = append(sets, SimilarSet{Kind: group.kind, Similarity: c.similarity, Files: c.files})
+		}
+	}
+	sort.SliceStable(sets, func(i, j int) bool {
+		if sets[i].Similarity != sets[j].Similarity {
+			return sets[i].Similarity > sets[j].Similarity
+		}
+		return sets[i].Files[0] < sets[j].Files[0]
+	})
+	return sets, errs
+}
+
+// identical reports whether every file of a cluster is in the same exact duplicate set
+func identical(files []string, inExactSet map[string]int) bool {
+	set := inExactSet[files[0]]
+	for _, f := range files[1:] {
+		if inExactSet[f] != set {
+			return false
+		}
+	}
+	return set != 0
+}
+
+// cluster is a group of files linked by similar pairs
+type cluster struct {
+	files      []string
+	similarity int
+}
+
+// clusterSimilar links every pair of files at least threshold percent similar with union-find.
+// Files are indexed by their candidate keys and only compared with files sharing one.
+func clusterSimilar(paths []string, hashes map[string]fuzzyHash, threshold int) []cluster {
+	sort.Strings(paths)
+	parent := make([]int, len(paths))
+	lowest := make([]int, len(paths)) // Lowest linking similarity, kept at the root
+	for i := range parent {
+		parent[i] = i
+		lowest[i] = 101
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	index := make(map[string][]int)
+	for j, path := range paths {
+		compared := make(map[int]bool)
+		for _, key := range hashes[path].candidateKeys(threshold) {
+			for _, i := range index[key] {
+				if compared[i] {
+					continue
+				}
+				compared[i] = true
+				score := hashes[paths[i]].similarity(hashes[path])
+				if score < threshold {
+					continue
+				}
+				ri, rj := find(i), find(j)
+				if ri != rj {
+					parent[rj] = ri
+					lowest[ri] = min(lowest[ri], lowest[rj])
+				}
+				lowest[ri] = min(lowest[ri], score)
+			}
+			index[key] = append(index[key], j)
+		}
+	}
+
+	byRoot := make(map[int]*cluster)
+	var roots []int
+	for i, path := range paths {
+		r := find(i)
+		if byRoot[r] == nil {
+			byRoot[r] = &cluster{similarity: lowest[r]}
+			roots = append(roots, r)
+		}
+		byRoot[r].files = append(byRoot[r].files, path)
+	}
+	var clusters []cluster
+	for _, r := range roots {
+		if c := byRoot[r]; len(c.files) > 1 {
+			clusters = append(clusters, *c)
+		}
+	}
+	return clusters
+}
+
+// ComputeFuzzyHash returns the perceptual hashes of an image, or the piecewise hash of any other
+// file or image that cannot be decoded, as a string that can be cached and compared
+func ComputeFuzzyHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if imageExtensions[strings.ToLower(filepath.Ext(path))] {
+		if img, _, err := image.Decode(bufio.NewReader(f)); err == nil {
+			return fmt.Sprintf("%s:%016x:%016x", FuzzyImage, DHash(img), PHash(img)), nil
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	sum, err := piecewiseHash(f, info.Size())
+	if err != nil {
+		return "", err
+	}
+	return string(FuzzyContent) + ":" + sum, nil
+}
+
+// fuzzyHash is a parsed fuzzy hash
+type fuzzyHash struct {
+	kind         FuzzyKind
+	dhash, phash uint64 // Images
+	blockSize    int    // Other files
+	sig1, sig2   string // Signatures at blockSize and twice blockSize, with long runs collapsed
+}
+
+// parseFuzzyHash parses the output of ComputeFuzzyHash
+func parseFuzzyHash(s string) (fuzzyHash, error) {
+	parts := strings.Split(s, ":")
+	switch {
+	case len(parts) == 3 && parts[0] == string(FuzzyImage):
+		d, err1 := strconv.ParseUint(parts[1], 16, 64)
+		p, err2 := strconv.ParseUint(parts[2], 16, 64)
+		if err1 == nil && err2 == nil {
+			return fuzzyHash{kind: FuzzyImage, dhash: d, phash: p}, nil
+		}
+	case len(parts) == 4 && parts[0] == string(FuzzyContent):
+		bs, err := strconv.Atoi(parts[1])
+		if err == nil && bs > 0 {
+			return fuzzyHash{kind: FuzzyContent, blockSize: bs, sig1: collapseRuns(parts[2]), sig2: collapseRuns(parts[3])}, nil
+		}
+	}
+	return fuzzyHash{}, fmt.Errorf("invalid fuzzy hash %q", s)
+}
+
+// similarity scores two fuzzy hashes of the same kind from 0 to 100
+func (a fuzzyHash) similarity(b fuzzyHash) int {
+	if a.kind != b.kind {
+		return 0
+	}
+	if a.kind == FuzzyImage {
+		// Both hashes must agree, so the larger distance counts
+		dist := max(bits.OnesCount64(a.dhash^b.dhash), bits.OnesCount64(a.phash^b.phash))
+		return 100 - dist*100/64
+	}
+	return comparePiecewise(a, b)
+}
+
+// candidateKeys returns keys of which two hashes at least threshold percent similar share one.
+// Piecewise hashes only score above zero when their signatures at a common block size are
+// equal or have rollingWindow characters in common, so like ssdeep they are keyed by each run
+// of rollingWindow characters and its block size. Two images within the distance allowed by
+// the threshold differ in at most that many bits of their dHash, so when the dHash is split
+// into one more band than that, at least one band is equal.
+func (h fuzzyHash) candidateKeys(threshold int) []string {
+	keys := make(map[string]bool)
+	if h.kind == FuzzyImage {
+		dist := 0
+		for dist < 64 && 100-(dist+1)*100/64 >= threshold {
+			dist++
+		}
+		bands := dist + 1
+		for i := 0; i < bands; i++ {
+			lo, hi := i*64/bands, (i+1)*64/bands
+			keys[fmt.Sprintf("%d:%x", i, h.dhash>>lo&(1<<(hi-lo)-1))] = true
+		}
+	} else {
+		keys[fmt.Sprintf("=%d:%s", h.blockSize, h.sig1)] = true
+		for _, s := range []struct {
+			sig string
+			bs  int
+		}{{h.sig1, h.blockSize}, {h.sig2, 2 * h.blockSize}} {
+			for i := 0; i+rollingWindow <= len(s.sig); i++ {
+				keys[fmt.Sprintf("%d:%s", s.bs, s.sig[i:i+rollingWindow])] = true
+			}
+		}
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// DHash is the difference hash of an image: each bit tells whether a pixel of a 9x8 grayscale
+// thumbnail is brighter than its right neighbour
+func DHash(img image.Image) uint64 {
+	g := grayThumbnail(img, 9, 8)
+	var h uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			h <<= 1
+			if g[y][x] > g[y][x+1] {
+				h |= 1
+			}
+		}
+	}
+	return h
+}
+
+// PHash is the perceptual hash of an image: each bit tells whether one of the 8x8 lowest
+// frequencies of the discrete cosine transform of a 32x32 thumbnail is above their median
+func PHash(img image.Image) uint64 {
+	const n = 32
+	g := grayThumbnail(img, n, n)
+
+	var cos [8][n]float64
+	for u := range cos {
+		for x := range cos[u] {
+			cos[u][x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / (2 * n))
+		}
+	}
+	// Separable DCT-II, only computing the frequencies that are kept
+	var rows [n][8]float64
+	for y := 0; y < n; y++ {
+		for u := 0; u < 8; u++ {
+			for x := 0; x < n; x++ {
+				rows[y][u] += g[y][x] * cos[u][x]
+			}
+		}
+	}
+	var coeffs [64]float64
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			for y := 0; y < n; y++ {
+				coeffs[v*8+u] += rows[y][u] * cos[v][y]
+			}
+		}
+	}
+
+	// The first coefficient is the average brightness and is left out of the median
+	sorted := append([]float64{}, coeffs[1:]...)
+	sort.Float64s(sorted)
+	median := sorted[len(sorted)/2]
+	var h uint64
+	for _, c := range coeffs {
+		h <<= 1
+		if c > median {
+			h |= 1
+		}
+	}
+	return h
+}
+
+// grayThumbnail scales an image down to w by h luminance values by averaging each area
+func grayThumbnail(img image.Image, w, h int) [][]float64 {
+	b := img.Bounds()
+	sums := make([][]float64, h)
+	counts := make([][]float64, h)
+	for y := range sums {
+		sums[y] = make([]float64, w)
+		counts[y] = make([]float64, w)
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		ty := (y - b.Min.Y) * h / b.Dy()
+		for x := b.Min.X; x < b.Max.X; x++ {
+			tx := (x - b.Min.X) * w / b.Dx()
+			r, g, bl, _ := img.At(x, y).RGBA()
+			sums[ty][tx] += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(bl)
+			counts[ty][tx]++
+		}
+	}
+	for y := range sums {
+		for x := range sums[y] {
+			if counts[y][x] > 0 {
+				sums[y][x] /= counts[y][x]
+			}
+		}
+	}
+	return sums
+}
+
+// Parameters of the piecewise hash, as used by spamsum and ssdeep
+const (
+	rollingWindow  = 7
+	minBlockSize   = 3
+	spamsumLength  = 64
+	fnvInit        = 0x28021967
+	fnvPrime       = 0x01000193
+	base64Alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+)
+
+// rollingHash is the hash of the last rollingWindow bytes that decides where pieces end
+type rollingHash struct {
+	window     [rollingWindow]byte
+	h1, h2, h3 uint32
+	n          int
+}
+
+func (r *rollingHash) roll(c byte) uint32 {
+	r.h2 -= r.h1
+	r.h2 += rollingWindow * uint32(c)
+	r.h1 += uint32(c)
+	r.h1 -= uint32(r.window[r.n%rollingWindow])
+	r.window[r.n%rollingWindow] = c
+	r.n++
+	r.h3 = r.h3<<5 ^ uint32(c)
+	return r.h1 + r.h2 + r.h3
+}
+
+// piecewiseHash computes a context triggered piecewise hash in the ssdeep format
+// "blocksize:signature:signature". The block size is chosen from the file size and
+// halved, reading the file again, until the signature is long enough to compare.
+func piecewiseHash(r io.ReadSeeker, size int64) (string, error) {
+	bs := minBlockSize
+	for int64(bs)*spamsumLength < size {
+		bs *= 2
+	}
+	for {
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+		sig1, sig2, err := piecewiseSignatures(bufio.NewReader(r), bs)
+		if err != nil {
+			return "", err
+		}
+		if bs > minBlockSize && len(sig1) < spamsumLength/2 {
+			bs /= 2
+			continue
+		}
+		return fmt.Sprintf("%d:%s:%s", bs, sig1, sig2), nil
+	}
+}
+
+// piecewiseSignatures hashes the pieces of a stream ending where the rolling hash hits the
+// block size, and of twice the block size
+func piecewiseSignatures(r io.ByteReader, bs int) (string, string, error) {
+	var roll rollingHash
+	var sig1, sig2 []byte
+	h1, h2 := uint32(fnvInit), uint32(fnvInit)
+	var last uint32
+	for {
+		c, err := r.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", "", err
+		}
+		h1 = h1*fnvPrime ^ uint32(c)
+		h2 = h2*fnvPrime ^ uint32(c)
+		last = roll.roll(c)
+		if last%uint32(bs) == uint32(bs-1) && len(sig1) < spamsumLength-1 {
+			sig1 = append(sig1, base64Alphabet[h1%64])
+			h1 = fnvInit
+		}
+		if last%uint32(2*bs) == uint32(2*bs-1) && len(sig2) < spamsumLength/2-1 {
+			sig2 = append(sig2, base64Alphabet[h2%64])
+			h2 = fnvInit
+		}
+	}
+	if last != 0 {
+		sig1 = append(sig1, base64Alphabet[h1%64])
+		sig2 = append(sig2, base64Alphabet[h2%64])
+	}
+	return string(sig1), string(sig2), nil
+}
+
+// comparePiecewise scores two piecewise hashes like ssdeep. Only signatures of the same
+// block size can be compared, so the block sizes must be equal or differ by a factor of two.
+func comparePiecewise(a, b fuzzyHash) int {
+	if a.blockSize != b.blockSize && a.blockSize != 2*b.blockSize && b.blockSize != 2*a.blockSize {
+		return 0
+	}
+	switch {
+	case a.blockSize == b.blockSize && a.sig1 == b.sig1:
+		return 100
+	case a.blockSize == b.blockSize:
+		return max(scoreSignatures(a.sig1, b.sig1, a.blockSize), scoreSignatures(a.sig2, b.sig2, 2*a.blockSize))
+	case a.blockSize == 2*b.blockSize:
+		return scoreSignatures(a.sig1, b.sig2, a.blockSize)
+	default:
+		return scoreSignatures(a.sig2, b.sig1, b.blockSize)
+	}
+}
+
+// collapseRuns shortens runs of more than three identical characters, which carry little information
+func collapseRuns(s string) string {
+	out := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if i >= 3 && s[i] == s[i-1] && s[i] == s[i-2] && s[i] == s[i-3] {
+			continue
+		}
+		out = append(out, s[i])
+	}
+	return string(out)
+}
+
+// scoreSignatures scores two signatures of the same block size by their edit distance.
+// Signatures must share a run of rollingWindow characters to be considered related at all.
+func scoreSignatures(s1, s2 string, bs int) int {
+	if len(s1) < rollingWindow || len(s2) < rollingWindow || !shareSubstring(s1, s2) {
+		return 0
+	}
+	score := editDistance(s1, s2) * spamsumLength / (len(s1) + len(s2))
+	score = 100 * score / spamsumLength
+	if score >= 100 {
+		return 0
+	}
+	score = 100 - score
+	// Short signatures of small files match by chance, so their score is capped
+	if bs < (99+rollingWindow)/rollingWindow*minBlockSize {
+		score = min(score, bs/minBlockSize*min(len(s1), len(s2)))
+	}
+	return score
+}
+
+// shareSubstring reports whether two strings have a substring of rollingWindow characters in common
+func shareSubstring(s1, s2 string) bool {
+	seen := make(map[string]bool, len(s1))
+	for i := 0; i+rollingWindow <= len(s1); i++ {
+		seen[s1[i:i+rollingWindow]] = true
+	}
+	for i := 0; i+rollingWindow <= len(s2); i++ {
+		if seen[s2[i:i+rollingWindow]] {
+			return true
+		}
+	}
+	return false
+}
+
+// editDistance counts the insertions and deletions turning s1 into s2, a change counting as two
+func editDistance(s1, s2 string) int {
+	prev := make([]int, len(s2)+1)
+	cur := make([]int, len(s2)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(s1); i++ {
+		cur[0] = i
+		for j := 1; j <= len(s2); j++ {
+			change := 2
+			if s1[i-1] == s2[j-1] {
+				change = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+change)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(s2)]
+}
@@ -0,0 +1,221 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math/bits"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// testImage draws a few soft shapes, scaled to the given width
+func testImage(w int, seed int64) image.Image {
+	h := w * 3 / 4
+	rng := rand.New(rand.NewSource(seed))
+	type blob struct{ x, y, r, v float64 }
+	var blobs []blob
+	for i := 0; i < 6; i++ {
+		blobs = append(blobs, blob{rng.Float64(), rng.Float64(), 0.1 + 0.3*rng.Float64(), 255 * rng.Float64()})
+	}
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			fx, fy := float64(x)/float64(w), float64(y)/float64(h)
+			v := 40.0
+			for _, b := range blobs {
+				if d := (fx-b.x)*(fx-b.x) + (fy-b.y)*(fy-b.y); d < b.r*b.r {
+					v = b.v
+				}
+			}
+			img.Set(x, y, color.RGBA{uint8(v), uint8(v / 2), uint8(255 - v), 255})
+		}
+	}
+	return img
+}
+
+// TestPerceptualHashes checks that resized and re-encoded images keep nearly the same hashes
+func TestPerceptualHashes(t *testing.T) {
+	orig := testImage(320, 1)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testImage(160, 1), &jpeg.Options{Quality: 60}); err != nil {
+		t.Fatalf("jpeg.Encode() err = %v; want nil", err)
+	}
+	reencoded, err := jpeg.Decode(&buf)
+	if err != nil {
+		t.Fatalf("jpeg.Decode() err = %v; want nil", err)
+	}
+	other := testImage(320, 2)
+
+	for name, hash := range map[string]func(image.Image) uint64{"DHash": DHash, "PHash": PHash} {
+		if d := bits.OnesCount64(hash(orig) ^ hash(reencoded)); d > 6 {
+			t.Errorf("%s distance of a resized JPEG copy = %d; want at most 6", name, d)
+		}
+		if d := bits.OnesCount64(hash(orig) ^ hash(other)); d < 12 {
+			t.Errorf("%s distance of a different image = %d; want at least 12", name, d)
+		}
+	}
+}
+
+// TestPiecewiseHash checks that small edits keep a high score and rearranged files a low one
+func TestPiecewiseHash(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	var words []string
+	for i := 0; i < 4000; i++ {
+		words = append(words, fmt.Sprintf("word%d", rng.Intn(500)))
+	}
+	text := strings.Join(words, " ")
+	edited := strings.Replace(text, words[2000], "EDITED", 1)
+	unrelated := strings.Join(words[2000:], " ") + strings.Join(words[:2000], "\n")
+
+	hash := func(s string) fuzzyHash {
+		sum, err := piecewiseHash(strings.NewReader(s), int64(len(s)))
+		if err != nil {
+			t.Fatalf("piecewiseHash() err = %v; want nil", err)
+		}
+		h, err := parseFuzzyHash(string(FuzzyContent) + ":" + sum)
+		if err != nil {
+			t.Fatalf("parseFuzzyHash() err = %v; want nil", err)
+		}
+		return h
+	}
+	orig := hash(text)
+	if got := orig.similarity(orig); got != 100 {
+		t.Errorf("similarity to itself = %d; want 100", got)
+	}
+	if got := orig.similarity(hash(edited)); got < 80 {
+		t.Errorf("similarity of an edited copy = %d; want at least 80", got)
+	}
+	if got := orig.similarity(hash(unrelated)); got >= 50 {
+		t.Errorf("similarity of a rearranged file = %d; want below 50", got)
+	}
+}
+
+// TestFindSimilar checks that images and documents are clustered, leaving out exact copies
+func TestFindSimilar(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, content []byte) ScannedFile {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		return ScannedFile{Path: path, Size: int64(len(content))}
+	}
+	encode := func(img image.Image, enc func(*bytes.Buffer, image.Image) error) []byte {
+		var buf bytes.Buffer
+		if err := enc(&buf, img); err != nil {
+			t.Fatalf("Failed to encode image: %v", err)
+		}
+		return buf.Bytes()
+	}
+	toPNG := func(b *bytes.Buffer, img image.Image) error { return png.Encode(b, img) }
+	toJPEG := func(b *bytes.Buffer, img image.Image) error { return jpeg.Encode(b, img, nil) }
+
+	rng := rand.New(rand.NewSource(2))
+	doc := make([]byte, 30000)
+	for i := range doc {
+		doc[i] = "abcdefgh \n"[rng.Intn(10)]
+	}
+	edited := append([]byte{}, doc...)
+	copy(edited[15000:], "a small edit")
+
+	files := []ScannedFile{
+		write("photo.png", encode(testImage(320, 1), toPNG)),
+		write("photo-small.jpg", encode(testImage(200, 1), toJPEG)),
+		write("other.png", encode(testImage(320, 2), toPNG)),
+		write("doc.txt", doc),
+		write("doc-edited.txt", edited),
+		write("copy1.txt", []byte(strings.Repeat("identical copy ", 500))),
+		write("copy2.txt", []byte(strings.Repeat("identical copy ", 500))),
+		{Path: "backup.zip!/doc.txt", Size: int64(len(doc)), Hashes: map[string]string{}},
+	}
+	exact := []DuplicateSet{{Files: []string{files[5].Path, files[6].Path}}}
+
+	sets, errs := FindSimilar(files, 2, DefaultSimilarity, exact, nil)
+	if len(errs) != 0 {
+		t.Fatalf("FindSimilar() errs = %v; want none", errs)
+	}
+	var got []string
+	for _, s := range sets {
+		var names []string
+		for _, f := range s.Files {
+			names = append(names, filepath.Base(f))
+		}
+		got = append(got, string(s.Kind)+" "+strings.Join(names, ","))
+	}
+	sort.Strings(got) // Ordered by similarity, which differs between the kinds
+	want := []string{"content doc-edited.txt,doc.txt", "image photo-small.jpg,photo.png"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("FindSimilar() = %q; want %q", got, want)
+	}
+}
+
+// TestCandidateKeys checks that every pair of hashes scoring at least the threshold shares a
+// candidate key, so clustering by the index finds the same pairs as comparing all of them
+func TestCandidateKeys(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	var hashes []fuzzyHash
+	for i := 0; i < 4; i++ {
+		d, p := rng.Uint64(), rng.Uint64()
+		for flips := 0; flips < 20; flips++ {
+			hashes = append(hashes, fuzzyHash{kind: FuzzyImage, dhash: d, phash: p})
+			d ^= 1 << rng.Intn(64)
+			p ^= 1 << rng.Intn(64)
+		}
+	}
+	for _, size := range []int{3000, 6000, 12000} {
+		doc := make([]byte, size)
+		for i := range doc {
+			doc[i] = "abcdefgh \n"[rng.Intn(10)]
+		}
+		for edits := 0; edits < 8; edits++ {
+			sum, err := piecewiseHash(bytes.NewReader(doc), int64(len(doc)))
+			if err != nil {
+				t.Fatalf("piecewiseHash() err = %v; want nil", err)
+			}
+			h, err := parseFuzzyHash(string(FuzzyContent) + ":" + sum)
+			if err != nil {
+				t.Fatalf("parseFuzzyHash() err = %v; want nil", err)
+			}
+			hashes = append(hashes, h)
+			at := rng.Intn(len(doc) - 50)
+			copy(doc[at:], "an edit of a few words")
+			doc = append(doc, doc[:size/10]...)
+		}
+	}
+	hashes = append(hashes, hashes[0], hashes[len(hashes)-1]) // Identical hashes score 100
+
+	for _, threshold := range []int{1, 50, DefaultSimilarity, 100} {
+		similar := 0
+		for i, a := range hashes {
+			keys := make(map[string]bool)
+			for _, k := range a.candidateKeys(threshold) {
+				keys[k] = true
+			}
+			for _, b := range hashes[i+1:] {
+				if a.similarity(b) < threshold {
+					continue
+				}
+				similar++
+				shared := false
+				for _, k := range b.candidateKeys(threshold) {
+					shared = shared || keys[k]
+				}
+				if !shared {
+					t.Errorf("hashes %+v and %+v score %d but share no candidate key at %d%%", a, b, a.similarity(b), threshold)
+				}
+			}
+		}
+		if similar == 0 {
+			t.Errorf("no similar pairs at %d%%; want some to check", threshold)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package internal
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"sort"
+	"strings"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/zeebo/blake3"
+	"golang.org/x/crypto/blake2b"
+)
+
+// DefaultHashAlgorithm is used when no algorithm is selected
+const DefaultHashAlgorithm = "sha256"
+
+// hashAlgorithm describes a supported hash function
+type hashAlgorithm struct {
+	tag string // Name used in BSD tag style manifests
+	new func() hash.Hash
+}
+
+// hashAlgorithms are the supported hash functions keyed by the name used on the command line
+var hashAlgorithms = map[string]hashAlgorithm{
+	"md5":     {tag: "MD5", new: md5.New},
+	"sha1":    {tag: "SHA1", new: sha1.New},
+	"sha256":  {tag: "SHA256", new: sha256.New},
+	"sha512":  {tag: "SHA512", new: sha512.New},
+	"blake2b": {tag: "BLAKE2b", new: newBlake2b},
+	"blake3":  {tag: "BLAKE3", new: func() hash.Hash { return blake3.New() }},
+	"xxhash":  {tag: "XXH64", new: func() hash.Hash { return xxhash.New() }},
+}
+
+// newBlake2b returns an unkeyed BLAKE2b-512 hash, the variant written by b2sum
+func newBlake2b() hash.Hash {
+	h, _ := blake2b.New512(nil) // Only fails for keys longer than 64 bytes
+	return h
+}
+
+// HashAlgorithmNames returns the names of all supported algorithms in sorted order
+func HashAlgorithmNames() []string {
+	names := make([]string, 0, len(hashAlgorithms))
+	for name := range hashAlgorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseHashAlgorithms validates algorithm names, accepting comma separated lists and dropping repeats
+func ParseHashAlgorithms(names []string) ([]string, error) {
+	var algos []string
+	seen := make(map[string]bool)
+	for _, list := range names {
+		for _, name := range strings.Split(list, ",") {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if _, ok := hashAlgorithms[name]; !ok {
+				return nil, fmt.Errorf("unknown hash algorithm %q (want one of %s)", name, strings.Join(HashAlgorithmNames(), ", "))
+			}
+			if !seen[name] {
+				seen[name] = true
+				algos = append(algos, name)
+			}
+		}
+	}
+	if len(algos) == 0 {
+		algos = []string{DefaultHashAlgorithm}
+	}
+	return algos, nil
+}
+
+// newHash creates a hash for a validated algorithm name
+func newHash(algo string) hash.Hash {
+	return hashAlgorithms[algo].new()
+}
+
+// hashTag returns the BSD tag of an algorithm
+func hashTag(algo string) string {
+	return hashAlgorithms[algo].tag
+}
+
+// algorithmForTag returns the algorithm written with the given BSD tag
+func algorithmForTag(tag string) (string, bool) {
+	for name, a := range hashAlgorithms {
+		if strings.EqualFold(a.tag, tag) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// algorithmForLength guesses the algorithm of a bare hex digest from its length.
+// Several algorithms produce 256 and 512 bit digests, SHA-2 is assumed for those.
+func algorithmForLength(hexLen int) string {
+	switch hexLen {
+	case 16:
+		return "xxhash"
+	case 32:
+		return "md5"
+	case 40:
+		return "sha1"
+	case 128:
+		return "sha512"
+	default:
+		return "sha256"
+	}
+}
@@ -0,0 +1,296 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// hashCacheSettle is how long a file must be unmodified before its hash is cached. A file
+// changed again within the timestamp granularity could otherwise keep a stale cache entry.
+const hashCacheSettle = 2 * time.Second
+
+// hashCacheKey identifies the contents of a file by its metadata. Any write changes the
+// modification or change time, and replacing the file changes the inode.
+type hashCacheKey struct {
+	Dev   uint64 `json:"dev"`
+	Ino   uint64 `json:"ino"`
+	Size  int64  `json:"size"`
+	MTime int64  `json:"mtime"` // Nanoseconds since the epoch
+	CTime int64  `json:"ctime"` // Nanoseconds since the epoch
+}
+
+// hashCacheRecord is a line of the cache file
+type hashCacheRecord struct {
+	hashCacheKey
+	Path   string            `json:"path"`
+	Hashes map[string]string `json:"hashes"`
+}
+
+// HashCache remembers file hashes between runs in an append-only JSON lines file.
+// A nil *HashCache hashes every file. It is safe for concurrent use.
+type HashCache struct {
+	mu      sync.Mutex
+	file    *os.File
+	entries map[hashCacheKey]*hashCacheRecord
+	hits    int
+	misses  int
+	failed  bool // Whether writing the cache failed, so the warning is only printed once
+}
+
+// DefaultHashCachePath returns the cache file in the user's cache directory
+func DefaultHashCachePath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "admin-cli", "hashcheck.jsonl"), nil
+}
+
+// OpenHashCache loads the cache file at path, creating it if needed
+func OpenHashCache(path string) (*HashCache, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("error creating cache directory: %v", err)
+	}
+	entries, err := loadHashCache(path)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("error opening hash cache: %v", err)
+	}
+	return &HashCache{file: file, entries: entries}, nil
+}
+
+// loadHashCache reads every record of a cache file, merging the hashes recorded for the same key.
+// Unreadable lines, such as one cut short by a crash, are ignored.
+func loadHashCache(path string) (map[hashCacheKey]*hashCacheRecord, error) {
+	entries := make(map[hashCacheKey]*hashCacheRecord)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading hash cache: %v", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var r hashCacheRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil || r.Hashes == nil {
+			continue
+		}
+		if e, ok := entries[r.hashCacheKey]; ok {
+			e.Path = r.Path
+			for algo, hash := range r.Hashes {
+				e.Hashes[algo] = hash
+			}
+		} else {
+			entries[r.hashCacheKey] = &r
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// Close closes the cache file
+func (c *HashCache) Close() error {
+	if c == nil {
+		return nil
+	}
+	return c.file.Close()
+}
+
+// Stats returns how many files were served from the cache and how many had to be read
+func (c *HashCache) Stats() (hits, misses int) {
+	if c == nil {
+		return 0, 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses
+}
+
+// FileHash returns the hash of a file, see FileHashes
+func (c *HashCache) FileHash(path, algo string) (string, error) {
+	hashes, err := c.FileHashes(path, []string{algo})
+	if err != nil {
+		return "", err
+	}
+	return hashes[algo], nil
+}
+
+// FileHashes returns the hashes of a file, reading it only if its metadata changed since
+// the hashes were cached or some of the algorithms were never computed for it.
+func (c *HashCache) FileHashes(path string, algos []string) (map[string]string, error) {
+	return c.hashes(path, algos, func(missing []string) (map[string]string, error) {
+		return ComputeFileHashes(path, missing)
+	})
+}
+
+// PartialHash returns the hash of both ends of a file, see ComputePartialHash
+func (c *HashCache) PartialHash(path string, size int64, algo string) (string, error) {
+	name := partialHashName(algo) // Cached next to the full hashes under its own name
+	hashes, err := c.hashes(path, []string{name}, func([]string) (map[string]string, error) {
+		hash, err := ComputePartialHash(path, size, algo)
+		return map[string]string{name: hash}, err
+	})
+	if err != nil {
+		return "", err
+	}
+	return hashes[name], nil
+}
+
+// FuzzyHash returns the fuzzy hash of a file, see ComputeFuzzyHash
+func (c *HashCache) FuzzyHash(path string) (string, error) {
+	hashes, err := c.hashes(path, []string{fuzzyHashName}, func([]string) (map[string]string, error) {
+		hash, err := ComputeFuzzyHash(path)
+		return map[string]string{fuzzyHashName: hash}, err
+	})
+	if err != nil {
+		return "", err
+	}
+	return hashes[fuzzyHashName], nil
+}
+
+// hashes looks up the named hashes of a file and calls compute for those not in the cache
+func (c *HashCache) hashes(path string, names []string, compute func(missing []string) (map[string]string, error)) (map[string]string, error) {
+	if c == nil {
+		return compute(names)
+	}
+
+	before, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := statKey(before)
+	if !ok {
+		return compute(names)
+	}
+
+	c.mu.Lock()
+	hashes, missing := c.lookup(key, names)
+	if len(missing) == 0 {
+		c.hits++
+		c.mu.Unlock()
+		return hashes, nil
+	}
+	c.misses++
+	c.mu.Unlock()
+
+	computed, err := compute(missing)
+	if err != nil {
+		return nil, err
+	}
+	for algo, hash := range computed {
+		hashes[algo] = hash
+	}
+
+	// Only cache hashes of files that did not change while being read and have settled
+	after, err := os.Stat(path)
+	if err != nil {
+		return hashes, nil
+	}
+	if afterKey, ok := statKey(after); !ok || afterKey != key || time.Since(after.ModTime()) < hashCacheSettle {
+		return hashes, nil
+	}
+	c.store(key, path, computed)
+	return hashes, nil
+}
+
+// lookup returns the cached hashes for a key and the names of those that are not cached
+func (c *HashCache) lookup(key hashCacheKey, names []string) (map[string]string, []string) {
+	hashes := make(map[string]string, len(names))
+	var missing []string
+	for _, name := range names {
+		if e, ok := c.entries[key]; ok && e.Hashes[name] != "" {
+			hashes[name] = e.Hashes[name]
+		} else {
+			missing = append(missing, name)
+		}
+	}
+	return hashes, missing
+}
+
+// store records newly computed hashes in memory and appends them to the cache file.
+// Failing to write the cache does not fail the hashing, it only prints a warning.
+func (c *HashCache) store(key hashCacheKey, path string, hashes map[string]string) {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs // Compaction stats the path from any working directory
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		e = &hashCacheRecord{hashCacheKey: key, Hashes: make(map[string]string)}
+		c.entries[key] = e
+	}
+	e.Path = path
+	for algo, hash := range hashes {
+		e.Hashes[algo] = hash
+	}
+
+	line, err := json.Marshal(hashCacheRecord{hashCacheKey: key, Path: path, Hashes: hashes})
+	if err == nil {
+		// A single write per line keeps concurrent runs from interleaving records
+		_, err = c.file.Write(append(line, '\n'))
+	}
+	if err != nil && !c.failed {
+		c.failed = true
+		fmt.Fprintf(os.Stderr, "Warning: error writing hash cache: %v\n", err)
+	}
+}
+
+// CompactHashCache rewrites the cache file with one record per file, dropping entries for
+// files that were deleted or changed since they were hashed. It returns the number of
+// records kept and dropped. Runs appending to the cache at the same time may lose entries.
+func CompactHashCache(path string) (kept, dropped int, err error) {
+	entries, err := loadHashCache(path)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".hashcheck-*.jsonl")
+	if err != nil {
+		return 0, 0, fmt.Errorf("error creating compacted cache: %v", err)
+	}
+	defer os.Remove(tmp.Name()) // Fails harmlessly once renamed
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for key, e := range entries {
+		info, err := os.Stat(e.Path)
+		if err != nil {
+			dropped++
+			continue
+		}
+		if current, ok := statKey(info); !ok || current != key {
+			dropped++
+			continue
+		}
+		if err := enc.Encode(e); err != nil {
+			tmp.Close()
+			return 0, 0, err
+		}
+		kept++
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return 0, 0, fmt.Errorf("error writing compacted cache: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, 0, fmt.Errorf("error writing compacted cache: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return 0, 0, fmt.Errorf("error replacing hash cache: %v", err)
+	}
+	return kept, dropped, nil
+}
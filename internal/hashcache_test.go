@@ -0,0 +1,72 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestHashCache checks that unchanged files are served from the cache across runs and changed files are re-read
+func TestHashCache(t *testing.T) {
+	dir := t.TempDir()
+	cachePath := filepath.Join(dir, "cache", "hashes.jsonl")
+	file := filepath.Join(dir, "file")
+	old := time.Now().Add(-time.Hour) // Older than hashCacheSettle so the hash is cached
+
+	write := func(content string) {
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		if err := os.Chtimes(file, old, old); err != nil {
+			t.Fatalf("Failed to set file times: %v", err)
+		}
+	}
+	hash := func(cache *HashCache) string {
+		h, err := cache.FileHash(file, "sha256")
+		if err != nil {
+			t.Fatalf("FileHash() err = %v; want nil", err)
+		}
+		return h
+	}
+
+	write("first")
+	cache, err := OpenHashCache(cachePath)
+	if err != nil {
+		t.Fatalf("OpenHashCache() err = %v; want nil", err)
+	}
+	first := hash(cache)
+	cache.Close()
+
+	// A new run reuses the stored hash without reading the file
+	cache, err = OpenHashCache(cachePath)
+	if err != nil {
+		t.Fatalf("OpenHashCache() err = %v; want nil", err)
+	}
+	if got := hash(cache); got != first {
+		t.Errorf("FileHash() = %s; want cached %s", got, first)
+	}
+	if hits, misses := cache.Stats(); hits != 1 || misses != 0 {
+		t.Errorf("Stats() = %d, %d; want 1 hit and no misses", hits, misses)
+	}
+
+	// Same size and modification time, but the change time moves on
+	write("other")
+	want, err := ComputeFileHash(file, "sha256")
+	if err != nil {
+		t.Fatalf("ComputeFileHash() err = %v; want nil", err)
+	}
+	if got := hash(cache); got != want {
+		t.Errorf("FileHash() after change = %s; want %s", got, want)
+	}
+	cache.Close()
+
+	// Compaction keeps only the entry for the current contents
+	kept, dropped, err := CompactHashCache(cachePath)
+	if err != nil {
+		t.Fatalf("CompactHashCache() err = %v; want nil", err)
+	}
+	if kept != 1 || dropped != 1 {
+		t.Errorf("CompactHashCache() = %d, %d; want 1 kept and 1 dropped", kept, dropped)
+	}
+}
@@ -0,0 +1,271 @@
+package internal
+
+import (
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"sync"
+)
+
+// ReadStrategy selects how files are read for hashing. Run 'go test -bench Hash ./internal'
+// from a source checkout to compare the strategies on a machine.
+type ReadStrategy string
+
+const (
+	ReadAuto     ReadStrategy = "auto"     // Memory map large files, read the rest into pooled buffers
+	ReadBuffered ReadStrategy = "buffered" // Read ahead into pooled buffers while the previous one is hashed
+	ReadMmap     ReadStrategy = "mmap"     // Memory map every file and hash the mapping
+	ReadSimple   ReadStrategy = "simple"   // io.Copy with its default buffer, reading and hashing in turn
+)
+
+// ParseReadStrategy validates a read strategy name
+func ParseReadStrategy(s string) (ReadStrategy, error) {
+	switch r := ReadStrategy(s); r {
+	case ReadAuto, ReadBuffered, ReadMmap, ReadSimple:
+		return r, nil
+	default:
+		return "", fmt.Errorf("invalid read strategy %q (want auto, buffered, mmap or simple)", s)
+	}
+}
+
+// HashIOOptions controls how files are read and hashed. Reading and hashing are separate stages:
+// the hashcheck workers (--routines) bound how many files are read at once, DeviceReads how many
+// of those may read from the same disk and CPUWorkers how many chunks are hashed at once.
+type HashIOOptions struct {
+	Strategy      ReadStrategy
+	BufferSize    int   // Size of the pooled read buffers
+	MmapThreshold int64 // Files from this size on are memory mapped by ReadAuto
+	DeviceReads   int   // Concurrent reads per device, 0 for one on spinning disks and no limit otherwise
+	CPUWorkers    int   // Chunks hashed at once, 0 for one per CPU
+}
+
+// DefaultHashIOOptions returns the options used unless ConfigureHashIO is called
+func DefaultHashIOOptions() HashIOOptions {
+	return HashIOOptions{
+		Strategy:      ReadAuto,
+		BufferSize:    1 << 20,
+		MmapThreshold: 64 << 20,
+	}
+}
+
+// hashIO reads every file hashed by this package, so the limits apply across all worker pools
+var hashIO = newHashScheduler(DefaultHashIOOptions())
+
+// ConfigureHashIO replaces the read options. It must be called before any file is hashed.
+func ConfigureHashIO(opts HashIOOptions) error {
+	if _, err := ParseReadStrategy(string(opts.Strategy)); err != nil {
+		return err
+	}
+	if opts.BufferSize < 4096 {
+		return fmt.Errorf("buffer size must be at least 4 KiB")
+	}
+	if opts.DeviceReads < 0 || opts.CPUWorkers < 0 {
+		return fmt.Errorf("device reads and CPU workers cannot be negative")
+	}
+	hashIO = newHashScheduler(opts)
+	return nil
+}
+
+// hashScheduler hands out read slots per device, hashing slots and read buffers
+type hashScheduler struct {
+	opts    HashIOOptions
+	cpu     chan struct{}
+	buffers sync.Pool
+
+	mu      sync.Mutex
+	devices map[uint64]chan struct{} // nil for devices without a limit
+}
+
+func newHashScheduler(opts HashIOOptions) *hashScheduler {
+	if opts.CPUWorkers == 0 {
+		opts.CPUWorkers = runtime.NumCPU()
+	}
+	s := &hashScheduler{
+		opts:    opts,
+		cpu:     make(chan struct{}, opts.CPUWorkers),
+		devices: make(map[uint64]chan struct{}),
+	}
+	s.buffers.New = func() any {
+		buf := make([]byte, opts.BufferSize)
+		return &buf
+	}
+	return s
+}
+
+// acquireDevice waits for a read slot on the device holding the file and returns its release function
+func (s *hashScheduler) acquireDevice(info os.FileInfo) func() {
+	key, ok := statKey(info)
+	if !ok {
+		return func() {}
+	}
+	s.mu.Lock()
+	slots, known := s.devices[key.Dev]
+	if !known {
+		limit := s.opts.DeviceReads
+		if limit == 0 && isRotational(key.Dev) {
+			limit = 1 // Concurrent reads make a spinning disk seek back and forth
+		}
+		if limit > 0 {
+			slots = make(chan struct{}, limit)
+		}
+		s.devices[key.Dev] = slots
+	}
+	s.mu.Unlock()
+
+	if slots == nil {
+		return func() {}
+	}
+	slots <- struct{}{}
+	return func() { <-slots }
+}
+
+// hash writes p to the hashes while holding a hashing slot
+func (s *hashScheduler) hash(w io.Writer, p []byte) {
+	s.cpu <- struct{}{}
+	defer func() { <-s.cpu }() // Also released when a mapped file faults
+	w.Write(p)                 // Hashes never return errors
+}
+
+// hashFile computes several hashes of a file in a single read pass, keyed by algorithm
+func (s *hashScheduler) hashFile(path string, algos []string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	// Every hash sees the same bytes as the file is read once
+	hashers := make([]hash.Hash, len(algos))
+	writers := make([]io.Writer, len(algos))
+	for i, algo := range algos {
+		hashers[i] = newHash(algo)
+		writers[i] = hashers[i]
+	}
+	w := io.MultiWriter(writers...)
+
+	release := s.acquireDevice(info)
+	defer release()
+	size := info.Size()
+	switch {
+	case s.opts.Strategy == ReadSimple:
+		_, err = io.Copy(w, file)
+	case size > 0 && (s.opts.Strategy == ReadMmap || (s.opts.Strategy == ReadAuto && size >= s.opts.MmapThreshold)):
+		err = s.hashMapped(w, file, size)
+	default:
+		err = s.hashBuffered(w, file, size)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make(map[string]string, len(algos))
+	for i, algo := range algos {
+		hashes[algo] = hex.EncodeToString(hashers[i].Sum(nil))
+	}
+	return hashes, nil
+}
+
+// hashMapped hashes a memory mapping of the file in buffer sized chunks,
+// falling back to reading where mapping is not possible
+func (s *hashScheduler) hashMapped(w io.Writer, file *os.File, size int64) (err error) {
+	data, unmap, err := mmapFile(file, size)
+	if err != nil {
+		return s.hashBuffered(w, file, size)
+	}
+	defer unmap()
+
+	// A file truncated while mapped faults instead of returning a read error
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("file changed while it was read: %v", r)
+		}
+	}()
+	adviseSequential(data)
+	for off := 0; off < len(data); off += s.opts.BufferSize {
+		s.hash(w, data[off:min(off+s.opts.BufferSize, len(data))])
+	}
+	return nil
+}
+
+// hashBuffered reads the file into pooled buffers. Files larger than one buffer are read
+// ahead by a second goroutine, so the disk is busy while the previous chunk is hashed.
+func (s *hashScheduler) hashBuffered(w io.Writer, file *os.File, size int64) error {
+	if size <= int64(s.opts.BufferSize) {
+		buf := s.buffers.Get().(*[]byte)
+		n, err := io.ReadFull(file, *buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			s.buffers.Put(buf)
+			return err
+		}
+		if n == len(*buf) {
+			// The file grew since it was opened, hash whatever follows too
+			return s.hashChunks(w, file, buf, n)
+		}
+		s.hash(w, (*buf)[:n])
+		s.buffers.Put(buf)
+		return nil
+	}
+	return s.hashChunks(w, file, nil, 0)
+}
+
+// chunk is a filled read buffer
+type chunk struct {
+	buf *[]byte
+	n   int
+}
+
+// hashChunks reads the rest of a file ahead into pooled buffers while hashing, after first
+// hashing n bytes already read into first, if any
+func (s *hashScheduler) hashChunks(w io.Writer, file *os.File, first *[]byte, n int) error {
+	chunks := make(chan chunk, 2)
+	var readErr error
+	go func() {
+		defer close(chunks)
+		if first != nil {
+			chunks <- chunk{first, n}
+		}
+		for {
+			buf := s.buffers.Get().(*[]byte)
+			n, err := io.ReadFull(file, *buf)
+			if n > 0 {
+				chunks <- chunk{buf, n}
+			} else {
+				s.buffers.Put(buf)
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return
+			}
+			if err != nil {
+				readErr = err
+				return
+			}
+		}
+	}()
+
+	for c := range chunks {
+		s.hash(w, (*c.buf)[:c.n])
+		s.buffers.Put(c.buf)
+	}
+	return readErr // Set before chunks was closed
+}
+
+// readAt reads len(p) bytes at off while holding the read slot of the file's device
+func (s *hashScheduler) readAt(file *os.File, p []byte, off int64) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	release := s.acquireDevice(info)
+	defer release()
+	_, err = file.ReadAt(p, off)
+	return err
+}
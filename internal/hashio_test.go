@@ -0,0 +1,131 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// writeRandomFile creates a file of the given size with reproducible contents
+func writeRandomFile(tb testing.TB, dir string, size int) (string, []byte) {
+	tb.Helper()
+	data := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(data)
+	path := filepath.Join(dir, fmt.Sprintf("file-%d", size))
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		tb.Fatalf("Failed to write file: %v", err)
+	}
+	return path, data
+}
+
+// TestHashStrategies checks that every read strategy hashes files around the buffer size alike
+func TestHashStrategies(t *testing.T) {
+	dir := t.TempDir()
+	const bufSize = 4096
+	for _, strategy := range []ReadStrategy{ReadAuto, ReadBuffered, ReadMmap, ReadSimple} {
+		s := newHashScheduler(HashIOOptions{Strategy: strategy, BufferSize: bufSize, MmapThreshold: 2 * bufSize})
+		for _, size := range []int{0, 1, bufSize - 1, bufSize, bufSize + 1, 3*bufSize + 17} {
+			path, data := writeRandomFile(t, dir, size)
+			sum := sha256.Sum256(data)
+			hashes, err := s.hashFile(path, []string{"sha256", "md5"})
+			if err != nil {
+				t.Fatalf("%s: hashFile(%d bytes) err = %v; want nil", strategy, size, err)
+			}
+			if want := hex.EncodeToString(sum[:]); hashes["sha256"] != want {
+				t.Errorf("%s: hashFile(%d bytes) = %s; want %s", strategy, size, hashes["sha256"], want)
+			}
+		}
+	}
+
+	if _, err := ParseReadStrategy("fastest"); err == nil {
+		t.Errorf("ParseReadStrategy(fastest) err = nil; want error")
+	}
+}
+
+// TestDeviceReads checks that reads from one device wait for a free slot
+func TestDeviceReads(t *testing.T) {
+	path, _ := writeRandomFile(t, t.TempDir(), 10)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("os.Stat() err = %v; want nil", err)
+	}
+	if _, ok := statKey(info); !ok {
+		t.Skip("Device numbers are not available on this platform")
+	}
+
+	s := newHashScheduler(HashIOOptions{Strategy: ReadBuffered, BufferSize: 4096, DeviceReads: 1})
+	release := s.acquireDevice(info)
+	acquired := make(chan struct{})
+	go func() {
+		s.acquireDevice(info)()
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatalf("Second read started while the only slot was taken")
+	case <-time.After(50 * time.Millisecond):
+	}
+	release()
+	select {
+	case <-acquired:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Second read did not start after the slot was released")
+	}
+}
+
+// BenchmarkHashStrategies reports the MB/s of each read strategy for small and large files.
+// Files are in the page cache after the first iteration, so this measures the CPU side;
+// drop the caches between runs to compare disk throughput.
+func BenchmarkHashStrategies(b *testing.B) {
+	dir := b.TempDir()
+	for _, size := range []int{64 << 10, 8 << 20, 128 << 20} {
+		path, _ := writeRandomFile(b, dir, size)
+		for _, strategy := range []ReadStrategy{ReadSimple, ReadBuffered, ReadMmap} {
+			s := newHashScheduler(HashIOOptions{Strategy: strategy, BufferSize: 1 << 20})
+			b.Run(fmt.Sprintf("%s/%s", strategy, FormatByteSize(int64(size))), func(b *testing.B) {
+				b.SetBytes(int64(size))
+				for i := 0; i < b.N; i++ {
+					if _, err := s.hashFile(path, []string{"sha256"}); err != nil {
+						b.Fatalf("hashFile() err = %v; want nil", err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkHashFilesWorkers reports the MB/s of hashing many files with different pool sizes
+func BenchmarkHashFilesWorkers(b *testing.B) {
+	dir := b.TempDir()
+	const count, size = 256, 256 << 10
+	var paths []string
+	for i := 0; i < count; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("file-%d", i))
+		if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+			b.Fatalf("Failed to write file: %v", err)
+		}
+		paths = append(paths, path)
+	}
+
+	for i, workers := range []int{1, runtime.NumCPU(), 4 * runtime.NumCPU()} {
+		if i == 1 && workers == 1 {
+			continue // A single CPU
+		}
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			b.SetBytes(count * size)
+			for i := 0; i < b.N; i++ {
+				if _, errs := HashFiles(paths, workers, func(path string) (string, error) {
+					return ComputeFileHash(path, "sha256")
+				}); len(errs) != 0 {
+					b.Fatalf("HashFiles() errs = %v; want none", errs)
+				}
+			}
+		})
+	}
+}
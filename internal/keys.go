@@ -0,0 +1,152 @@
+package internal
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"golang.org/x/crypto/ssh"
+)
+
+// Key types of generated TLS certificates
+const (
+	KeyTypeECDSA   = "ecdsa"   // P-256
+	KeyTypeEd25519 = "ed25519" // Not supported by some older clients and browsers
+	KeyTypeRSA     = "rsa"     // 3072 bits
+)
+
+// DefaultCertValidity is how long generated TLS certificates are valid for
+const DefaultCertValidity = 365 * 24 * time.Hour
+
+// GenerateAgeKey returns a new age X25519 identity file in the format of age-keygen, with
+// creation time and public key comments, and the public key (recipient) separately
+func GenerateAgeKey() (identityFile []byte, recipient string, err error) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to generate age key: %v", err)
+	}
+	recipient = identity.Recipient().String()
+	identityFile = fmt.Appendf(nil, "# created: %s\n# public key: %s\n%s\n",
+		time.Now().Format(time.RFC3339), recipient, identity)
+	return identityFile, recipient, nil
+}
+
+// GenerateSSHKey returns a new Ed25519 SSH private key in OpenSSH format, encrypted with
+// passphrase unless it is empty, and its authorized_keys line with comment
+func GenerateSSHKey(comment, passphrase string) (privateKey, authorizedKey []byte, err error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate key: %v", err)
+	}
+	var block *pem.Block
+	if passphrase == "" {
+		block, err = ssh.MarshalPrivateKey(priv, comment)
+	} else {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(priv, comment, []byte(passphrase))
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return nil, nil, err
+	}
+	authorizedKey = ssh.MarshalAuthorizedKey(sshPub) // Ends with a newline
+	if comment != "" {
+		authorizedKey = fmt.Appendf(nil, "%s %s\n", strings.TrimSpace(string(authorizedKey)), comment)
+	}
+	return pem.EncodeToMemory(block), authorizedKey, nil
+}
+
+// CertOptions are the parameters of a self-signed TLS certificate
+type CertOptions struct {
+	Hosts      []string      // DNS names and IP addresses the certificate is valid for
+	CommonName string        // Subject common name, the first host when empty
+	ValidFor   time.Duration // Validity from now, DefaultCertValidity when zero
+	KeyType    string        // KeyTypeECDSA when empty
+	IsCA       bool          // Whether the certificate can sign other certificates
+}
+
+// GenerateSelfSignedCert returns a self-signed TLS server certificate and its PKCS #8
+// private key, both PEM encoded
+func GenerateSelfSignedCert(opts CertOptions) (certPEM, keyPEM []byte, err error) {
+	if len(opts.Hosts) == 0 && !opts.IsCA {
+		return nil, nil, fmt.Errorf("at least one host is required")
+	}
+	if opts.ValidFor == 0 {
+		opts.ValidFor = DefaultCertValidity
+	}
+	if opts.ValidFor < 0 {
+		return nil, nil, fmt.Errorf("validity must be positive")
+	}
+	if opts.CommonName == "" && len(opts.Hosts) > 0 {
+		opts.CommonName = opts.Hosts[0]
+	}
+
+	var key crypto.Signer
+	keyUsage := x509.KeyUsageDigitalSignature
+	switch opts.KeyType {
+	case KeyTypeECDSA, "":
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyTypeEd25519:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	case KeyTypeRSA:
+		key, err = rsa.GenerateKey(rand.Reader, 3072)
+		keyUsage |= x509.KeyUsageKeyEncipherment // For RSA key exchange before TLS 1.3
+	default:
+		return nil, nil, fmt.Errorf("invalid key type %q (want ecdsa, ed25519 or rsa)", opts.KeyType)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate key: %v", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read random numbers: %v", err)
+	}
+	notBefore := time.Now().Add(-5 * time.Minute) // Tolerate clocks running slightly behind
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: opts.CommonName},
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(opts.ValidFor),
+		KeyUsage:              keyUsage,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, host := range opts.Hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	if opts.IsCA {
+		template.IsCA = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, key.Public(), key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
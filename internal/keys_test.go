@@ -0,0 +1,128 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"golang.org/x/crypto/ssh"
+)
+
+// TestGenerateAgeKey checks that the identity file parses and decrypts for its recipient
+func TestGenerateAgeKey(t *testing.T) {
+	file, recipient, err := GenerateAgeKey()
+	if err != nil {
+		t.Fatalf("GenerateAgeKey() err = %v; want nil", err)
+	}
+	if !strings.Contains(string(file), "# public key: "+recipient+"\n") {
+		t.Errorf("GenerateAgeKey() identity file %q lacks the public key comment", file)
+	}
+	identities, err := age.ParseIdentities(bytes.NewReader(file))
+	if err != nil {
+		t.Fatalf("ParseIdentities() err = %v; want nil", err)
+	}
+	r, err := age.ParseX25519Recipient(recipient)
+	if err != nil {
+		t.Fatalf("ParseX25519Recipient() err = %v; want nil", err)
+	}
+
+	var encrypted bytes.Buffer
+	w, err := age.Encrypt(&encrypted, r)
+	if err != nil {
+		t.Fatalf("Encrypt() err = %v; want nil", err)
+	}
+	io.WriteString(w, "secret")
+	w.Close()
+	dr, err := age.Decrypt(&encrypted, identities...)
+	if err != nil {
+		t.Fatalf("Decrypt() err = %v; want nil", err)
+	}
+	if plain, _ := io.ReadAll(dr); string(plain) != "secret" {
+		t.Errorf("Decrypt() = %q; want secret", plain)
+	}
+}
+
+// TestGenerateSSHKey checks that OpenSSH keys parse, with and without a passphrase
+func TestGenerateSSHKey(t *testing.T) {
+	private, public, err := GenerateSSHKey("admin@example", "")
+	if err != nil {
+		t.Fatalf("GenerateSSHKey() err = %v; want nil", err)
+	}
+	signer, err := ssh.ParsePrivateKey(private)
+	if err != nil {
+		t.Fatalf("ParsePrivateKey() err = %v; want nil", err)
+	}
+	pub, comment, _, _, err := ssh.ParseAuthorizedKey(public)
+	if err != nil {
+		t.Fatalf("ParseAuthorizedKey() err = %v; want nil", err)
+	}
+	if comment != "admin@example" || !bytes.Equal(pub.Marshal(), signer.PublicKey().Marshal()) {
+		t.Errorf("GenerateSSHKey() public key %q does not match the private key", public)
+	}
+	if pub.Type() != ssh.KeyAlgoED25519 {
+		t.Errorf("GenerateSSHKey() key type = %s; want %s", pub.Type(), ssh.KeyAlgoED25519)
+	}
+
+	private, _, err = GenerateSSHKey("", "hunter2")
+	if err != nil {
+		t.Fatalf("GenerateSSHKey() err = %v; want nil", err)
+	}
+	if _, err := ssh.ParsePrivateKey(private); err == nil {
+		t.Errorf("ParsePrivateKey() of an encrypted key err = nil; want a passphrase error")
+	}
+	if _, err := ssh.ParsePrivateKeyWithPassphrase(private, []byte("hunter2")); err != nil {
+		t.Errorf("ParsePrivateKeyWithPassphrase() err = %v; want nil", err)
+	}
+}
+
+// TestGenerateSelfSignedCert checks the hosts, key types and self-signature of certificates
+func TestGenerateSelfSignedCert(t *testing.T) {
+	for _, keyType := range []string{KeyTypeECDSA, KeyTypeEd25519, KeyTypeRSA} {
+		certPEM, keyPEM, err := GenerateSelfSignedCert(CertOptions{
+			Hosts:   []string{"example.com", "10.0.0.1", "::1"},
+			KeyType: keyType,
+		})
+		if err != nil {
+			t.Fatalf("GenerateSelfSignedCert(%s) err = %v; want nil", keyType, err)
+		}
+		block, _ := pem.Decode(certPEM)
+		if block == nil || block.Type != "CERTIFICATE" {
+			t.Fatalf("GenerateSelfSignedCert(%s) certificate is not PEM encoded", keyType)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatalf("ParseCertificate() err = %v; want nil", err)
+		}
+		if cert.Subject.CommonName != "example.com" || len(cert.DNSNames) != 1 || len(cert.IPAddresses) != 2 {
+			t.Errorf("GenerateSelfSignedCert(%s) subject %s, DNS names %v, IPs %v; want the hosts",
+				keyType, cert.Subject, cert.DNSNames, cert.IPAddresses)
+		}
+
+		roots := x509.NewCertPool()
+		roots.AddCert(cert)
+		for _, host := range []string{"example.com", "10.0.0.1"} {
+			if _, err := cert.Verify(x509.VerifyOptions{DNSName: host, Roots: roots}); err != nil {
+				t.Errorf("GenerateSelfSignedCert(%s) Verify(%s) err = %v; want nil", keyType, host, err)
+			}
+		}
+
+		block, _ = pem.Decode(keyPEM)
+		if block == nil || block.Type != "PRIVATE KEY" {
+			t.Fatalf("GenerateSelfSignedCert(%s) key is not a PEM encoded PKCS #8 key", keyType)
+		}
+		if _, err := x509.ParsePKCS8PrivateKey(block.Bytes); err != nil {
+			t.Errorf("ParsePKCS8PrivateKey() err = %v; want nil", err)
+		}
+	}
+
+	if _, _, err := GenerateSelfSignedCert(CertOptions{}); err == nil {
+		t.Errorf("GenerateSelfSignedCert() without hosts err = nil; want an error")
+	}
+	if _, _, err := GenerateSelfSignedCert(CertOptions{Hosts: []string{"a"}, KeyType: "dsa"}); err == nil {
+		t.Errorf("GenerateSelfSignedCert(dsa) err = nil; want an error")
+	}
+}
@@ -0,0 +1,305 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ManifestFormat is the on-disk layout of a checksum manifest
+type ManifestFormat string
+
+const (
+	FormatSHA256Sum ManifestFormat = "sha256sum" // "<hash>  <path>", as written by sha256sum, md5sum, b2sum etc.
+	FormatBSD       ManifestFormat = "bsd"       // "SHA256 (<path>) = <hash>", as written by sha256sum --tag
+	FormatJSON      ManifestFormat = "json"      // JSON document with sizes
+)
+
+// Manifest lists the expected hash of every file below a directory
+type Manifest struct {
+	Created    time.Time       `json:"created"`
+	Algorithms []string        `json:"algorithms"` // Algorithms hashed for every file, in the order they are written
+	Files      []ManifestEntry `json:"files"`
+}
+
+// ManifestEntry is the expected state of a single file, with a slash separated path relative to the root
+type ManifestEntry struct {
+	Path   string            `json:"path"`
+	Size   int64             `json:"size,omitempty"`
+	Hashes map[string]string `json:"hashes"` // Hex digests keyed by algorithm name
+}
+
+// VerifyStatus is the outcome of checking a single file against a manifest
+type VerifyStatus string
+
+const (
+	StatusOK      VerifyStatus = "OK"      // Hash matches
+	StatusFailed  VerifyStatus = "FAILED"  // Hash differs or the file could not be read
+	StatusMissing VerifyStatus = "MISSING" // Listed in the manifest but not found
+	StatusNew     VerifyStatus = "NEW"     // Found but not listed in the manifest
+)
+
+// VerifyResult is the status of a single file
+type VerifyResult struct {
+	Path   string
+	Status VerifyStatus
+	Err    error // Set when the file could not be read
+}
+
+// bsdLine matches a BSD tag style manifest line
+var bsdLine = regexp.MustCompile(`^([A-Za-z0-9-]+) \((.*)\) = ([0-9a-fA-F]+)$`)
+
+// ParseManifestFormat validates a manifest format name
+func ParseManifestFormat(s string) (ManifestFormat, error) {
+	switch f := ManifestFormat(s); f {
+	case FormatSHA256Sum, FormatBSD, FormatJSON:
+		return f, nil
+	default:
+		return "", fmt.Errorf("invalid manifest format %q (want sha256sum, bsd or json)", s)
+	}
+}
+
+// GenerateManifest hashes the files found below root with every algorithm and records them in a manifest.
+// Hashes are taken from the scan or the cache where possible, the cache may be nil.
+func GenerateManifest(root string, files []ScannedFile, workers int, algos []string, cache *HashCache) (*Manifest, []FileError) {
+	hasher := newFileHasher(files, cache)
+	paths := make([]string, len(files))
+	for i, f := range files {
+		paths[i] = f.Path
+	}
+	hashes, errs := HashFiles(paths, workers, func(path string) (map[string]string, error) {
+		return hasher.fileHashes(path, algos)
+	})
+
+	m := &Manifest{Created: time.Now().UTC(), Algorithms: algos}
+	for _, f := range files {
+		h, ok := hashes[f.Path]
+		if !ok {
+			continue
+		}
+		m.Files = append(m.Files, ManifestEntry{Path: manifestPath(root, f.Path), Size: f.Size, Hashes: h})
+	}
+	sort.Slice(m.Files, func(i, j int) bool { return m.Files[i].Path < m.Files[j].Path })
+	sortFileErrors(errs)
+	return m, errs
+}
+
+// manifestPath converts a scanned path to the slash separated path stored in a manifest
+func manifestPath(root, path string) string {
+	if strings.HasPrefix(path, root+ArchiveSeparator) {
+		return path[len(root)+len(ArchiveSeparator):]
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		rel = path
+	}
+	return filepath.ToSlash(rel)
+}
+
+// WriteManifest writes the manifest in the given format.
+// The sha256sum format has no room for the algorithm name, so it only holds a single algorithm.
+func WriteManifest(w io.Writer, m *Manifest, format ManifestFormat) error {
+	if format == FormatJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(m)
+	}
+	if format == FormatSHA256Sum && len(m.Algorithms) > 1 {
+		return fmt.Errorf("the %s format holds a single algorithm, use bsd or json for %s", format, strings.Join(m.Algorithms, ", "))
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, e := range m.Files {
+		name, escaped := escapeManifestPath(e.Path)
+		prefix := ""
+		if escaped {
+			prefix = "\\"
+		}
+		for _, algo := range m.Algorithms {
+			if format == FormatBSD {
+				fmt.Fprintf(bw, "%s%s (%s) = %s\n", prefix, hashTag(algo), name, e.Hashes[algo])
+			} else {
+				fmt.Fprintf(bw, "%s%s  %s\n", prefix, e.Hashes[algo], name)
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// escapeManifestPath escapes backslashes and newlines the way coreutils does
+func escapeManifestPath(path string) (string, bool) {
+	if !strings.ContainsAny(path, "\\\n\r") {
+		return path, false
+	}
+	r := strings.NewReplacer("\\", "\\\\", "\n", "\\n", "\r", "\\r")
+	return r.Replace(path), true
+}
+
+// unescapeManifestPath reverses escapeManifestPath
+func unescapeManifestPath(path string) string {
+	r := strings.NewReplacer("\\\\", "\\", "\\n", "\n", "\\r", "\r")
+	return r.Replace(path)
+}
+
+// ReadManifest parses a manifest, detecting whether it is JSON, BSD tag or sha256sum format.
+// Lines in sha256sum format carry no algorithm name, they are read as sumAlgo or, if that is
+// empty, as the algorithm guessed from the digest length.
+func ReadManifest(r io.Reader, sumAlgo string) (*Manifest, error) {
+	br := bufio.NewReader(r)
+	if first, err := br.Peek(1); err == nil && first[0] == '{' {
+		var m Manifest
+		if err := json.NewDecoder(br).Decode(&m); err != nil {
+			return nil, fmt.Errorf("error parsing JSON manifest: %v", err)
+		}
+		// Digests are compared as lowercase hex, like those of the other formats
+		for _, e := range m.Files {
+			for algo, hash := range e.Hashes {
+				e.Hashes[algo] = strings.ToLower(hash)
+			}
+		}
+		return &m, nil
+	}
+
+	m := &Manifest{}
+	index := make(map[string]int) // Position of each path in m.Files, BSD manifests list a file once per algorithm
+	algos := make(map[string]bool)
+	scanner := bufio.NewScanner(br)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		escaped := strings.HasPrefix(line, "\\")
+		if escaped {
+			line = line[1:]
+		}
+
+		var algo, path, hash string
+		if match := bsdLine.FindStringSubmatch(line); match != nil {
+			var ok bool
+			if algo, ok = algorithmForTag(match[1]); !ok {
+				return nil, fmt.Errorf("unsupported hash algorithm %q on manifest line %d", match[1], lineNo)
+			}
+			path, hash = match[2], match[3]
+		} else if i := strings.Index(line, " "); i > 0 && i+1 < len(line) && (line[i+1] == ' ' || line[i+1] == '*') {
+			// "<hash>  <path>" in text mode or "<hash> *<path>" in binary mode
+			hash, path = line[:i], line[i+2:]
+			algo = sumAlgo
+			if algo == "" {
+				algo = algorithmForLength(len(hash))
+			}
+		} else {
+			return nil, fmt.Errorf("invalid manifest line %d: %q", lineNo, line)
+		}
+		if escaped {
+			path = unescapeManifestPath(path)
+		}
+
+		if !algos[algo] {
+			algos[algo] = true
+			m.Algorithms = append(m.Algorithms, algo)
+		}
+		i, ok := index[path]
+		if !ok {
+			i = len(m.Files)
+			index[path] = i
+			m.Files = append(m.Files, ManifestEntry{Path: path, Hashes: make(map[string]string)})
+		}
+		m.Files[i].Hashes[algo] = strings.ToLower(hash)
+	}
+	return m, scanner.Err()
+}
+
+// LoadManifest reads a manifest from a file, see ReadManifest
+func LoadManifest(path, sumAlgo string) (*Manifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadManifest(f, sumAlgo)
+}
+
+// VerifyManifest re-hashes the files listed in the manifest below root and compares every
+// recorded hash in a single read per file. Files present below root but missing from the
+// manifest are reported as new. Files whose metadata is unchanged since they were cached are
+// not read again, pass a nil cache to detect corruption that leaves the metadata intact.
+// If root is an archive, files must hold its contents as returned by ScanArchive. Entries that
+// are absolute or lead out of root with ".." fail without being read.
+func VerifyManifest(root string, m *Manifest, files []ScannedFile, workers int, cache *HashCache) []VerifyResult {
+	hasher := newFileHasher(files, cache)
+	archive := isArchiveRoot(root)
+	if archive {
+		hasher.archives = append(hasher.archives, root)
+	}
+
+	listed := make(map[string]bool, len(m.Files))
+	paths := make([]string, len(m.Files)) // Empty for entries outside root
+	var hashed []string
+	for i, e := range m.Files {
+		listed[e.Path] = true
+		if !filepath.IsLocal(filepath.FromSlash(e.Path)) {
+			continue
+		}
+		if archive {
+			paths[i] = root + ArchiveSeparator + e.Path
+		} else {
+			paths[i] = filepath.Join(root, filepath.FromSlash(e.Path))
+		}
+		hashed = append(hashed, paths[i])
+	}
+
+	algos := make(map[string][]string, len(paths)) // Supported algorithms recorded for each file
+	for i, e := range m.Files {
+		for algo := range e.Hashes {
+			if _, ok := hashAlgorithms[algo]; ok && paths[i] != "" {
+				algos[paths[i]] = append(algos[paths[i]], algo)
+			}
+		}
+	}
+	hashes, errs := HashFiles(hashed, workers, func(path string) (map[string]string, error) {
+		return hasher.fileHashes(path, algos[path])
+	})
+	failures := make(map[string]error, len(errs))
+	for _, e := range errs {
+		failures[e.Path] = e.Err
+	}
+
+	var results []VerifyResult
+	for i, e := range m.Files {
+		path := paths[i]
+		result := VerifyResult{Path: e.Path, Status: StatusOK}
+		if path == "" {
+			result.Status, result.Err = StatusFailed, fmt.Errorf("path is outside the directory")
+		} else if err, failed := failures[path]; failed {
+			result.Status, result.Err = StatusFailed, err
+			if os.IsNotExist(err) {
+				result.Status, result.Err = StatusMissing, nil
+			}
+		} else if len(algos[path]) == 0 {
+			result.Status, result.Err = StatusFailed, fmt.Errorf("no supported hash in manifest")
+		} else {
+			for algo, hash := range hashes[path] {
+				if hash != e.Hashes[algo] {
+					result.Status = StatusFailed
+				}
+			}
+		}
+		results = append(results, result)
+	}
+
+	for _, f := range files {
+		if rel := manifestPath(root, f.Path); !listed[rel] {
+			results = append(results, VerifyResult{Path: rel, Status: StatusNew})
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Path < results[j].Path })
+	return results
+}
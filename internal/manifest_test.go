@@ -0,0 +1,156 @@
+package internal
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestManifestRoundTrip checks that every format reads back the entries it was written with
+func TestManifestRoundTrip(t *testing.T) {
+	m := &Manifest{Algorithms: []string{"sha256"}, Files: []ManifestEntry{
+		{Path: "a", Hashes: map[string]string{"sha256": "87428fc522803d31065e7bce3cf03fe475096631e5e07bbd7a0fde60c4cf25c7"}},
+		{Path: "sub/with space", Hashes: map[string]string{"sha256": "0263829989b6fd954f72baaf2fc64bc2e2f01d692d4de72986ea808f6e99813f"}},
+		{Path: "back\\slash\nnewline", Hashes: map[string]string{"sha256": "2d711642b726b04401627ca9fbac32f5c8530fb1903cc4db02258717921a4881"}},
+	}}
+
+	for _, format := range []ManifestFormat{FormatSHA256Sum, FormatBSD, FormatJSON} {
+		var buf bytes.Buffer
+		if err := WriteManifest(&buf, m, format); err != nil {
+			t.Fatalf("WriteManifest(%s) err = %v; want nil", format, err)
+		}
+		got, err := ReadManifest(&buf, "")
+		if err != nil {
+			t.Fatalf("ReadManifest(%s) err = %v; want nil", format, err)
+		}
+		if !reflect.DeepEqual(got.Files, m.Files) {
+			t.Errorf("ReadManifest(%s) = %v; want %v", format, got.Files, m.Files)
+		}
+	}
+}
+
+// TestManifestMultipleAlgorithms checks that several hashes per file survive the formats that can hold them
+func TestManifestMultipleAlgorithms(t *testing.T) {
+	m := &Manifest{Algorithms: []string{"sha256", "xxhash"}, Files: []ManifestEntry{
+		{Path: "abc", Hashes: map[string]string{
+			"sha256": "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+			"xxhash": "44bc2cf5ad770999",
+		}},
+	}}
+
+	if err := WriteManifest(&bytes.Buffer{}, m, FormatSHA256Sum); err == nil {
+		t.Errorf("WriteManifest(sha256sum) err = nil; want error for two algorithms")
+	}
+	for _, format := range []ManifestFormat{FormatBSD, FormatJSON} {
+		var buf bytes.Buffer
+		if err := WriteManifest(&buf, m, format); err != nil {
+			t.Fatalf("WriteManifest(%s) err = %v; want nil", format, err)
+		}
+		got, err := ReadManifest(&buf, "")
+		if err != nil {
+			t.Fatalf("ReadManifest(%s) err = %v; want nil", format, err)
+		}
+		if !reflect.DeepEqual(got.Algorithms, m.Algorithms) || !reflect.DeepEqual(got.Files, m.Files) {
+			t.Errorf("ReadManifest(%s) = %v %v; want %v %v", format, got.Algorithms, got.Files, m.Algorithms, m.Files)
+		}
+	}
+}
+
+// TestManifestUppercase checks that uppercase digests read as lowercase in every format
+func TestManifestUppercase(t *testing.T) {
+	const digest = "BA7816BF8F01CFEA414140DE5DAE2223B00361A396177A9CB410FF61F20015AD"
+	for format, manifest := range map[ManifestFormat]string{
+		FormatSHA256Sum: digest + "  abc\n",
+		FormatBSD:       "SHA256 (abc) = " + digest + "\n",
+		FormatJSON:      `{"algorithms":["sha256"],"files":[{"path":"abc","hashes":{"sha256":"` + digest + `"}}]}`,
+	} {
+		m, err := ReadManifest(strings.NewReader(manifest), "")
+		if err != nil {
+			t.Fatalf("ReadManifest(%s) err = %v; want nil", format, err)
+		}
+		if got := m.Files[0].Hashes["sha256"]; got != strings.ToLower(digest) {
+			t.Errorf("ReadManifest(%s) digest = %s; want %s", format, got, strings.ToLower(digest))
+		}
+	}
+}
+
+// TestVerifyManifest checks that changed, deleted and added files are reported
+func TestVerifyManifest(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+	scan := func() []ScannedFile {
+		var files []ScannedFile
+		filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err == nil && info.Mode().IsRegular() {
+				files = append(files, ScannedFile{Path: path, Size: info.Size()})
+			}
+			return err
+		})
+		return files
+	}
+
+	write("same", "unchanged")
+	write("changed", "before")
+	write("sub/deleted", "gone soon")
+
+	m, errs := GenerateManifest(dir, scan(), 2, []string{"sha256", "md5"}, nil)
+	if len(errs) != 0 {
+		t.Fatalf("GenerateManifest() errs = %v; want none", errs)
+	}
+
+	write("changed", "after")
+	write("added", "new file")
+	if err := os.Remove(filepath.Join(dir, "sub/deleted")); err != nil {
+		t.Fatalf("Failed to remove file: %v", err)
+	}
+
+	got := make(map[string]VerifyStatus)
+	for _, r := range VerifyManifest(dir, m, scan(), 2, nil) {
+		got[r.Path] = r.Status
+	}
+	want := map[string]VerifyStatus{
+		"same":        StatusOK,
+		"changed":     StatusFailed,
+		"sub/deleted": StatusMissing,
+		"added":       StatusNew,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("VerifyManifest() = %v; want %v", got, want)
+	}
+
+	// Entries leading out of the directory fail without reading the file
+	outside := filepath.Join(t.TempDir(), "outside")
+	if err := os.WriteFile(outside, []byte("unchanged"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	rel, err := filepath.Rel(dir, outside)
+	if err != nil {
+		t.Fatalf("filepath.Rel() err = %v; want nil", err)
+	}
+	var sameHashes map[string]string // The outside file has the same contents
+	for _, e := range m.Files {
+		if e.Path == "same" {
+			sameHashes = e.Hashes
+		}
+	}
+	escaping := &Manifest{Algorithms: m.Algorithms}
+	for _, path := range []string{filepath.ToSlash(rel), filepath.ToSlash(outside), "sub/../../same"} {
+		escaping.Files = append(escaping.Files, ManifestEntry{Path: path, Hashes: sameHashes})
+	}
+	for _, r := range VerifyManifest(dir, escaping, nil, 2, nil) {
+		if r.Status != StatusFailed || r.Err == nil {
+			t.Errorf("VerifyManifest(%s) = %s, %v; want FAILED with an error", r.Path, r.Status, r.Err)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !(linux || darwin || freebsd)
+
+package internal
+
+import (
+	"errors"
+	"os"
+)
+
+// mmapFile is not supported on this platform, files are read instead
+func mmapFile(f *os.File, size int64) ([]byte, func(), error) {
+	return nil, nil, errors.New("memory mapping is not supported on this platform")
+}
+
+// adviseSequential does nothing without memory mapping
+func adviseSequential(data []byte) {}
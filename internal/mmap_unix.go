@@ -0,0 +1,26 @@
+//go:build linux || darwin || freebsd
+
+package internal
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// mmapFile maps size bytes of a file read-only and returns the function unmapping it
+func mmapFile(f *os.File, size int64) ([]byte, func(), error) {
+	if int64(int(size)) != size {
+		return nil, nil, unix.EFBIG
+	}
+	data, err := unix.Mmap(int(f.Fd()), 0, int(size), unix.PROT_READ, unix.MAP_SHARED)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() { unix.Munmap(data) }, nil
+}
+
+// adviseSequential tells the kernel to read ahead aggressively and drop pages behind
+func adviseSequential(data []byte) {
+	unix.Madvise(data, unix.MADV_SEQUENTIAL)
+}
@@ -0,0 +1,140 @@
+package internal
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// MountArchive exposes the contents of a backup archive as a read-only FUSE filesystem
+// at mountpoint and serves it until SIGINT or SIGTERM is received.
+func MountArchive(archivePath, mountpoint, passphrase string) error {
+	idx, err := IndexArchive(archivePath, passphrase)
+	if err != nil {
+		return err
+	}
+	defer idx.Close()
+
+	root, _ := idx.Lookup("")
+	timeout := time.Minute // The archive never changes, let the kernel cache aggressively
+	server, err := fs.Mount(mountpoint, &archiveDirNode{idx: idx, entry: root}, &fs.Options{
+		EntryTimeout: &timeout,
+		AttrTimeout:  &timeout,
+		MountOptions: fuse.MountOptions{
+			FsName:  archivePath,
+			Name:    "admin-cli",
+			Options: []string{"ro"},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error mounting %s: %v", mountpoint, err)
+	}
+	fmt.Printf("Mounted %s at %s (press Ctrl+C to unmount)\n", archivePath, mountpoint)
+
+	// Unmount on shutdown signal, which makes Wait return.
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-quit
+		if err := server.Unmount(); err != nil {
+			fmt.Printf("Error unmounting %s: %v\n", mountpoint, err)
+		}
+	}()
+
+	server.Wait()
+	return nil
+}
+
+// archiveDirNode is a directory inside the mounted archive.
+type archiveDirNode struct {
+	fs.Inode
+	idx   *ArchiveIndex
+	entry *ArchiveEntry
+}
+
+// archiveFileNode is a regular file inside the mounted archive.
+type archiveFileNode struct {
+	fs.Inode
+	idx   *ArchiveIndex
+	entry *ArchiveEntry
+}
+
+var _ = (fs.NodeOnAdder)((*archiveDirNode)(nil))
+var _ = (fs.NodeGetattrer)((*archiveDirNode)(nil))
+var _ = (fs.NodeOpener)((*archiveFileNode)(nil))
+var _ = (fs.NodeReader)((*archiveFileNode)(nil))
+var _ = (fs.NodeGetattrer)((*archiveFileNode)(nil))
+
+// OnAdd builds the inode tree of the directory from the archive index.
+func (d *archiveDirNode) OnAdd(ctx context.Context) {
+	for _, e := range d.idx.List(d.entry.Name) {
+		var child *fs.Inode
+		switch e.Header.Typeflag {
+		case tar.TypeDir:
+			child = d.NewPersistentInode(ctx, &archiveDirNode{idx: d.idx, entry: e}, fs.StableAttr{Mode: fuse.S_IFDIR})
+		case tar.TypeReg:
+			child = d.NewPersistentInode(ctx, &archiveFileNode{idx: d.idx, entry: e}, fs.StableAttr{Mode: fuse.S_IFREG})
+		case tar.TypeSymlink:
+			link := &fs.MemSymlink{Data: []byte(e.Header.Linkname)}
+			fillAttr(&link.Attr, e.Header)
+			child = d.NewPersistentInode(ctx, link, fs.StableAttr{Mode: fuse.S_IFLNK})
+		default:
+			continue // Ignore unsupported types, as restore does
+		}
+		d.AddChild(path.Base(e.Name), child, true)
+	}
+}
+
+// Getattr reports the directory metadata stored in the archive.
+func (d *archiveDirNode) Getattr(ctx context.Context, f fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	fillAttr(&out.Attr, d.entry.Header)
+	return fs.OK
+}
+
+// Open allows read-only access to the file.
+func (n *archiveFileNode) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	if flags&(syscall.O_WRONLY|syscall.O_RDWR) != 0 {
+		return nil, 0, syscall.EROFS
+	}
+	return nil, fuse.FOPEN_KEEP_CACHE, fs.OK
+}
+
+// Read reads the file contents through the archive index.
+func (n *archiveFileNode) Read(ctx context.Context, f fs.FileHandle, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	if off >= n.entry.Header.Size {
+		return fuse.ReadResultData(nil), fs.OK
+	}
+	if remaining := n.entry.Header.Size - off; int64(len(dest)) > remaining {
+		dest = dest[:remaining]
+	}
+	read, err := n.idx.ReadAt(n.entry, dest, off)
+	if err != nil && read < len(dest) {
+		fmt.Printf("Error reading %s: %v\n", n.entry.Name, err)
+		return nil, syscall.EIO
+	}
+	return fuse.ReadResultData(dest[:read]), fs.OK
+}
+
+// Getattr reports the file metadata stored in the archive.
+func (n *archiveFileNode) Getattr(ctx context.Context, f fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	fillAttr(&out.Attr, n.entry.Header)
+	return fs.OK
+}
+
+// fillAttr copies permissions, ownership, size and times from a tar header.
+func fillAttr(attr *fuse.Attr, h *tar.Header) {
+	attr.Mode = uint32(h.Mode) & 07777
+	attr.Size = uint64(h.Size)
+	attr.Uid = uint32(h.Uid)
+	attr.Gid = uint32(h.Gid)
+	mtime := h.ModTime
+	attr.SetTimes(&mtime, &mtime, &mtime)
+}
@@ -0,0 +1,243 @@
+package internal
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// Defaults of the otpauth URI format, which authenticator apps assume when a parameter is missing
+const (
+	DefaultOTPDigits     = 6
+	DefaultOTPPeriod     = 30
+	DefaultOTPAlgorithm  = "SHA1"
+	DefaultOTPSecretSize = 20 // 160 bits, as recommended by RFC 4226
+)
+
+// OTP types
+const (
+	OTPTypeTOTP = "totp"
+	OTPTypeHOTP = "hotp"
+)
+
+// otpBase32 is base32 without padding, as authenticator apps expect secrets
+var otpBase32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// OTPKey is a one-time password secret with the parameters codes are computed with
+type OTPKey struct {
+	Type      string // totp or hotp
+	Secret    []byte
+	Issuer    string
+	Account   string
+	Algorithm string // SHA1, SHA256 or SHA512
+	Digits    int
+	Period    int    // Seconds each TOTP code is valid for
+	Counter   uint64 // Next HOTP counter
+}
+
+// NewOTPKey returns a key with a random secret of size bytes and the default parameters
+func NewOTPKey(otpType string, size int) (OTPKey, error) {
+	if otpType != OTPTypeTOTP && otpType != OTPTypeHOTP {
+		return OTPKey{}, fmt.Errorf("invalid OTP type %q (want totp or hotp)", otpType)
+	}
+	if size < 16 {
+		return OTPKey{}, fmt.Errorf("secret size must be at least 16 bytes")
+	}
+	secret := make([]byte, size)
+	if _, err := io.ReadFull(randReader, secret); err != nil {
+		return OTPKey{}, fmt.Errorf("failed to read random numbers: %v", err)
+	}
+	return OTPKey{
+		Type:      otpType,
+		Secret:    secret,
+		Algorithm: DefaultOTPAlgorithm,
+		Digits:    DefaultOTPDigits,
+		Period:    DefaultOTPPeriod,
+	}, nil
+}
+
+// EncodedSecret returns the secret in base32 without padding
+func (k OTPKey) EncodedSecret() string {
+	return otpBase32.EncodeToString(k.Secret)
+}
+
+// DecodeOTPSecret decodes a base32 secret, ignoring case, spaces, dashes and padding
+func DecodeOTPSecret(s string) ([]byte, error) {
+	s = strings.ToUpper(strings.NewReplacer(" ", "", "-", "", "=", "").Replace(strings.TrimSpace(s)))
+	secret, err := otpBase32.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("secret is not valid base32: %v", err)
+	}
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("secret is empty")
+	}
+	return secret, nil
+}
+
+// URI returns the otpauth:// URI authenticator apps import the key from
+func (k OTPKey) URI() string {
+	label := k.Account
+	if k.Issuer != "" {
+		label = k.Issuer + ":" + k.Account
+	}
+	q := url.Values{}
+	q.Set("secret", k.EncodedSecret())
+	if k.Issuer != "" {
+		q.Set("issuer", k.Issuer)
+	}
+	q.Set("algorithm", k.Algorithm)
+	q.Set("digits", strconv.Itoa(k.Digits))
+	if k.Type == OTPTypeHOTP {
+		q.Set("counter", strconv.FormatUint(k.Counter, 10))
+	} else {
+		q.Set("period", strconv.Itoa(k.Period))
+	}
+	// Some apps show a + for spaces literally, literal plus signs are escaped by Encode
+	query := strings.ReplaceAll(q.Encode(), "+", "%20")
+	u := url.URL{Scheme: "otpauth", Host: k.Type, Path: "/" + label, RawQuery: query}
+	return u.String()
+}
+
+// ParseOTPURI reads a key from an otpauth:// URI, using the defaults for missing parameters
+func ParseOTPURI(uri string) (OTPKey, error) {
+	u, err := url.Parse(strings.TrimSpace(uri))
+	if err != nil {
+		return OTPKey{}, err
+	}
+	if u.Scheme != "otpauth" {
+		return OTPKey{}, fmt.Errorf("not an otpauth:// URI")
+	}
+	k := OTPKey{
+		Type:      strings.ToLower(u.Host),
+		Algorithm: DefaultOTPAlgorithm,
+		Digits:    DefaultOTPDigits,
+		Period:    DefaultOTPPeriod,
+	}
+	if k.Type != OTPTypeTOTP && k.Type != OTPTypeHOTP {
+		return OTPKey{}, fmt.Errorf("invalid OTP type %q (want totp or hotp)", u.Host)
+	}
+
+	label := strings.TrimPrefix(u.Path, "/")
+	if issuer, account, ok := strings.Cut(label, ":"); ok {
+		k.Issuer, k.Account = issuer, strings.TrimSpace(account)
+	} else {
+		k.Account = label
+	}
+	q := u.Query()
+	if k.Secret, err = DecodeOTPSecret(q.Get("secret")); err != nil {
+		return OTPKey{}, err
+	}
+	if issuer := q.Get("issuer"); issuer != "" {
+		k.Issuer = issuer
+	}
+	if algorithm := q.Get("algorithm"); algorithm != "" {
+		k.Algorithm = strings.ToUpper(algorithm)
+	}
+	if digits := q.Get("digits"); digits != "" {
+		if k.Digits, err = strconv.Atoi(digits); err != nil {
+			return OTPKey{}, fmt.Errorf("invalid digits %q", digits)
+		}
+	}
+	if period := q.Get("period"); period != "" {
+		if k.Period, err = strconv.Atoi(period); err != nil {
+			return OTPKey{}, fmt.Errorf("invalid period %q", period)
+		}
+	}
+	if counter := q.Get("counter"); counter != "" {
+		if k.Counter, err = strconv.ParseUint(counter, 10, 64); err != nil {
+			return OTPKey{}, fmt.Errorf("invalid counter %q", counter)
+		}
+	}
+	return k, k.validate()
+}
+
+// validate checks the parameters codes are computed with
+func (k OTPKey) validate() error {
+	if _, err := otpHash(k.Algorithm); err != nil {
+		return err
+	}
+	if k.Digits < 6 || k.Digits > 10 {
+		return fmt.Errorf("digits must be between 6 and 10")
+	}
+	if k.Type == OTPTypeTOTP && k.Period < 1 {
+		return fmt.Errorf("period must be at least 1 second")
+	}
+	return nil
+}
+
+// otpHash returns the hash of an OTP algorithm name
+func otpHash(algorithm string) (func() hash.Hash, error) {
+	switch strings.ToUpper(algorithm) {
+	case "SHA1":
+		return sha1.New, nil
+	case "SHA256":
+		return sha256.New, nil
+	case "SHA512":
+		return sha512.New, nil
+	default:
+		return nil, fmt.Errorf("invalid OTP algorithm %q (want SHA1, SHA256 or SHA512)", algorithm)
+	}
+}
+
+// HOTP computes the RFC 4226 code for a counter: the HMAC of the counter is truncated
+// dynamically to 31 bits and reduced to the number of digits
+func HOTP(secret []byte, counter uint64, digits int, algorithm string) (string, error) {
+	newHash, err := otpHash(algorithm)
+	if err != nil {
+		return "", err
+	}
+	if digits < 6 || digits > 10 {
+		return "", fmt.Errorf("digits must be between 6 and 10")
+	}
+	mac := hmac.New(newHash, secret)
+	binary.Write(mac, binary.BigEndian, counter)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	code := uint64(binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff)
+	mod := uint64(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, code%mod), nil
+}
+
+// TOTP computes the RFC 6238 code for a time, the HOTP code of the number of periods
+// since the Unix epoch
+func TOTP(secret []byte, t time.Time, period, digits int, algorithm string) (string, error) {
+	if period < 1 {
+		return "", fmt.Errorf("period must be at least 1 second")
+	}
+	return HOTP(secret, uint64(t.Unix())/uint64(period), digits, algorithm)
+}
+
+// Code returns the key's code at time t, or for its counter when it is an HOTP key
+func (k OTPKey) Code(t time.Time) (string, error) {
+	if k.Type == OTPTypeHOTP {
+		return HOTP(k.Secret, k.Counter, k.Digits, k.Algorithm)
+	}
+	return TOTP(k.Secret, t, k.Period, k.Digits, k.Algorithm)
+}
+
+// TerminalQRCode renders text as a QR code of half block characters. By default light
+// modules are drawn, for terminals with light text on a dark background; invert draws the
+// dark modules instead.
+func TerminalQRCode(text string, invert bool) (string, error) {
+	qr, err := qrcode.New(text, qrcode.Medium)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode QR code: %v", err)
+	}
+	return qr.ToSmallString(invert), nil
+}
@@ -0,0 +1,97 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestHOTP checks the test values of RFC 4226 appendix D
+func TestHOTP(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	want := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	for counter, code := range want {
+		got, err := HOTP(secret, uint64(counter), 6, "SHA1")
+		if err != nil || got != code {
+			t.Errorf("HOTP(%d) = %s, %v; want %s", counter, got, err, code)
+		}
+	}
+}
+
+// TestTOTP checks the test values of RFC 6238 appendix B
+func TestTOTP(t *testing.T) {
+	secrets := map[string][]byte{
+		"SHA1":   []byte("12345678901234567890"),
+		"SHA256": []byte("12345678901234567890123456789012"),
+		"SHA512": []byte("1234567890123456789012345678901234567890123456789012345678901234"),
+	}
+	tests := []struct {
+		unix                 int64
+		sha1, sha256, sha512 string
+	}{
+		{59, "94287082", "46119246", "90693936"},
+		{1111111109, "07081804", "68084774", "25091201"},
+		{1111111111, "14050471", "67062674", "99943326"},
+		{1234567890, "89005924", "91819424", "93441116"},
+		{2000000000, "69279037", "90698825", "38618901"},
+		{20000000000, "65353130", "77737706", "47863826"},
+	}
+	for _, tt := range tests {
+		for algorithm, want := range map[string]string{"SHA1": tt.sha1, "SHA256": tt.sha256, "SHA512": tt.sha512} {
+			got, err := TOTP(secrets[algorithm], time.Unix(tt.unix, 0), 30, 8, algorithm)
+			if err != nil || got != want {
+				t.Errorf("TOTP(%d, %s) = %s, %v; want %s", tt.unix, algorithm, got, err, want)
+			}
+		}
+	}
+
+	if _, err := TOTP(secrets["SHA1"], time.Now(), 30, 6, "MD5"); err == nil {
+		t.Errorf("TOTP() with MD5 err = nil; want error")
+	}
+}
+
+// TestOTPURI checks that keys survive a round trip through an otpauth URI
+func TestOTPURI(t *testing.T) {
+	key, err := NewOTPKey(OTPTypeTOTP, DefaultOTPSecretSize)
+	if err != nil {
+		t.Fatalf("NewOTPKey() err = %v; want nil", err)
+	}
+	key.Issuer, key.Account, key.Digits = "Example Corp", "alice@example.com", 8
+	uri := key.URI()
+	if !strings.HasPrefix(uri, "otpauth://totp/Example%20Corp:alice@example.com?") || !strings.Contains(uri, "issuer=Example%20Corp") ||
+		len(key.EncodedSecret()) != 32 {
+		t.Errorf("URI() = %s; want an otpauth://totp URI with a 32 character secret", uri)
+	}
+
+	parsed, err := ParseOTPURI(uri)
+	if err != nil {
+		t.Fatalf("ParseOTPURI() err = %v; want nil", err)
+	}
+	if parsed.Issuer != key.Issuer || parsed.Account != key.Account || parsed.Digits != 8 ||
+		parsed.Period != 30 || string(parsed.Secret) != string(key.Secret) {
+		t.Errorf("ParseOTPURI() = %+v; want %+v", parsed, key)
+	}
+
+	// The example of the Key Uri Format, with a secret in lower case and parameters left out
+	parsed, err = ParseOTPURI("otpauth://hotp/ACME%20Co:john.doe@email.com?secret=hxdmvjecjjwsrb3hwizr4ifugftmxboz&counter=7")
+	if err != nil {
+		t.Fatalf("ParseOTPURI() err = %v; want nil", err)
+	}
+	if parsed.Type != OTPTypeHOTP || parsed.Issuer != "ACME Co" || parsed.Counter != 7 || parsed.Algorithm != "SHA1" || parsed.Digits != 6 {
+		t.Errorf("ParseOTPURI() = %+v; want the HOTP key of ACME Co with counter 7", parsed)
+	}
+	for _, bad := range []string{
+		"https://example.com/?secret=JBSWY3DPEHPK3PXP",
+		"otpauth://totp/x?secret=not*base32",
+		"otpauth://totp/x?secret=JBSWY3DPEHPK3PXP&digits=4",
+		"otpauth://motp/x?secret=JBSWY3DPEHPK3PXP",
+	} {
+		if _, err := ParseOTPURI(bad); err == nil {
+			t.Errorf("ParseOTPURI(%s) err = nil; want error", bad)
+		}
+	}
+
+	if qr, err := TerminalQRCode(uri, false); err != nil || strings.Count(qr, "\n") < 10 {
+		t.Errorf("TerminalQRCode() = %q, %v; want a QR code", qr, err)
+	}
+}
@@ -0,0 +1,291 @@
+package internal
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// ambiguousChars are characters easily mistaken for each other when read or typed
+const ambiguousChars = "0O1lI"
+
+// maxPolicyAttempts bounds rejection sampling for policies whose banned substrings or
+// repeat limits reject nearly every candidate
+const maxPolicyAttempts = 1000000
+
+// Character classes of a password policy
+const (
+	classLower = iota
+	classUpper
+	classDigit
+	classSymbol
+	numClasses
+)
+
+var classNames = [numClasses]string{"lower", "upper", "digit", "symbol"}
+
+// PasswordPolicy describes the passwords a system accepts. It is read from a JSON file
+// with the field names below, e.g. {"length": 16, "require": ["upper", "digit"], "no_ambiguous": true}.
+type PasswordPolicy struct {
+	Length      int      `json:"length,omitempty"`
+	Require     []string `json:"require,omitempty"`      // Classes needing at least one character: lower, upper, digit, symbol
+	MinLower    int      `json:"min_lower,omitempty"`    // Minimum lower case letters
+	MinUpper    int      `json:"min_upper,omitempty"`    // Minimum upper case letters
+	MinDigits   int      `json:"min_digits,omitempty"`   // Minimum digits
+	MinSymbols  int      `json:"min_symbols,omitempty"`  // Minimum symbols
+	Charset     string   `json:"charset,omitempty"`      // Characters to draw from instead of the default set
+	Exclude     string   `json:"exclude,omitempty"`      // Characters never used
+	NoAmbiguous bool     `json:"no_ambiguous,omitempty"` // Leave out the look-alikes 0O1lI
+	MaxRepeat   int      `json:"max_repeat,omitempty"`   // Longest run of one character, 0 for no limit
+	Banned      []string `json:"banned,omitempty"`       // Substrings that may not appear, ignoring case
+}
+
+// LoadPasswordPolicy reads a password policy from a JSON file
+func LoadPasswordPolicy(path string) (PasswordPolicy, error) {
+	var p PasswordPolicy
+	file, err := os.Open(path)
+	if err != nil {
+		return p, err
+	}
+	defer file.Close()
+
+	dec := json.NewDecoder(file)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&p); err != nil {
+		return p, fmt.Errorf("failed to parse password policy %s: %v", path, err)
+	}
+	return p, nil
+}
+
+// classOf returns the character class of r. Everything but letters and digits is a symbol.
+func classOf(r rune) int {
+	switch {
+	case unicode.IsLower(r):
+		return classLower
+	case unicode.IsUpper(r):
+		return classUpper
+	case unicode.IsDigit(r):
+		return classDigit
+	default:
+		return classSymbol
+	}
+}
+
+// minimums returns the minimum count of each class
+func (p PasswordPolicy) minimums() ([numClasses]int, error) {
+	mins := [numClasses]int{p.MinLower, p.MinUpper, p.MinDigits, p.MinSymbols}
+	for _, name := range p.Require {
+		found := false
+		for c, className := range classNames {
+			if strings.EqualFold(name, className) || strings.EqualFold(name, className+"s") {
+				mins[c] = max(mins[c], 1)
+				found = true
+			}
+		}
+		if !found {
+			return mins, fmt.Errorf("unknown character class %q (want lower, upper, digit or symbol)", name)
+		}
+	}
+	for c, n := range mins {
+		if n < 0 {
+			return mins, fmt.Errorf("minimum %s count cannot be negative", classNames[c])
+		}
+	}
+	return mins, nil
+}
+
+// Alphabet returns the characters the policy draws from, without duplicates
+func (p PasswordPolicy) Alphabet() []rune {
+	set := p.Charset
+	if set == "" {
+		set = charset
+	}
+	excluded := p.Exclude
+	if p.NoAmbiguous {
+		excluded += ambiguousChars
+	}
+
+	seen := make(map[rune]bool)
+	var alphabet []rune
+	for _, r := range set {
+		if !seen[r] && !strings.ContainsRune(excluded, r) && !unicode.IsSpace(r) {
+			seen[r] = true
+			alphabet = append(alphabet, r)
+		}
+	}
+	return alphabet
+}
+
+// Check returns an error describing the first rule the password breaks, if any
+func (p PasswordPolicy) Check(password string) error {
+	mins, err := p.minimums()
+	if err != nil {
+		return err
+	}
+	var counts [numClasses]int
+	run, prev := 0, rune(-1)
+	for _, r := range password {
+		counts[classOf(r)]++
+		if r == prev {
+			run++
+		} else {
+			run, prev = 1, r
+		}
+		if p.MaxRepeat > 0 && run > p.MaxRepeat {
+			return fmt.Errorf("%q is repeated more than %d times in a row", r, p.MaxRepeat)
+		}
+	}
+	for c, n := range counts {
+		if n < mins[c] {
+			return fmt.Errorf("has %d %s characters, want at least %d", n, classNames[c], mins[c])
+		}
+	}
+	lower := strings.ToLower(password)
+	for _, banned := range p.Banned {
+		if banned != "" && strings.Contains(lower, strings.ToLower(banned)) {
+			return fmt.Errorf("contains the banned substring %q", banned)
+		}
+	}
+	return nil
+}
+
+// GeneratePolicyPassword returns a password following the policy and its entropy in bits.
+// Every password meeting the class minimums is equally likely: each character is drawn with
+// the weight of the compliant passwords that can still follow it, which placing required
+// characters first and filling the rest would not achieve. Candidates breaking the repeat
+// limit or containing banned substrings are rejected and drawn again; the entropy ignores
+// the few passwords removed that way.
+func GeneratePolicyPassword(p PasswordPolicy) (string, float64, error) {
+	if p.Length < 1 {
+		return "", 0, fmt.Errorf("length must be at least 1")
+	}
+	if p.MaxRepeat < 0 {
+		return "", 0, fmt.Errorf("max repeat cannot be negative")
+	}
+	mins, err := p.minimums()
+	if err != nil {
+		return "", 0, err
+	}
+	alphabet := p.Alphabet()
+	if len(alphabet) == 0 {
+		return "", 0, fmt.Errorf("policy excludes every character")
+	}
+
+	var classes [numClasses][]rune
+	for _, r := range alphabet {
+		c := classOf(r)
+		classes[c] = append(classes[c], r)
+	}
+	required := 0
+	for c, n := range mins {
+		if n > 0 && len(classes[c]) == 0 {
+			return "", 0, fmt.Errorf("policy requires %s characters but allows none", classNames[c])
+		}
+		required += n
+	}
+	if required > p.Length {
+		return "", 0, fmt.Errorf("policy requires %d characters but the length is %d", required, p.Length)
+	}
+
+	counter := newClassCounter(p.Length, classes, mins)
+	entropy := log2Int(counter.ways[0][0])
+	for attempt := 0; attempt < maxPolicyAttempts; attempt++ {
+		password, err := counter.sample()
+		if err != nil {
+			return "", 0, err
+		}
+		if p.Check(password) == nil {
+			return password, entropy, nil
+		}
+	}
+	return "", 0, fmt.Errorf("no password met the policy after %d attempts, relax the repeat limit or banned substrings", maxPolicyAttempts)
+}
+
+// classCounter counts the passwords meeting per class minimums. A state holds how many
+// characters of each class were used so far, counted up to the class minimum as more makes
+// no difference; ways[i][s] is the number of ways to fill positions i and on from state s
+// so that every minimum is reached.
+type classCounter struct {
+	classes [numClasses][]rune
+	mins    [numClasses]int
+	strides [numClasses]int
+	ways    [][]*big.Int
+}
+
+func newClassCounter(length int, classes [numClasses][]rune, mins [numClasses]int) *classCounter {
+	cc := &classCounter{classes: classes, mins: mins}
+	states := 1
+	for c, m := range mins {
+		cc.strides[c] = states
+		states *= m + 1
+	}
+
+	cc.ways = make([][]*big.Int, length+1)
+	for i := length; i >= 0; i-- {
+		cc.ways[i] = make([]*big.Int, states)
+		for s := range cc.ways[i] {
+			n := new(big.Int)
+			if i == length {
+				if s == states-1 { // Every minimum reached
+					n.SetInt64(1)
+				}
+			} else {
+				for c := range classes {
+					size := big.NewInt(int64(len(classes[c])))
+					n.Add(n, size.Mul(size, cc.ways[i+1][cc.next(s, c)]))
+				}
+			}
+			cc.ways[i][s] = n
+		}
+	}
+	return cc
+}
+
+// next returns the state after adding a character of class c to state s
+func (cc *classCounter) next(s, c int) int {
+	if (s/cc.strides[c])%(cc.mins[c]+1) < cc.mins[c] {
+		return s + cc.strides[c]
+	}
+	return s
+}
+
+// sample draws a password uniformly from those meeting the minimums. One random number
+// below the count of completions picks both the class and the character of each position.
+func (cc *classCounter) sample() (string, error) {
+	length := len(cc.ways) - 1
+	password := make([]rune, length)
+	s := 0
+	for i := range password {
+		r, err := rand.Int(randReader, cc.ways[i][s])
+		if err != nil {
+			return "", fmt.Errorf("failed to read random numbers: %v", err)
+		}
+		for c, class := range cc.classes {
+			t := cc.next(s, c)
+			weight := new(big.Int).Mul(big.NewInt(int64(len(class))), cc.ways[i+1][t])
+			if r.Cmp(weight) < 0 {
+				password[i] = class[new(big.Int).Div(r, cc.ways[i+1][t]).Int64()]
+				s = t
+				break
+			}
+			r.Sub(r, weight)
+		}
+	}
+	return string(password), nil
+}
+
+// log2Int returns the base 2 logarithm of a positive integer of any size
+func log2Int(n *big.Int) float64 {
+	if n.Sign() <= 0 {
+		return math.Inf(-1)
+	}
+	mant := new(big.Float).SetInt(n)
+	exp := mant.MantExp(mant)
+	m, _ := mant.Float64()
+	return float64(exp) + math.Log2(m)
+}
@@ -0,0 +1,131 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestPasswordPolicyCheck checks each rule of a policy
+func TestPasswordPolicyCheck(t *testing.T) {
+	policy := PasswordPolicy{Require: []string{"upper", "digits"}, MinSymbols: 2, MaxRepeat: 2, Banned: []string{"Admin"}}
+	tests := []struct {
+		password string
+		ok       bool
+	}{
+		{"Passw0rd!!", true},
+		{"passw0rd!!", false},  // No upper case letter
+		{"Password!!", false},  // No digit
+		{"Passw0rd!", false},   // One symbol
+		{"Passsw0rd!!", false}, // Three s in a row
+		{"xADMIN0r!!", false},  // Banned substring
+	}
+	for _, tt := range tests {
+		if err := policy.Check(tt.password); (err == nil) != tt.ok {
+			t.Errorf("Check(%q) err = %v; want ok = %v", tt.password, err, tt.ok)
+		}
+	}
+
+	if got := string(PasswordPolicy{Charset: "aa0O1lIbc", Exclude: "c", NoAmbiguous: true}.Alphabet()); got != "ab" {
+		t.Errorf("Alphabet() = %q; want %q", got, "ab")
+	}
+}
+
+// TestClassCounter compares the counted compliant passwords with a brute force count
+func TestClassCounter(t *testing.T) {
+	classes := [numClasses][]rune{[]rune("ab"), []rune("C"), []rune("12"), nil}
+	mins := [numClasses]int{1, 1, 2, 0}
+	policy := PasswordPolicy{MinLower: 1, MinUpper: 1, MinDigits: 2}
+	alphabet := []rune("abC12")
+
+	const length = 5
+	want := 0
+	password := make([]rune, length)
+	var enumerate func(i int)
+	enumerate = func(i int) {
+		if i == length {
+			if policy.Check(string(password)) == nil {
+				want++
+			}
+			return
+		}
+		for _, r := range alphabet {
+			password[i] = r
+			enumerate(i + 1)
+		}
+	}
+	enumerate(0)
+
+	counter := newClassCounter(length, classes, mins)
+	if got := counter.ways[0][0].Int64(); got != int64(want) {
+		t.Errorf("ways = %d; want %d", got, want)
+	}
+}
+
+// TestGeneratePolicyPassword checks that compliant passwords are generated with equal odds
+func TestGeneratePolicyPassword(t *testing.T) {
+	// a1, b1, 1a, 1b and 11 are the passwords meeting the policy
+	policy := PasswordPolicy{Length: 2, Charset: "ab1", Require: []string{"digit"}}
+	counts := make(map[string]int)
+	const samples = 5000
+	for i := 0; i < samples; i++ {
+		pass, entropy, err := GeneratePolicyPassword(policy)
+		if err != nil {
+			t.Fatalf("GeneratePolicyPassword() err = %v; want nil", err)
+		}
+		if i == 0 && (entropy < 2.32 || entropy > 2.33) {
+			t.Errorf("GeneratePolicyPassword() entropy = %.2f; want log2(5)", entropy)
+		}
+		counts[pass]++
+	}
+	if len(counts) != 5 {
+		t.Fatalf("GeneratePolicyPassword() made %v; want 5 different passwords", counts)
+	}
+	for pass, n := range counts {
+		// The standard deviation is about 28, so this only fails for a biased generator
+		if n < samples/5-150 || n > samples/5+150 {
+			t.Errorf("GeneratePolicyPassword() made %q %d times; want about %d", pass, n, samples/5)
+		}
+	}
+
+	// Policies rejecting nearly every uniform candidate still work
+	pass, _, err := GeneratePolicyPassword(PasswordPolicy{Length: 12, MinDigits: 12, NoAmbiguous: true})
+	if err != nil || strings.Trim(pass, "23456789") != "" {
+		t.Errorf("GeneratePolicyPassword() = %q, %v; want 12 unambiguous digits", pass, err)
+	}
+
+	for _, bad := range []PasswordPolicy{
+		{Length: 4, MinDigits: 3, MinUpper: 2},
+		{Length: 8, Charset: "abc", Require: []string{"symbol"}},
+		{Length: 8, Require: []string{"emoji"}},
+		{Length: 8, Charset: "01", NoAmbiguous: true},
+	} {
+		if _, _, err := GeneratePolicyPassword(bad); err == nil {
+			t.Errorf("GeneratePolicyPassword(%+v) err = nil; want error", bad)
+		}
+	}
+}
+
+// TestLoadPasswordPolicy checks that policy files are read and typos are reported
+func TestLoadPasswordPolicy(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "policy.json")
+	if err := os.WriteFile(path, []byte(`{"length": 16, "require": ["upper"], "banned": ["acme"]}`), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	policy, err := LoadPasswordPolicy(path)
+	if err != nil {
+		t.Fatalf("LoadPasswordPolicy() err = %v; want nil", err)
+	}
+	if policy.Length != 16 || len(policy.Require) != 1 || policy.Banned[0] != "acme" {
+		t.Errorf("LoadPasswordPolicy() = %+v; want the file's policy", policy)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"lenght": 16}`), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if _, err := LoadPasswordPolicy(path); err == nil {
+		t.Errorf("LoadPasswordPolicy() with an unknown field err = nil; want error")
+	}
+}
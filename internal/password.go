@@ -2,16 +2,190 @@ package internal
 
 import (
 	"crypto/rand"
+	_ "embed"
+	"fmt"
+	"io"
+	"math"
 	"math/big"
+	"strings"
+	"sync"
+	"unicode"
 )
 
 const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789!@#$%^&*()"
 
+// randReader is the entropy source of every generated password, replaced by tests
+var randReader io.Reader = rand.Reader
+
+// Letters used by pronounceable passwords. q, w, x and y are left out as they read ambiguously.
+const (
+	consonants = "bcdfghjklmnprstvz"
+	vowels     = "aeiou"
+)
+
+// PasswordMode selects how genpass builds a password
+type PasswordMode string
+
+const (
+	ModeChars         PasswordMode = "chars"         // Random characters from charset
+	ModeWords         PasswordMode = "words"         // Diceware passphrase from the EFF large wordlist
+	ModePronounceable PasswordMode = "pronounceable" // Alternating consonants and vowels
+)
+
+// ParsePasswordMode validates a password mode name
+func ParsePasswordMode(s string) (PasswordMode, error) {
+	switch m := PasswordMode(s); m {
+	case ModeChars, ModeWords, ModePronounceable:
+		return m, nil
+	default:
+		return "", fmt.Errorf("invalid mode %q (want chars, words or pronounceable)", s)
+	}
+}
+
+// effWordlist is https://www.eff.org/files/2016/07/18/eff_large_wordlist.txt,
+// one "dice rolls<TAB>word" line for each of the 6^5 rolls of five dice
+//
+//go:embed wordlists/eff_large_wordlist.txt
+var effWordlist string
+
+// dicewareWords returns the words of the EFF wordlist in roll order
+var dicewareWords = sync.OnceValue(func() []string {
+	lines := strings.Split(strings.TrimSpace(effWordlist), "\n")
+	words := make([]string, len(lines))
+	for i, line := range lines {
+		_, words[i], _ = strings.Cut(line, "\t")
+	}
+	return words
+})
+
+// PassphraseOptions controls diceware passphrases
+type PassphraseOptions struct {
+	Words      int    // Number of words
+	Separator  string // Placed between words
+	Capitalize bool   // Upper case the first letter of every word
+	Digits     int    // Random digits appended to randomly chosen words
+}
+
+// GeneratePassword returns a password of length characters drawn uniformly from charset
 func GeneratePassword(length int) (string, error) {
+	if length < 1 {
+		return "", fmt.Errorf("length must be at least 1")
+	}
 	password := make([]byte, length)
-	for char := range password {
-		randIndex, _ := rand.Int(rand.Reader, big.NewInt(int64(len(charset))))
-		password[char] = charset[randIndex.Int64()]
+	for i := range password {
+		n, err := randomIndex(len(charset))
+		if err != nil {
+			return "", err
+		}
+		password[i] = charset[n]
 	}
 	return string(password), nil
 }
+
+// PasswordEntropy returns the entropy in bits of a GeneratePassword password
+func PasswordEntropy(length int) float64 {
+	return float64(length) * math.Log2(float64(len(charset)))
+}
+
+// GeneratePassphrase picks words uniformly from the EFF large wordlist and returns the
+// passphrase with its entropy in bits. Capitalization adds no entropy as it is always applied.
+// The entropy of the digits is a lower bound, see digitEntropy.
+func GeneratePassphrase(opts PassphraseOptions) (string, float64, error) {
+	if opts.Words < 1 {
+		return "", 0, fmt.Errorf("word count must be at least 1")
+	}
+	if opts.Digits < 0 {
+		return "", 0, fmt.Errorf("digit count cannot be negative")
+	}
+
+	list := dicewareWords()
+	words := make([]string, opts.Words)
+	for i := range words {
+		n, err := randomIndex(len(list))
+		if err != nil {
+			return "", 0, err
+		}
+		words[i] = list[n]
+		if opts.Capitalize {
+			r := []rune(words[i])
+			r[0] = unicode.ToUpper(r[0])
+			words[i] = string(r)
+		}
+	}
+	entropy := float64(opts.Words) * math.Log2(float64(len(list)))
+
+	// Each digit is one of ten, appended to one of the words
+	entropy += digitEntropy(opts.Digits, len(words))
+	for i := 0; i < opts.Digits; i++ {
+		word, err := randomIndex(len(words))
+		if err != nil {
+			return "", 0, err
+		}
+		digit, err := randomIndex(10)
+		if err != nil {
+			return "", 0, err
+		}
+		words[word] += string(rune('0' + digit))
+	}
+	return strings.Join(words, opts.Separator), entropy, nil
+}
+
+// digitEntropy is the min-entropy in bits of appending digits random digits to random words.
+// Draws in a different order can give the same passphrase, so the placements are not equally
+// likely; the most likely one spreads the digits evenly over the words. Its probability is
+// digits!/(k1!...kn! words^digits) for the k digits each word gets, which bounds the entropy
+// from below.
+func digitEntropy(digits, words int) float64 {
+	log2Factorial := func(n int) float64 {
+		lg, _ := math.Lgamma(float64(n + 1))
+		return lg / math.Ln2
+	}
+	entropy := float64(digits)*math.Log2(float64(10*words)) - log2Factorial(digits)
+	for i := 0; i < words; i++ {
+		k := digits / words
+		if i < digits%words {
+			k++
+		}
+		entropy += log2Factorial(k)
+	}
+	return entropy
+}
+
+// GeneratePronounceable returns a password of alternating consonants and vowels, starting
+// with either, and its entropy in bits. Passwords of odd length starting with a vowel hold
+// fewer consonants, so the entropy is that of this weaker branch, the same for every password.
+func GeneratePronounceable(length int) (string, float64, error) {
+	if length < 1 {
+		return "", 0, fmt.Errorf("length must be at least 1")
+	}
+
+	start, err := randomIndex(2)
+	if err != nil {
+		return "", 0, err
+	}
+	password := make([]byte, length)
+	// The choice of starting with a consonant or a vowel, then at least half the letters are vowels
+	entropy := 1 + float64(length/2)*math.Log2(float64(len(consonants))) + float64((length+1)/2)*math.Log2(float64(len(vowels)))
+	for i := range password {
+		letters := consonants
+		if (i+start)%2 == 1 {
+			letters = vowels
+		}
+		n, err := randomIndex(len(letters))
+		if err != nil {
+			return "", 0, err
+		}
+		password[i] = letters[n]
+	}
+	return string(password), entropy, nil
+}
+
+// randomIndex returns a uniform random number in [0, n). rand.Int draws again when the random
+// bits exceed the largest multiple of n, so no number is more likely than the others.
+func randomIndex(n int) (int, error) {
+	i, err := rand.Int(randReader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read random numbers: %v", err)
+	}
+	return int(i.Int64()), nil
+}
@@ -1,7 +1,13 @@
 package internal
 
 import (
+	"errors"
+	"io"
+	"math"
+	mathrand "math/rand"
+	"strings"
 	"testing"
+	"unicode"
 )
 
 func TestGeneratePassword(t *testing.T) {
@@ -20,3 +26,207 @@ func TestGeneratePassword(t *testing.T) {
 		t.Error("Generated password should not be empty")
 	}
 }
+
+// failingReader is an entropy source that always fails
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) {
+	return 0, errors.New("entropy source unavailable")
+}
+
+// useRandReader replaces the entropy source for the rest of the test
+func useRandReader(t *testing.T, r io.Reader) {
+	t.Helper()
+	saved := randReader
+	randReader = r
+	t.Cleanup(func() { randReader = saved })
+}
+
+// chiSquare returns Pearson's chi-square statistic of counts against equal expected counts
+func chiSquare(counts []int) float64 {
+	total := 0
+	for _, n := range counts {
+		total += n
+	}
+	expected := float64(total) / float64(len(counts))
+	stat := 0.0
+	for _, n := range counts {
+		stat += (float64(n) - expected) * (float64(n) - expected) / expected
+	}
+	return stat
+}
+
+// TestGeneratePasswordErrors checks that bad lengths and entropy source failures are reported
+func TestGeneratePasswordErrors(t *testing.T) {
+	for _, length := range []int{0, -1} {
+		if _, err := GeneratePassword(length); err == nil {
+			t.Errorf("GeneratePassword(%d) err = nil; want error", length)
+		}
+	}
+
+	useRandReader(t, failingReader{})
+	if _, err := GeneratePassword(12); err == nil || !strings.Contains(err.Error(), "entropy source unavailable") {
+		t.Errorf("GeneratePassword() err = %v; want the entropy source error", err)
+	}
+	if _, _, err := GeneratePassphrase(PassphraseOptions{Words: 4}); err == nil {
+		t.Errorf("GeneratePassphrase() err = nil; want error")
+	}
+	if _, _, err := GeneratePolicyPassword(PasswordPolicy{Length: 8, Require: []string{"digit"}}); err == nil {
+		t.Errorf("GeneratePolicyPassword() err = nil; want error")
+	}
+}
+
+// TestGeneratePasswordUniform checks with a chi-square test that every character of charset
+// is equally likely. A seeded source keeps the test reproducible; the critical values are
+// those of a 0.1% significance level. Taking random bytes modulo len(charset) would make 40
+// of the characters a third more likely than the rest and exceed it many times over.
+func TestGeneratePasswordUniform(t *testing.T) {
+	useRandReader(t, mathrand.New(mathrand.NewSource(1)))
+
+	index := make(map[byte]int, len(charset))
+	for i := 0; i < len(charset); i++ {
+		index[charset[i]] = i
+	}
+	counts := make([]int, len(charset))
+	for i := 0; i < 2000; i++ {
+		pass, err := GeneratePassword(36)
+		if err != nil {
+			t.Fatalf("GeneratePassword() err = %v; want nil", err)
+		}
+		for j := 0; j < len(pass); j++ {
+			counts[index[pass[j]]]++
+		}
+	}
+	// 71 degrees of freedom
+	if stat := chiSquare(counts); stat > 113.6 {
+		t.Errorf("GeneratePassword() chi-square = %.1f; want at most 113.6 for uniform characters", stat)
+	}
+
+	// Ranges that do not divide 256 are where taking random bytes modulo n would be biased
+	for _, tt := range []struct {
+		n        int
+		critical float64
+	}{{3, 13.82}, {10, 27.88}, {7776, 8166}} {
+		counts := make([]int, tt.n)
+		for i := 0; i < 100*tt.n; i++ {
+			n, err := randomIndex(tt.n)
+			if err != nil {
+				t.Fatalf("randomIndex(%d) err = %v; want nil", tt.n, err)
+			}
+			counts[n]++
+		}
+		if stat := chiSquare(counts); stat > tt.critical {
+			t.Errorf("randomIndex(%d) chi-square = %.1f; want at most %.2f", tt.n, stat, tt.critical)
+		}
+	}
+}
+
+// TestGeneratePassphrase checks that passphrases are built from the EFF wordlist
+func TestGeneratePassphrase(t *testing.T) {
+	list := dicewareWords()
+	if len(list) != 7776 || list[0] != "abacus" || list[7775] != "zoom" {
+		t.Fatalf("dicewareWords() has %d words; want the 7776 EFF words", len(list))
+	}
+	known := make(map[string]bool, len(list))
+	for _, word := range list {
+		known[word] = true
+	}
+
+	pass, entropy, err := GeneratePassphrase(PassphraseOptions{Words: 6, Separator: " "})
+	if err != nil {
+		t.Fatalf("GeneratePassphrase() err = %v; want nil", err)
+	}
+	words := strings.Split(pass, " ")
+	if len(words) != 6 {
+		t.Fatalf("GeneratePassphrase() = %q; want 6 words", pass)
+	}
+	for _, word := range words {
+		if !known[word] {
+			t.Errorf("GeneratePassphrase() word %q is not in the wordlist", word)
+		}
+	}
+	if math.Abs(entropy-77.55) > 0.01 {
+		t.Errorf("GeneratePassphrase() entropy = %.2f; want 77.55", entropy)
+	}
+
+	pass, entropy, err = GeneratePassphrase(PassphraseOptions{Words: 4, Separator: ".", Capitalize: true, Digits: 2})
+	if err != nil {
+		t.Fatalf("GeneratePassphrase() err = %v; want nil", err)
+	}
+	for _, word := range strings.Split(pass, ".") {
+		if !unicode.IsUpper(rune(word[0])) {
+			t.Errorf("GeneratePassphrase() word %q is not capitalized", word)
+		}
+	}
+	if digits := len(strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, pass)); digits != 2 {
+		t.Errorf("GeneratePassphrase() = %q; want 2 digits", pass)
+	}
+	// Of the 16 placements of two digits on four words, the 12 on different words give
+	// 6 passphrases, each twice as likely as one with both digits on the same word
+	if want := 4*math.Log2(7776) + 2*math.Log2(10) + 3; math.Abs(entropy-want) > 0.01 {
+		t.Errorf("GeneratePassphrase() entropy = %.2f; want %.2f", entropy, want)
+	}
+
+	if _, _, err := GeneratePassphrase(PassphraseOptions{}); err == nil {
+		t.Errorf("GeneratePassphrase() without words err = nil; want error")
+	}
+}
+
+// TestDigitEntropy checks the bound against the most likely placement found by trying every draw
+func TestDigitEntropy(t *testing.T) {
+	for _, tt := range []struct{ digits, words int }{{0, 3}, {1, 4}, {2, 4}, {3, 2}, {4, 3}, {5, 6}} {
+		draws := 1
+		for i := 0; i < tt.digits; i++ {
+			draws *= tt.words
+		}
+		placements := make(map[string]int)
+		for d := 0; d < draws; d++ {
+			counts := make([]byte, tt.words)
+			for i, rest := 0, d; i < tt.digits; i, rest = i+1, rest/tt.words {
+				counts[rest%tt.words]++
+			}
+			placements[string(counts)]++
+		}
+		most := 0
+		for _, n := range placements {
+			most = max(most, n)
+		}
+		want := float64(tt.digits)*math.Log2(10) + math.Log2(float64(draws)/float64(most))
+		if got := digitEntropy(tt.digits, tt.words); math.Abs(got-want) > 1e-9 {
+			t.Errorf("digitEntropy(%d, %d) = %.4f; want %.4f", tt.digits, tt.words, got, want)
+		}
+	}
+}
+
+// TestGeneratePronounceable checks that consonants and vowels alternate
+func TestGeneratePronounceable(t *testing.T) {
+	pass, entropy, err := GeneratePronounceable(10)
+	if err != nil {
+		t.Fatalf("GeneratePronounceable() err = %v; want nil", err)
+	}
+	if len(pass) != 10 {
+		t.Fatalf("GeneratePronounceable() = %q; want 10 letters", pass)
+	}
+	for i := 1; i < len(pass); i++ {
+		if strings.ContainsRune(vowels, rune(pass[i])) == strings.ContainsRune(vowels, rune(pass[i-1])) {
+			t.Errorf("GeneratePronounceable() = %q; want alternating consonants and vowels", pass)
+			break
+		}
+	}
+	if want := 1 + 5*math.Log2(17) + 5*math.Log2(5); math.Abs(entropy-want) > 0.01 {
+		t.Errorf("GeneratePronounceable() entropy = %.2f; want %.2f", entropy, want)
+	}
+
+	// Odd lengths report the branch starting with a vowel, whichever was drawn
+	want := 1 + 4*math.Log2(17) + 5*math.Log2(5)
+	for i := 0; i < 20; i++ {
+		if _, entropy, err := GeneratePronounceable(9); err != nil || math.Abs(entropy-want) > 0.01 {
+			t.Fatalf("GeneratePronounceable(9) entropy = %.2f, %v; want %.2f", entropy, err, want)
+		}
+	}
+}
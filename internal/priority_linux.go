@@ -0,0 +1,39 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	ioprioWhoProcess = 1  // IOPRIO_WHO_PROCESS
+	ioprioClassIdle  = 3  // IOPRIO_CLASS_IDLE
+	ioprioClassShift = 13 // IOPRIO_CLASS_SHIFT
+)
+
+// setLowPriority gives the process the lowest CPU priority and the idle I/O class,
+// the equivalent of running under `nice -n 19 ionice -c 3`.
+func setLowPriority() error {
+	// Both priorities are per thread on Linux; threads created later inherit them
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return fmt.Errorf("error listing threads: %v", err)
+	}
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+		if err := unix.Setpriority(unix.PRIO_PROCESS, tid, 19); err != nil {
+			return fmt.Errorf("error lowering CPU priority: %v", err)
+		}
+		_, _, errno := unix.Syscall(unix.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), ioprioClassIdle<<ioprioClassShift)
+		if errno != 0 {
+			return fmt.Errorf("error lowering I/O priority: %v", errno)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package internal
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// setLowPriority gives the process the lowest CPU priority.
+// I/O scheduling classes are only available on Linux.
+func setLowPriority() error {
+	if err := syscall.Setpriority(syscall.PRIO_PROCESS, 0, 19); err != nil {
+		return fmt.Errorf("error lowering CPU priority: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package internal
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// reflinkCopy creates dst as a copy-on-write clone of src (FICLONE), with the metadata in info.
+// It fails on filesystems without reflink support, such as ext4.
+func reflinkCopy(src, dst string, info os.FileInfo) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if err := unix.IoctlFileClone(int(out.Fd()), int(in.Fd())); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	uid, gid := fileOwner(info)
+	return restoreMetadata(dst, info.Mode(), info.ModTime(), uid, gid)
+}
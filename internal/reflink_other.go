@@ -0,0 +1,13 @@
+//go:build !linux
+
+package internal
+
+import (
+	"errors"
+	"os"
+)
+
+// reflinkCopy is not supported outside Linux.
+func reflinkCopy(src, dst string, info os.FileInfo) error {
+	return errors.New("reflinks are only supported on Linux")
+}
@@ -0,0 +1,354 @@
+package internal
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// OutputFormat selects how hashcheck results are written
+type OutputFormat string
+
+const (
+	OutputText   OutputFormat = "text"   // Human readable report
+	OutputJSON   OutputFormat = "json"   // A single JSON document
+	OutputCSV    OutputFormat = "csv"    // One row per file, with a header row
+	OutputNDJSON OutputFormat = "ndjson" // One JSON record per line, ending with a summary record
+)
+
+// ParseOutputFormat validates an output format name
+func ParseOutputFormat(s string) (OutputFormat, error) {
+	switch f := OutputFormat(s); f {
+	case OutputText, OutputJSON, OutputCSV, OutputNDJSON:
+		return f, nil
+	default:
+		return "", fmt.Errorf("invalid output format %q (want text, json, csv or ndjson)", s)
+	}
+}
+
+// DuplicateGroup is a set of identical files as written in structured reports
+type DuplicateGroup struct {
+	Type   string   `json:"type,omitempty"` // "group" in NDJSON records
+	Hash   string   `json:"hash"`
+	Size   int64    `json:"size"`
+	Count  int      `json:"count"`
+	Wasted int64    `json:"wasted_bytes"`
+	Files  []string `json:"files"`
+}
+
+// SimilarGroup is a cluster of similar files as written in structured reports
+type SimilarGroup struct {
+	Type       string    `json:"type,omitempty"` // "similar" in NDJSON records
+	Kind       FuzzyKind `json:"kind"`
+	Similarity int       `json:"similarity"`
+	Count      int       `json:"count"`
+	Files      []string  `json:"files"`
+}
+
+// ErrorRecord is a file that could not be processed
+type ErrorRecord struct {
+	Type  string `json:"type,omitempty"` // "error" in NDJSON records
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// DuplicateSummary totals a duplicate report
+type DuplicateSummary struct {
+	Type      string `json:"type,omitempty"` // "summary" in NDJSON records
+	Algorithm string `json:"algorithm"`
+	Groups    int    `json:"groups"`
+	Files     int    `json:"files"`
+	Wasted    int64  `json:"wasted_bytes"`
+	Errors    int    `json:"errors"`
+	Similar   int    `json:"similar_groups,omitempty"`
+}
+
+// DuplicateReport is the JSON document describing the duplicates found
+type DuplicateReport struct {
+	Summary DuplicateSummary `json:"summary"`
+	Groups  []DuplicateGroup `json:"groups"`
+	Similar []SimilarGroup   `json:"similar,omitempty"` // Only with fuzzy matching
+	Errors  []ErrorRecord    `json:"errors"`
+}
+
+// NewDuplicateReport converts duplicate sets and errors into a report.
+// The sets and errors keep their order, which FindDuplicates makes deterministic.
+func NewDuplicateReport(algo string, sets []DuplicateSet, errs []FileError) DuplicateReport {
+	r := DuplicateReport{
+		Summary: DuplicateSummary{Algorithm: algo, Groups: len(sets), Errors: len(errs)},
+		Groups:  make([]DuplicateGroup, 0, len(sets)),
+		Errors:  errorRecords(errs),
+	}
+	for _, set := range sets {
+		r.Groups = append(r.Groups, DuplicateGroup{
+			Hash:   set.Hash,
+			Size:   set.Size,
+			Count:  len(set.Files),
+			Wasted: set.Wasted(),
+			Files:  set.Files,
+		})
+		r.Summary.Files += len(set.Files)
+		r.Summary.Wasted += set.Wasted()
+	}
+	return r
+}
+
+// AddSimilar adds the clusters of similar files found by FindSimilar and their errors to the report
+func (r *DuplicateReport) AddSimilar(sets []SimilarSet, errs []FileError) {
+	for _, set := range sets {
+		r.Similar = append(r.Similar, SimilarGroup{Kind: set.Kind, Similarity: set.Similarity, Count: len(set.Files), Files: set.Files})
+	}
+	r.Summary.Similar = len(sets)
+	r.Errors = append(r.Errors, errorRecords(errs)...)
+	r.Summary.Errors = len(r.Errors)
+}
+
+// errorRecords converts file errors for structured output
+func errorRecords(errs []FileError) []ErrorRecord {
+	records := make([]ErrorRecord, 0, len(errs))
+	for _, e := range errs {
+		records = append(records, ErrorRecord{Path: e.Path, Error: e.Err.Error()})
+	}
+	return records
+}
+
+// WriteDuplicateReport writes the report in a structured format
+func WriteDuplicateReport(w io.Writer, r DuplicateReport, format OutputFormat) error {
+	switch format {
+	case OutputJSON:
+		return writeJSON(w, r)
+
+	case OutputNDJSON:
+		enc := json.NewEncoder(w)
+		for _, g := range r.Groups {
+			g.Type = "group"
+			if err := enc.Encode(g); err != nil {
+				return err
+			}
+		}
+		for _, s := range r.Similar {
+			s.Type = "similar"
+			if err := enc.Encode(s); err != nil {
+				return err
+			}
+		}
+		for _, e := range r.Errors {
+			e.Type = "error"
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+		r.Summary.Type = "summary"
+		return enc.Encode(r.Summary)
+
+	case OutputCSV:
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"record", "group", "hash", "size", "count", "wasted_bytes", "path", "error", "kind", "similarity"})
+		for i, g := range r.Groups {
+			for _, f := range g.Files {
+				cw.Write([]string{"file", strconv.Itoa(i + 1), g.Hash, strconv.FormatInt(g.Size, 10),
+					strconv.Itoa(g.Count), strconv.FormatInt(g.Wasted, 10), f, "", "", ""})
+			}
+		}
+		for i, s := range r.Similar {
+			for _, f := range s.Files {
+				cw.Write([]string{"similar", strconv.Itoa(i + 1), "", "", strconv.Itoa(s.Count), "", f, "",
+					string(s.Kind), strconv.Itoa(s.Similarity)})
+			}
+		}
+		for _, e := range r.Errors {
+			cw.Write([]string{"error", "", "", "", "", "", e.Path, e.Error, "", ""})
+		}
+		cw.Flush()
+		return cw.Error()
+
+	default:
+		return fmt.Errorf("unsupported structured output format %q", format)
+	}
+}
+
+// VerifyRecord is the status of a single file as written in structured reports
+type VerifyRecord struct {
+	Type   string       `json:"type,omitempty"` // "file" in NDJSON records
+	Path   string       `json:"path"`
+	Status VerifyStatus `json:"status"`
+	Error  string       `json:"error,omitempty"`
+}
+
+// VerifySummary counts the files of each status
+type VerifySummary struct {
+	Type    string `json:"type,omitempty"` // "summary" in NDJSON records
+	OK      int    `json:"ok"`
+	Failed  int    `json:"failed"`
+	Missing int    `json:"missing"`
+	New     int    `json:"new"`
+	Errors  int    `json:"errors"` // Files that could not be accessed while scanning the directory
+}
+
+// Passed reports whether every file matched the manifest
+func (s VerifySummary) Passed() bool {
+	return s.Failed+s.Missing+s.New == 0
+}
+
+// SummarizeVerify counts the results of each status
+func SummarizeVerify(results []VerifyResult) VerifySummary {
+	var s VerifySummary
+	for _, r := range results {
+		switch r.Status {
+		case StatusOK:
+			s.OK++
+		case StatusFailed:
+			s.Failed++
+		case StatusMissing:
+			s.Missing++
+		case StatusNew:
+			s.New++
+		}
+	}
+	return s
+}
+
+// WriteVerifyReport writes the verification results and the files that could not be
+// accessed while scanning the directory in a structured format
+func WriteVerifyReport(w io.Writer, results []VerifyResult, errs []FileError, format OutputFormat) error {
+	records := make([]VerifyRecord, 0, len(results))
+	for _, r := range results {
+		rec := VerifyRecord{Path: r.Path, Status: r.Status}
+		if r.Err != nil {
+			rec.Error = r.Err.Error()
+		}
+		records = append(records, rec)
+	}
+	errRecords := errorRecords(errs)
+	summary := SummarizeVerify(results)
+	summary.Errors = len(errs)
+
+	switch format {
+	case OutputJSON:
+		return writeJSON(w, struct {
+			Summary VerifySummary  `json:"summary"`
+			Files   []VerifyRecord `json:"files"`
+			Errors  []ErrorRecord  `json:"errors"`
+		}{summary, records, errRecords})
+
+	case OutputNDJSON:
+		enc := json.NewEncoder(w)
+		for _, rec := range records {
+			rec.Type = "file"
+			if err := enc.Encode(rec); err != nil {
+				return err
+			}
+		}
+		for _, e := range errRecords {
+			e.Type = "error"
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+		summary.Type = "summary"
+		return enc.Encode(summary)
+
+	case OutputCSV:
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"path", "status", "error"})
+		for _, rec := range records {
+			cw.Write([]string{rec.Path, string(rec.Status), rec.Error})
+		}
+		for _, e := range errRecords {
+			cw.Write([]string{e.Path, "ERROR", e.Error})
+		}
+		cw.Flush()
+		return cw.Error()
+
+	default:
+		return fmt.Errorf("unsupported structured output format %q", format)
+	}
+}
+
+// writeJSON writes an indented JSON document
+func writeJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// CompareRecord is a difference between two trees as written in structured reports
+type CompareRecord struct {
+	Type   string        `json:"type,omitempty"` // "difference" in NDJSON records
+	Status CompareStatus `json:"status"`
+	PathA  string        `json:"path_a,omitempty"`
+	PathB  string        `json:"path_b,omitempty"`
+	HashA  string        `json:"hash_a,omitempty"`
+	HashB  string        `json:"hash_b,omitempty"`
+}
+
+// compareSummaryRecord is the summary of a comparison as written in structured reports
+type compareSummaryRecord struct {
+	Type      string `json:"type,omitempty"` // "summary" in NDJSON records
+	Identical int    `json:"identical"`
+	OnlyA     int    `json:"only_in_a"`
+	OnlyB     int    `json:"only_in_b"`
+	Different int    `json:"different"`
+	Moved     int    `json:"moved"`
+	Errors    int    `json:"errors"`
+}
+
+// WriteCompareReport writes the differences between two trees in a structured format
+func WriteCompareReport(w io.Writer, results []CompareResult, summary CompareSummary, errs []FileError, format OutputFormat) error {
+	records := make([]CompareRecord, 0, len(results))
+	for _, r := range results {
+		records = append(records, CompareRecord{Status: r.Status, PathA: r.PathA, PathB: r.PathB, HashA: r.HashA, HashB: r.HashB})
+	}
+	errRecords := errorRecords(errs)
+	sum := compareSummaryRecord{
+		Identical: summary.Identical,
+		OnlyA:     summary.OnlyA,
+		OnlyB:     summary.OnlyB,
+		Different: summary.Different,
+		Moved:     summary.Moved,
+		Errors:    len(errs),
+	}
+
+	switch format {
+	case OutputJSON:
+		return writeJSON(w, struct {
+			Summary     compareSummaryRecord `json:"summary"`
+			Differences []CompareRecord      `json:"differences"`
+			Errors      []ErrorRecord        `json:"errors"`
+		}{sum, records, errRecords})
+
+	case OutputNDJSON:
+		enc := json.NewEncoder(w)
+		for _, rec := range records {
+			rec.Type = "difference"
+			if err := enc.Encode(rec); err != nil {
+				return err
+			}
+		}
+		for _, e := range errRecords {
+			e.Type = "error"
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+		sum.Type = "summary"
+		return enc.Encode(sum)
+
+	case OutputCSV:
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"status", "path_a", "path_b", "hash_a", "hash_b", "error"})
+		for _, rec := range records {
+			cw.Write([]string{string(rec.Status), rec.PathA, rec.PathB, rec.HashA, rec.HashB, ""})
+		}
+		for _, e := range errRecords {
+			cw.Write([]string{"ERROR", e.Path, "", "", "", e.Error})
+		}
+		cw.Flush()
+		return cw.Error()
+
+	default:
+		return fmt.Errorf("unsupported structured output format %q", format)
+	}
+}
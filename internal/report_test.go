@@ -0,0 +1,117 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// TestWriteDuplicateReport checks that every structured format carries the groups, errors and totals
+func TestWriteDuplicateReport(t *testing.T) {
+	sets := []DuplicateSet{
+		{Algorithm: "sha256", Hash: "aa", Size: 100, Files: []string{"x/1", "x/2", "x/3"}},
+		{Algorithm: "sha256", Hash: "bb", Size: 10, Files: []string{"y/1", "y/2"}},
+	}
+	errs := []FileError{{Path: "z", Err: errors.New("permission denied")}}
+	report := NewDuplicateReport("sha256", sets, errs)
+
+	if report.Summary.Files != 5 || report.Summary.Wasted != 210 || report.Groups[0].Count != 3 {
+		t.Errorf("NewDuplicateReport() summary = %+v; want 5 files wasting 210 bytes", report.Summary)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteDuplicateReport(&buf, report, OutputJSON); err != nil {
+		t.Fatalf("WriteDuplicateReport(json) err = %v; want nil", err)
+	}
+	var decoded DuplicateReport
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("json.Unmarshal() err = %v; want nil", err)
+	}
+	if len(decoded.Groups) != 2 || decoded.Groups[1].Hash != "bb" || decoded.Errors[0].Path != "z" {
+		t.Errorf("JSON report = %+v; want both groups in order and the error", decoded)
+	}
+
+	buf.Reset()
+	if err := WriteDuplicateReport(&buf, report, OutputNDJSON); err != nil {
+		t.Fatalf("WriteDuplicateReport(ndjson) err = %v; want nil", err)
+	}
+	var types []string
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var rec struct{ Type string }
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("json.Unmarshal(%q) err = %v; want nil", line, err)
+		}
+		types = append(types, rec.Type)
+	}
+	if strings.Join(types, ",") != "group,group,error,summary" {
+		t.Errorf("NDJSON record types = %v; want group,group,error,summary", types)
+	}
+
+	buf.Reset()
+	if err := WriteDuplicateReport(&buf, report, OutputCSV); err != nil {
+		t.Fatalf("WriteDuplicateReport(csv) err = %v; want nil", err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("csv.ReadAll() err = %v; want nil", err)
+	}
+	if len(rows) != 7 || rows[1][6] != "x/1" || rows[6][0] != "error" {
+		t.Errorf("CSV rows = %v; want a header, five files and one error", rows)
+	}
+}
+
+// TestWriteVerifyReport checks that files skipped while scanning appear as error records
+func TestWriteVerifyReport(t *testing.T) {
+	results := []VerifyResult{
+		{Path: "a", Status: StatusOK},
+		{Path: "b", Status: StatusMissing},
+	}
+	errs := []FileError{{Path: "c", Err: errors.New("permission denied")}}
+
+	var buf bytes.Buffer
+	if err := WriteVerifyReport(&buf, results, errs, OutputJSON); err != nil {
+		t.Fatalf("WriteVerifyReport(json) err = %v; want nil", err)
+	}
+	var decoded struct {
+		Summary VerifySummary
+		Files   []VerifyRecord
+		Errors  []ErrorRecord
+	}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("json.Unmarshal() err = %v; want nil", err)
+	}
+	if decoded.Summary.Errors != 1 || len(decoded.Files) != 2 || len(decoded.Errors) != 1 || decoded.Errors[0].Path != "c" {
+		t.Errorf("JSON report = %+v; want two files and the error", decoded)
+	}
+
+	buf.Reset()
+	if err := WriteVerifyReport(&buf, results, errs, OutputNDJSON); err != nil {
+		t.Fatalf("WriteVerifyReport(ndjson) err = %v; want nil", err)
+	}
+	var types []string
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var rec struct{ Type string }
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("json.Unmarshal(%q) err = %v; want nil", line, err)
+		}
+		types = append(types, rec.Type)
+	}
+	if strings.Join(types, ",") != "file,file,error,summary" {
+		t.Errorf("NDJSON record types = %v; want file,file,error,summary", types)
+	}
+
+	buf.Reset()
+	if err := WriteVerifyReport(&buf, results, errs, OutputCSV); err != nil {
+		t.Fatalf("WriteVerifyReport(csv) err = %v; want nil", err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("csv.ReadAll() err = %v; want nil", err)
+	}
+	if len(rows) != 4 || rows[3][0] != "c" || rows[3][1] != "ERROR" {
+		t.Errorf("CSV rows = %v; want a header, two files and one error", rows)
+	}
+}
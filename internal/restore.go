@@ -89,7 +89,7 @@ func restoreItem(tr *tar.Reader, header *tar.Header, destDir string) error {
 	case tar.TypeDir:
 		return createDirectory(targetPath, header.Mode)
 	case tar.TypeReg:
-		return restoreFile(tr, targetPath, header.Mode)
+		return restoreFile(tr, targetPath, header)
 	case tar.TypeSymlink:
 		return createSymlink(header.Linkname, targetPath)
 	default:
@@ -102,16 +102,19 @@ func createDirectory(path string, mode int64) error {
 	return os.MkdirAll(path, os.FileMode(mode))
 }
 
-// restoreFile extracts a regular file from the tar reader.
-func restoreFile(tr *tar.Reader, path string, mode int64) error {
+// restoreFile extracts a regular file from the tar reader, recreating holes of sparse files.
+func restoreFile(tr *tar.Reader, path string, header *tar.Header) error {
 	if err := ensureParentDir(path); err != nil {
 		return err
 	}
-	outFile, err := createFile(path, mode)
+	outFile, err := createFile(path, header.Mode)
 	if err != nil {
 		return err
 	}
 	defer outFile.Close()
+	if isSparseHeader(header) {
+		return copySparse(outFile, tr, header.Size)
+	}
 	return copyFileContents(outFile, tr)
 }
 
@@ -120,9 +123,9 @@ func ensureParentDir(path string) error {
 	return os.MkdirAll(filepath.Dir(path), 0755)
 }
 
-// createFile creates a file with the specified mode.
+// createFile creates a file with the specified mode, truncating any earlier entry of the same name.
 func createFile(path string, mode int64) (*os.File, error) {
-	return os.OpenFile(path, os.O_CREATE|os.O_WRONLY, os.FileMode(mode))
+	return os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(mode))
 }
 
 // copyFileContents copies data from the tar reader to the output file.
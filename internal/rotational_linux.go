@@ -0,0 +1,25 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// isRotational reports whether a device is a spinning disk, according to sysfs.
+// Partitions report through their parent disk; virtual and network filesystems are not rotational.
+func isRotational(dev uint64) bool {
+	block := fmt.Sprintf("/sys/dev/block/%d:%d", unix.Major(dev), unix.Minor(dev))
+	for _, path := range []string{
+		filepath.Join(block, "queue", "rotational"),
+		filepath.Join(block, "..", "queue", "rotational"),
+	} {
+		if data, err := os.ReadFile(path); err == nil {
+			return strings.TrimSpace(string(data)) == "1"
+		}
+	}
+	return false
+}
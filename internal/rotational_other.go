@@ -0,0 +1,8 @@
+//go:build !linux
+
+package internal
+
+// isRotational reports no spinning disks, the disk type is only known on Linux
+func isRotational(dev uint64) bool {
+	return false
+}
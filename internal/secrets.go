@@ -0,0 +1,158 @@
+package internal
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"regexp"
+	"time"
+)
+
+// DefaultSecretSize is the size of generated tokens and secrets in bytes, 256 bits
+const DefaultSecretSize = 32
+
+// Alphabets of tokens and base58 secrets
+const (
+	base62Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz" // Bitcoin's, without 0, O, I and l
+)
+
+// SecretEncodings are the encodings RandomSecret supports
+var SecretEncodings = []string{"hex", "base64", "base64url", "base58"}
+
+// tokenPrefix is what GenerateToken accepts as a prefix, like the "ghp" of GitHub tokens
+var tokenPrefix = regexp.MustCompile(`^[a-z][a-z0-9]*$`)
+
+// randomBytes reads n bytes from randReader
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(randReader, b); err != nil {
+		return nil, fmt.Errorf("failed to read random numbers: %v", err)
+	}
+	return b, nil
+}
+
+// GenerateToken returns an API token of base62 characters holding at least size bytes of
+// entropy, after prefix and an underscore when a prefix is given. Prefixed tokens are easy
+// to tell apart and to find with secret scanners.
+func GenerateToken(prefix string, size int) (string, error) {
+	if size < 16 {
+		return "", fmt.Errorf("token size must be at least 16 bytes")
+	}
+	if prefix != "" && !tokenPrefix.MatchString(prefix) {
+		return "", fmt.Errorf("invalid prefix %q, it must be lowercase letters and digits starting with a letter", prefix)
+	}
+	length := int(math.Ceil(float64(8*size) / math.Log2(float64(len(base62Alphabet)))))
+	token := make([]byte, length)
+	for i := range token {
+		j, err := randomIndex(len(base62Alphabet))
+		if err != nil {
+			return "", err
+		}
+		token[i] = base62Alphabet[j]
+	}
+	if prefix == "" {
+		return string(token), nil
+	}
+	return prefix + "_" + string(token), nil
+}
+
+// RandomSecret returns size random bytes in one of SecretEncodings. base64 is padded,
+// base64url is not, as is usual in URLs and JWT keys.
+func RandomSecret(size int, encoding string) (string, error) {
+	if size < 1 {
+		return "", fmt.Errorf("secret size must be at least 1 byte")
+	}
+	var encode func([]byte) string
+	switch encoding {
+	case "hex":
+		encode = hex.EncodeToString
+	case "base64":
+		encode = base64.StdEncoding.EncodeToString
+	case "base64url":
+		encode = base64.RawURLEncoding.EncodeToString
+	case "base58":
+		encode = Base58Encode
+	default:
+		return "", fmt.Errorf("invalid encoding %q (want hex, base64, base64url or base58)", encoding)
+	}
+	b, err := randomBytes(size)
+	if err != nil {
+		return "", err
+	}
+	return encode(b), nil
+}
+
+// Base58Encode encodes b in base58 with the Bitcoin alphabet. Each leading zero byte
+// becomes a leading 1.
+func Base58Encode(b []byte) string {
+	zeros := 0
+	for zeros < len(b) && b[zeros] == 0 {
+		zeros++
+	}
+	var digits []byte
+	n := new(big.Int).SetBytes(b)
+	radix, mod := big.NewInt(int64(len(base58Alphabet))), new(big.Int)
+	for n.Sign() > 0 {
+		n.DivMod(n, radix, mod)
+		digits = append(digits, base58Alphabet[mod.Int64()])
+	}
+	for i := 0; i < zeros; i++ {
+		digits = append(digits, base58Alphabet[0])
+	}
+	for i, j := 0, len(digits)-1; i < j; i, j = i+1, j-1 {
+		digits[i], digits[j] = digits[j], digits[i]
+	}
+	return string(digits)
+}
+
+// UUID is an RFC 9562 UUID
+type UUID [16]byte
+
+// String returns the UUID in the usual 8-4-4-4-12 hex form
+func (u UUID) String() string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
+
+// Version returns the version number of the UUID
+func (u UUID) Version() int {
+	return int(u[6] >> 4)
+}
+
+// setVersion sets the version and the RFC 9562 variant bits
+func (u *UUID) setVersion(version byte) {
+	u[6] = u[6]&0x0f | version<<4
+	u[8] = u[8]&0x3f | 0x80
+}
+
+// NewUUIDv4 returns a random UUID
+func NewUUIDv4() (UUID, error) {
+	var u UUID
+	if _, err := io.ReadFull(randReader, u[:]); err != nil {
+		return u, fmt.Errorf("failed to read random numbers: %v", err)
+	}
+	u.setVersion(4)
+	return u, nil
+}
+
+// NewUUIDv7 returns a time ordered UUID for t: the Unix time in milliseconds, then the
+// fraction of the millisecond in 12 bits (method 3 of RFC 9562), then random bits. UUIDs
+// created more than a quarter microsecond apart sort in creation order.
+func NewUUIDv7(t time.Time) (UUID, error) {
+	var u UUID
+	if _, err := io.ReadFull(randReader, u[8:]); err != nil {
+		return u, fmt.Errorf("failed to read random numbers: %v", err)
+	}
+	ns := t.UnixNano()
+	ms, frac := ns/int64(time.Millisecond), ns%int64(time.Millisecond)
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], uint64(ms))
+	copy(u[0:6], ts[2:])
+	binary.BigEndian.PutUint16(u[6:8], uint16(frac*4096/int64(time.Millisecond)))
+	u.setVersion(7)
+	return u, nil
+}
@@ -0,0 +1,120 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestBase58Encode checks known encodings, including leading zero bytes
+func TestBase58Encode(t *testing.T) {
+	tests := []struct {
+		input, want string
+	}{
+		{"", ""},
+		{"61", "2g"},
+		{"626262", "a3gV"},
+		{"636363", "aPEr"},
+		{"00000000000000000000", "1111111111"},
+		{"00eb15231dfceb60925886b67d065299925915aeb172c06647", "1NS17iag9jJgTHD1VXjvLCEnZuQ3rJDE9L"},
+		{hex.EncodeToString([]byte("Hello World!")), "2NEpo7TZRRrLZSi2U"},
+	}
+	for _, tt := range tests {
+		input, _ := hex.DecodeString(tt.input)
+		if got := Base58Encode(input); got != tt.want {
+			t.Errorf("Base58Encode(%s) = %q; want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+// TestRandomSecret checks the length and alphabet of each encoding
+func TestRandomSecret(t *testing.T) {
+	useRandReader(t, bytes.NewReader(bytes.Repeat([]byte{0xff}, 1000)))
+	tests := []struct {
+		encoding string
+		want     string
+	}{
+		{"hex", strings.Repeat("ff", 32)},
+		{"base64", base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{0xff}, 32))},
+		{"base64url", strings.Repeat("_", 42) + "8"},
+		{"base58", "JEKNVnkbo3jma5nREBBJCDoXFVeKkD56V3xKrvRmWxFG"},
+	}
+	for _, tt := range tests {
+		got, err := RandomSecret(32, tt.encoding)
+		if err != nil {
+			t.Fatalf("RandomSecret(%s) err = %v; want nil", tt.encoding, err)
+		}
+		if got != tt.want {
+			t.Errorf("RandomSecret(%s) = %q; want %q", tt.encoding, got, tt.want)
+		}
+	}
+	if _, err := RandomSecret(32, "base32"); err == nil {
+		t.Errorf("RandomSecret(base32) err = nil; want an error")
+	}
+}
+
+// TestGenerateToken checks the prefix, length and alphabet of tokens
+func TestGenerateToken(t *testing.T) {
+	token, err := GenerateToken("adm", 32)
+	if err != nil {
+		t.Fatalf("GenerateToken() err = %v; want nil", err)
+	}
+	// 256 bits take 43 base62 characters
+	if !regexp.MustCompile(`^adm_[0-9A-Za-z]{43}$`).MatchString(token) {
+		t.Errorf("GenerateToken() = %q; want adm_ and 43 base62 characters", token)
+	}
+	if token, err := GenerateToken("", 16); err != nil || len(token) != 22 {
+		t.Errorf("GenerateToken() without prefix = %q, %v; want 22 characters", token, err)
+	}
+	for _, prefix := range []string{"Adm", "1adm", "adm_", "a-b"} {
+		if _, err := GenerateToken(prefix, 32); err == nil {
+			t.Errorf("GenerateToken(%q) err = nil; want an invalid prefix error", prefix)
+		}
+	}
+	useRandReader(t, failingReader{})
+	if _, err := GenerateToken("adm", 32); err == nil {
+		t.Errorf("GenerateToken() with a failing entropy source err = nil; want an error")
+	}
+}
+
+// TestUUID checks the format, version and variant of UUIDs and the ordering of version 7
+func TestUUID(t *testing.T) {
+	format := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-([47])[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	v4, err := NewUUIDv4()
+	if err != nil {
+		t.Fatalf("NewUUIDv4() err = %v; want nil", err)
+	}
+	if m := format.FindStringSubmatch(v4.String()); m == nil || m[1] != "4" || v4.Version() != 4 {
+		t.Errorf("NewUUIDv4() = %s; want a version 4 UUID", v4)
+	}
+
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	v7, err := NewUUIDv7(at)
+	if err != nil {
+		t.Fatalf("NewUUIDv7() err = %v; want nil", err)
+	}
+	if m := format.FindStringSubmatch(v7.String()); m == nil || m[1] != "7" || v7.Version() != 7 {
+		t.Errorf("NewUUIDv7() = %s; want a version 7 UUID", v7)
+	}
+	// The first 48 bits are the Unix time in milliseconds, 0x018f34069e00
+	if prefix := v7.String()[:13]; prefix != "018f3406-9e00" {
+		t.Errorf("NewUUIDv7(%v) starts with %s; want 018f3406-9e00", at, prefix)
+	}
+
+	// Later UUIDs sort after earlier ones, also within a millisecond
+	prev := v7
+	for _, d := range []time.Duration{time.Microsecond, 500 * time.Microsecond, time.Millisecond, time.Hour} {
+		next, err := NewUUIDv7(at.Add(d))
+		if err != nil {
+			t.Fatalf("NewUUIDv7() err = %v; want nil", err)
+		}
+		if next.String() <= prev.String() {
+			t.Errorf("NewUUIDv7(+%v) = %s; want after %s", d, next, prev)
+		}
+		prev = next
+	}
+}
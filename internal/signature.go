@@ -0,0 +1,462 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/crypto/ssh"
+)
+
+// SignatureFormat is the kind of key and signature protecting a manifest
+type SignatureFormat string
+
+const (
+	SigMinisign SignatureFormat = "minisign" // Ed25519, verifiable with minisign -V
+	SigSignify  SignatureFormat = "signify"  // Ed25519, verifiable with signify -V
+	SigSSH      SignatureFormat = "ssh"      // Any SSH key, verifiable with ssh-keygen -Y verify -n file
+)
+
+// Constants of the minisign, signify and SSHSIG key and signature formats
+const (
+	untrustedPrefix  = "untrusted comment: "
+	trustedPrefix    = "trusted comment: "
+	minisignOpsLimit = 33554432   // Scrypt work of minisign -G
+	minisignMemLimit = 1073741824 // Scrypt memory of minisign -G
+	sshSigNamespace  = "file"     // Namespace used by ssh-keygen -Y sign for files
+	sshSigArmorBegin = "-----BEGIN SSH SIGNATURE-----"
+	sshSigArmorEnd   = "-----END SSH SIGNATURE-----"
+)
+
+// SignatureExtension is the file extension the tools of a format give signatures
+func SignatureExtension(format SignatureFormat) string {
+	if format == SigMinisign {
+		return ".minisig"
+	}
+	return ".sig"
+}
+
+// SigningKey is a secret key that signs manifests
+type SigningKey struct {
+	Format  SignatureFormat
+	keyID   [8]byte            // Minisign and signify key number
+	private ed25519.PrivateKey // Minisign and signify
+	signer  ssh.Signer         // SSH
+}
+
+// LoadSigningKey reads a minisign, signify or OpenSSH secret key file,
+// decrypting it with the passphrase if it is encrypted
+func LoadSigningKey(path, passphrase string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSigningKey(data, passphrase)
+}
+
+// ParseSigningKey parses a minisign, signify or SSH secret key
+func ParseSigningKey(data []byte, passphrase string) (*SigningKey, error) {
+	if bytes.Contains(data, []byte("PRIVATE KEY-----")) {
+		signer, err := ssh.ParsePrivateKey(data)
+		var missing *ssh.PassphraseMissingError
+		if errors.As(err, &missing) {
+			if passphrase == "" {
+				return nil, fmt.Errorf("the SSH key is encrypted, a passphrase is required")
+			}
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(data, []byte(passphrase))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading SSH key: %v", err)
+		}
+		return &SigningKey{Format: SigSSH, signer: signer}, nil
+	}
+
+	raw, err := decodeKeyFile(data)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case len(raw) == 158 && string(raw[:2]) == "Ed" && string(raw[4:6]) == "B2":
+		return parseMinisignKey(raw, passphrase)
+	case len(raw) == 104 && string(raw[:4]) == "EdBK":
+		return parseSignifyKey(raw, passphrase)
+	default:
+		return nil, fmt.Errorf("unknown secret key format")
+	}
+}
+
+// decodeKeyFile decodes the base64 line of a minisign or signify file, skipping comments
+func decodeKeyFile(data []byte) ([]byte, error) {
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, untrustedPrefix) {
+			continue
+		}
+		raw, err := base64.StdEncoding.DecodeString(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key: %v", err)
+		}
+		return raw, nil
+	}
+	return nil, fmt.Errorf("invalid key: no key data")
+}
+
+// parseMinisignKey decodes a minisign secret key: the algorithms, the scrypt salt and limits,
+// then the key number, secret key and checksum, encrypted with the scrypt output
+func parseMinisignKey(raw []byte, passphrase string) (*SigningKey, error) {
+	keynum := append([]byte{}, raw[54:]...)
+	switch kdf := string(raw[2:4]); kdf {
+	case "\x00\x00":
+		// Unencrypted
+	case "Sc":
+		if passphrase == "" {
+			return nil, fmt.Errorf("the minisign key is encrypted, a passphrase is required")
+		}
+		stream, err := minisignKDF(passphrase, raw[6:38], binary.LittleEndian.Uint64(raw[38:46]), binary.LittleEndian.Uint64(raw[46:54]))
+		if err != nil {
+			return nil, err
+		}
+		for i := range keynum {
+			keynum[i] ^= stream[i]
+		}
+	default:
+		return nil, fmt.Errorf("unsupported minisign key derivation %q", kdf)
+	}
+
+	var k SigningKey
+	copy(k.keyID[:], keynum[:8])
+	k.Format = SigMinisign
+	k.private = ed25519.PrivateKey(keynum[8:72])
+	if !bytes.Equal(minisignChecksum(k.keyID, k.private), keynum[72:]) {
+		return nil, fmt.Errorf("wrong passphrase or corrupted minisign key")
+	}
+	return &k, nil
+}
+
+// minisignChecksum is the BLAKE2b checksum minisign stores with the secret key
+func minisignChecksum(keyID [8]byte, private ed25519.PrivateKey) []byte {
+	h, _ := blake2b.New256(nil)
+	h.Write([]byte("Ed"))
+	h.Write(keyID[:])
+	h.Write(private)
+	return h.Sum(nil)
+}
+
+// minisignKDF derives the 104 byte key stream of minisign, choosing the scrypt parameters
+// from the limits like libsodium's crypto_pwhash_scryptsalsa208sha256
+func minisignKDF(passphrase string, salt []byte, opsLimit, memLimit uint64) ([]byte, error) {
+	opsLimit = max(opsLimit, 32768)
+	r, p := uint64(8), uint64(1)
+	maxN := memLimit / (r * 128)
+	if opsLimit < memLimit/32 {
+		maxN = opsLimit / (r * 4)
+	}
+	nLog2 := uint64(1)
+	for ; nLog2 < 63 && uint64(1)<<nLog2 <= maxN/2; nLog2++ {
+	}
+	if opsLimit >= memLimit/32 {
+		maxRP := min((opsLimit/4)/(uint64(1)<<nLog2), 0x3fffffff)
+		p = maxRP / r
+	}
+	if nLog2 > 30 || p == 0 {
+		return nil, fmt.Errorf("unsupported minisign key derivation limits")
+	}
+	return scrypt.Key([]byte(passphrase), salt, 1<<nLog2, int(r), int(p), 104)
+}
+
+// parseSignifyKey decodes a signify secret key: the algorithms, bcrypt_pbkdf rounds and salt,
+// a checksum, the key number and the secret key, encrypted with the bcrypt_pbkdf output
+func parseSignifyKey(raw []byte, passphrase string) (*SigningKey, error) {
+	rounds := binary.BigEndian.Uint32(raw[4:8])
+	private := append([]byte{}, raw[40:]...)
+	if rounds > 0 {
+		if passphrase == "" {
+			return nil, fmt.Errorf("the signify key is encrypted, a passphrase is required")
+		}
+		stream, err := bcryptPBKDF([]byte(passphrase), raw[8:24], int(rounds), len(private))
+		if err != nil {
+			return nil, err
+		}
+		for i := range private {
+			private[i] ^= stream[i]
+		}
+	}
+	sum := sha512.Sum512(private)
+	if !bytes.Equal(sum[:8], raw[24:32]) {
+		return nil, fmt.Errorf("wrong passphrase or corrupted signify key")
+	}
+
+	k := &SigningKey{Format: SigSignify, private: ed25519.PrivateKey(private)}
+	copy(k.keyID[:], raw[32:40])
+	return k, nil
+}
+
+// GenerateSigningKeys creates an Ed25519 key pair in minisign format. Its signatures are
+// prehashed, so minisign verifies them but signify does not. The secret key is only encrypted
+// if a passphrase is given.
+func GenerateSigningKeys(passphrase string) (publicKey, secretKey []byte, err error) {
+	return generateMinisignKeys(passphrase, minisignOpsLimit, minisignMemLimit)
+}
+
+// generateMinisignKeys creates minisign keys with the given scrypt limits
+func generateMinisignKeys(passphrase string, opsLimit, memLimit uint64) ([]byte, []byte, error) {
+	pub, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	var keyID [8]byte
+	if _, err := rand.Read(keyID[:]); err != nil {
+		return nil, nil, err
+	}
+
+	keynum := append(append(append([]byte{}, keyID[:]...), private...), minisignChecksum(keyID, private)...)
+	raw := make([]byte, 54, 54+len(keynum))
+	copy(raw, "Ed\x00\x00B2")
+	if passphrase != "" {
+		copy(raw[2:], "Sc")
+		if _, err := rand.Read(raw[6:38]); err != nil {
+			return nil, nil, err
+		}
+		binary.LittleEndian.PutUint64(raw[38:], opsLimit)
+		binary.LittleEndian.PutUint64(raw[46:], memLimit)
+		stream, err := minisignKDF(passphrase, raw[6:38], opsLimit, memLimit)
+		if err != nil {
+			return nil, nil, err
+		}
+		for i := range keynum {
+			keynum[i] ^= stream[i]
+		}
+	}
+	raw = append(raw, keynum...)
+
+	comment := "minisign encrypted secret key"
+	if passphrase == "" {
+		comment = "minisign unencrypted secret key"
+	}
+	secretKey := []byte(untrustedPrefix + comment + "\n" + base64.StdEncoding.EncodeToString(raw) + "\n")
+	publicKey := []byte(fmt.Sprintf("%sminisign public key %016X\n%s\n", untrustedPrefix,
+		binary.LittleEndian.Uint64(keyID[:]), base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), keyID[:]...), pub...))))
+	return publicKey, secretKey, nil
+}
+
+// Sign signs a message. Minisign signatures carry the trusted comment, which is signed too;
+// signify and SSH signatures have no room for it.
+func (k *SigningKey) Sign(message []byte, trustedComment string) ([]byte, error) {
+	switch k.Format {
+	case SigMinisign:
+		// Prehashed signature, so large files need not be held in memory to verify
+		hashed := blake2b.Sum512(message)
+		sig := ed25519.Sign(k.private, hashed[:])
+		global := ed25519.Sign(k.private, append(append([]byte{}, sig...), trustedComment...))
+		return []byte(fmt.Sprintf("%ssignature from minisign secret key\n%s\n%s%s\n%s\n", untrustedPrefix,
+			base64.StdEncoding.EncodeToString(append(append([]byte("ED"), k.keyID[:]...), sig...)),
+			trustedPrefix, trustedComment, base64.StdEncoding.EncodeToString(global))), nil
+
+	case SigSignify:
+		sig := ed25519.Sign(k.private, message)
+		return []byte(fmt.Sprintf("%sverify with signify public key\n%s\n", untrustedPrefix,
+			base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), k.keyID[:]...), sig...)))), nil
+
+	default:
+		return k.signSSH(message)
+	}
+}
+
+// signSSH creates an armored SSHSIG signature like ssh-keygen -Y sign -n file
+func (k *SigningKey) signSSH(message []byte) ([]byte, error) {
+	const hashAlgo = "sha512"
+	hashed := sha512.Sum512(message)
+	signed := sshSigSignedData(sshSigNamespace, hashAlgo, hashed[:])
+
+	var sig *ssh.Signature
+	var err error
+	if as, ok := k.signer.(ssh.AlgorithmSigner); ok && k.signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+		sig, err = as.SignWithAlgorithm(rand.Reader, signed, ssh.KeyAlgoRSASHA512) // SHA-1 is not accepted
+	} else {
+		sig, err = k.signer.Sign(rand.Reader, signed)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	blob := []byte("SSHSIG")
+	blob = binary.BigEndian.AppendUint32(blob, 1)
+	blob = appendSSHString(blob, k.signer.PublicKey().Marshal())
+	blob = appendSSHString(blob, []byte(sshSigNamespace))
+	blob = appendSSHString(blob, nil)
+	blob = appendSSHString(blob, []byte(hashAlgo))
+	blob = appendSSHString(blob, ssh.Marshal(sig))
+
+	var out strings.Builder
+	out.WriteString(sshSigArmorBegin + "\n")
+	encoded := base64.StdEncoding.EncodeToString(blob)
+	for len(encoded) > 70 {
+		out.WriteString(encoded[:70] + "\n")
+		encoded = encoded[70:]
+	}
+	out.WriteString(encoded + "\n" + sshSigArmorEnd + "\n")
+	return []byte(out.String()), nil
+}
+
+// sshSigSignedData is the data an SSHSIG signature covers
+func sshSigSignedData(namespace, hashAlgo string, hashed []byte) []byte {
+	data := []byte("SSHSIG")
+	data = appendSSHString(data, []byte(namespace))
+	data = appendSSHString(data, nil)
+	data = appendSSHString(data, []byte(hashAlgo))
+	return appendSSHString(data, hashed)
+}
+
+// appendSSHString appends a length prefixed string in SSH wire format
+func appendSSHString(b, s []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+// VerifySignature checks a minisign, signify or SSH signature of a message against a public key
+// file. SSH public key files may list several keys, one per line, in authorized_keys or
+// allowed_signers format. The trusted comment of minisign signatures is returned.
+func VerifySignature(message, signature, publicKey []byte) (string, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(signature), []byte(sshSigArmorBegin)) {
+		return "", verifySSHSignature(message, signature, publicKey)
+	}
+
+	pub, err := decodeKeyFile(publicKey)
+	if err != nil {
+		return "", err
+	}
+	if len(pub) != 42 || string(pub[:2]) != "Ed" {
+		return "", fmt.Errorf("not a minisign or signify public key")
+	}
+
+	var lines []string
+	for _, line := range strings.Split(string(signature), "\n") {
+		if line = strings.TrimRight(line, "\r"); line != "" && !strings.HasPrefix(line, untrustedPrefix) {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) == 0 {
+		return "", fmt.Errorf("invalid signature: no signature data")
+	}
+	sig, err := base64.StdEncoding.DecodeString(lines[0])
+	if err != nil || len(sig) != 74 {
+		return "", fmt.Errorf("invalid signature")
+	}
+	if !bytes.Equal(sig[2:10], pub[2:10]) {
+		return "", fmt.Errorf("signature was made with a different key (key ID %016X)", binary.LittleEndian.Uint64(sig[2:10]))
+	}
+
+	key := ed25519.PublicKey(pub[10:])
+	signed := message
+	switch string(sig[:2]) {
+	case "Ed":
+	case "ED":
+		hashed := blake2b.Sum512(message)
+		signed = hashed[:]
+	default:
+		return "", fmt.Errorf("unsupported signature algorithm %q", sig[:2])
+	}
+	if !ed25519.Verify(key, signed, sig[10:]) {
+		return "", fmt.Errorf("signature verification failed")
+	}
+
+	// Signify signatures end here, minisign ones sign a trusted comment too
+	if len(lines) == 1 {
+		return "", nil
+	}
+	if len(lines) != 3 || !strings.HasPrefix(lines[1], trustedPrefix) {
+		return "", fmt.Errorf("invalid signature: malformed trusted comment")
+	}
+	comment := strings.TrimPrefix(lines[1], trustedPrefix)
+	global, err := base64.StdEncoding.DecodeString(lines[2])
+	if err != nil || !ed25519.Verify(key, append(append([]byte{}, sig[10:]...), comment...), global) {
+		return "", fmt.Errorf("trusted comment verification failed")
+	}
+	return comment, nil
+}
+
+// verifySSHSignature checks an armored SSHSIG signature made for files
+func verifySSHSignature(message, signature, publicKey []byte) error {
+	armored := strings.TrimSpace(string(signature))
+	armored = strings.TrimSuffix(strings.TrimPrefix(armored, sshSigArmorBegin), sshSigArmorEnd)
+	blob, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(armored), ""))
+	if err != nil || !bytes.HasPrefix(blob, []byte("SSHSIG")) {
+		return fmt.Errorf("invalid SSH signature")
+	}
+
+	var parsed struct {
+		Version   uint32
+		PublicKey []byte
+		Namespace string
+		Reserved  []byte
+		HashAlgo  string
+		Signature []byte
+	}
+	if err := ssh.Unmarshal(blob[6:], &parsed); err != nil || parsed.Version != 1 {
+		return fmt.Errorf("invalid SSH signature")
+	}
+	if parsed.Namespace != sshSigNamespace {
+		return fmt.Errorf("SSH signature is for namespace %q, not %q", parsed.Namespace, sshSigNamespace)
+	}
+	signer, err := ssh.ParsePublicKey(parsed.PublicKey)
+	if err != nil {
+		return fmt.Errorf("invalid SSH signature: %v", err)
+	}
+	if !sshKeyAllowed(signer, publicKey) {
+		return fmt.Errorf("signature was made with a key not in the public key file (%s)", ssh.FingerprintSHA256(signer))
+	}
+
+	var hashed []byte
+	switch parsed.HashAlgo {
+	case "sha512":
+		sum := sha512.Sum512(message)
+		hashed = sum[:]
+	case "sha256":
+		sum := sha256.Sum256(message)
+		hashed = sum[:]
+	default:
+		return fmt.Errorf("unsupported SSH signature hash %q", parsed.HashAlgo)
+	}
+	var sig ssh.Signature
+	if err := ssh.Unmarshal(parsed.Signature, &sig); err != nil {
+		return fmt.Errorf("invalid SSH signature: %v", err)
+	}
+	if sig.Format == ssh.KeyAlgoRSA {
+		return fmt.Errorf("SSH signatures using SHA-1 are not accepted")
+	}
+	if err := signer.Verify(sshSigSignedData(parsed.Namespace, parsed.HashAlgo, hashed), &sig); err != nil {
+		return fmt.Errorf("signature verification failed")
+	}
+	return nil
+}
+
+// sshKeyAllowed reports whether a key is listed in an authorized_keys or allowed_signers file
+func sshKeyAllowed(key ssh.PublicKey, file []byte) bool {
+	for _, line := range strings.Split(string(file), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// Allowed signers lines start with the principals
+		candidates := []string{line}
+		if fields := strings.Fields(line); len(fields) > 1 {
+			candidates = append(candidates, strings.Join(fields[1:], " "))
+		}
+		for _, c := range candidates {
+			if allowed, _, _, _, err := ssh.ParseAuthorizedKey([]byte(c)); err == nil && bytes.Equal(allowed.Marshal(), key.Marshal()) {
+				return true
+			}
+		}
+	}
+	return false
+}
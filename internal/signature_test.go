@@ -0,0 +1,199 @@
+package internal
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// TestMinisignSignature checks encrypted minisign keys and signatures with trusted comments
+func TestMinisignSignature(t *testing.T) {
+	// Small scrypt limits keep the test fast, the format is the same
+	pub, sec, err := generateMinisignKeys("secret", 32768, 16<<20)
+	if err != nil {
+		t.Fatalf("generateMinisignKeys() err = %v; want nil", err)
+	}
+	if _, err := ParseSigningKey(sec, "wrong"); err == nil {
+		t.Errorf("ParseSigningKey() with a wrong passphrase err = nil; want error")
+	}
+	key, err := ParseSigningKey(sec, "secret")
+	if err != nil {
+		t.Fatalf("ParseSigningKey() err = %v; want nil", err)
+	}
+
+	message := []byte("0123abcd  file.txt\n")
+	sig, err := key.Sign(message, "timestamp:1\tfile:SHA256SUMS")
+	if err != nil {
+		t.Fatalf("Sign() err = %v; want nil", err)
+	}
+	comment, err := VerifySignature(message, sig, pub)
+	if err != nil || comment != "timestamp:1\tfile:SHA256SUMS" {
+		t.Errorf("VerifySignature() = %q, %v; want the trusted comment", comment, err)
+	}
+
+	if _, err := VerifySignature([]byte("0123abce  file.txt\n"), sig, pub); err == nil {
+		t.Errorf("VerifySignature() of a changed manifest err = nil; want error")
+	}
+	forged := strings.Replace(string(sig), "timestamp:1", "timestamp:2", 1)
+	if _, err := VerifySignature(message, []byte(forged), pub); err == nil {
+		t.Errorf("VerifySignature() with a changed trusted comment err = nil; want error")
+	}
+	otherPub, _, err := generateMinisignKeys("", 0, 0)
+	if err != nil {
+		t.Fatalf("generateMinisignKeys() err = %v; want nil", err)
+	}
+	if _, err := VerifySignature(message, sig, otherPub); err == nil {
+		t.Errorf("VerifySignature() with another key err = nil; want error")
+	}
+}
+
+// TestMinisignFixtures checks keys and signatures made by minisign: an encrypted secret key with
+// the default scrypt limits, a legacy signature and a prehashed one
+func TestMinisignFixtures(t *testing.T) {
+	const pubKey = "untrusted comment: minisign public key C373193807678450\n" +
+		"RWRQhGcHOBlzw4CoKyugkk4ioDfoxlXxC9LBx+VNhJ3w9w+cAxgvPsuo\n"
+	const legacySig = "untrusted comment: signature from minisign secret key\n" +
+		"RWRQhGcHOBlzwxrJCyuC+rJfHSfyRKRxkuwa3JJ0bWEs7RHjL1OUmqnTr+V1B9JzFuJIH/ybR2Eus9oEZKt9RbitpF/L4D3+5wg=\n" +
+		"trusted comment: timestamp:1614549543\tfile:message.txt\n" +
+		"P/722+ynQ+tIy0qadFHwLx5MsyNz/jDKJkDWQj4dDD2OKnVte8m/M14mwPE/1NMwzShPMSBhMXqZGdbe+UZjDg==\n"
+	comment, err := VerifySignature([]byte("Hello World!\n"), []byte(legacySig), []byte(pubKey))
+	if err != nil || comment != "timestamp:1614549543\tfile:message.txt" {
+		t.Errorf("VerifySignature(legacy minisign signature) = %q, %v; want the trusted comment", comment, err)
+	}
+
+	const hashedPubKey = "untrusted comment: minisign public key E7620F1842B4E81F\n" +
+		"RWQf6LRCGA9i53mlYecO4IzT51TGPpvWucNSCh1CBM0QTaLn73Y7GFO3\n"
+	const hashedSig = "untrusted comment: signature from minisign secret key\n" +
+		"RUQf6LRCGA9i559r3g7V1qNyJDApGip8MfqcadIgT9CuhV3EMhHoN1mGTkUidF/z7SrlQgXdy8ofjb7bNJJylDOocrCo8KLzZwo=\n" +
+		"trusted comment: timestamp:1635443258\tfile:test\thashed\n" +
+		"/cj37GK60vryibFn+ftOgbCvW9NKhKYgjVpFFQUcWPAnjO23wrvVDTt7cloNC06maoBli9q6qwZDXXoaxweICQ==\n"
+	if _, err := VerifySignature([]byte("test"), []byte(hashedSig), []byte(hashedPubKey)); err != nil {
+		t.Errorf("VerifySignature(prehashed minisign signature) err = %v; want nil", err)
+	}
+	if _, err := VerifySignature([]byte("test!"), []byte(hashedSig), []byte(hashedPubKey)); err == nil {
+		t.Errorf("VerifySignature() of a changed message err = nil; want error")
+	}
+
+	if testing.Short() {
+		t.Skip("decrypting a minisign -G key takes 1 GiB of memory")
+	}
+	const secKey = "untrusted comment: minisign encrypted secret key\n" +
+		"RWRTY0Iytaz5znJmUO5kBt5xVkvpBl+29A7pZH86phD4h8vD3V8AAAACAAAAAAAAAEAAAAAA9vH9EcS6NdXNIEGhYGoqG1CiL4aptyJreJ4IfuT4+1h+OgVaY/vi0HsbCP0Y6n/wcy0AN0wOXmVDPP33jZqv82YCj2fH+/6MRuAfzNQYoLvc3sH/8bIwqdfpKIjDRZhvqRf063RFYoI=\n"
+	key, err := ParseSigningKey([]byte(secKey), "correct horse battery staple")
+	if err != nil {
+		t.Fatalf("ParseSigningKey() err = %v; want nil", err)
+	}
+	sig, err := key.Sign([]byte("manifest\n"), "file:SHA256SUMS")
+	if err != nil {
+		t.Fatalf("Sign() err = %v; want nil", err)
+	}
+	if _, err := VerifySignature([]byte("manifest\n"), sig, []byte(pubKey)); err != nil {
+		t.Errorf("VerifySignature() with the minisign public key err = %v; want nil", err)
+	}
+}
+
+// TestSignifySignature checks encrypted signify secret keys and their signatures
+func TestSignifySignature(t *testing.T) {
+	pub, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("ed25519.GenerateKey() err = %v; want nil", err)
+	}
+	keynum := []byte("KEYNUM01")
+	salt := []byte("0123456789abcdef")
+	stream, err := bcryptPBKDF([]byte("secret"), salt, 16, len(private))
+	if err != nil {
+		t.Fatalf("bcryptPBKDF() err = %v; want nil", err)
+	}
+	sum := sha512.Sum512(private)
+	raw := append([]byte("EdBK"), binary.BigEndian.AppendUint32(nil, 16)...)
+	raw = append(append(append(raw, salt...), sum[:8]...), keynum...)
+	for i, b := range private {
+		raw = append(raw, b^stream[i])
+	}
+	sec := "untrusted comment: signify secret key\n" + base64.StdEncoding.EncodeToString(raw) + "\n"
+	pubFile := "untrusted comment: signify public key\n" +
+		base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), keynum...), pub...)) + "\n"
+
+	if _, err := ParseSigningKey([]byte(sec), ""); err == nil {
+		t.Errorf("ParseSigningKey() without passphrase err = nil; want error")
+	}
+	key, err := ParseSigningKey([]byte(sec), "secret")
+	if err != nil {
+		t.Fatalf("ParseSigningKey() err = %v; want nil", err)
+	}
+	message := []byte("manifest\n")
+	sig, err := key.Sign(message, "ignored")
+	if err != nil {
+		t.Fatalf("Sign() err = %v; want nil", err)
+	}
+	if strings.Count(string(sig), "\n") != 2 || SignatureExtension(key.Format) != ".sig" {
+		t.Errorf("Sign() = %q; want a two line signify signature", sig)
+	}
+	if _, err := VerifySignature(message, sig, []byte(pubFile)); err != nil {
+		t.Errorf("VerifySignature() err = %v; want nil", err)
+	}
+}
+
+// TestSignifyFixtures checks a signature made by signify -S
+func TestSignifyFixtures(t *testing.T) {
+	const pubKey = "untrusted comment: signify public key\n" +
+		"RWSZyj9wTc0QvAfiUA2zFbdxSpPGyXLc/Mcxn+7hd9f6+VP+jHu0bu8b\n"
+	const signifySig = "untrusted comment: verify with signify.pub\n" +
+		"RWSZyj9wTc0QvMrf5en3xQSpQcAZCzNyW23BBPBPjQuFVek3KGzNtNCv60pob32eGBL9ZuuiG36GnvcOwFodj7l9dl1jbzNR6QE=\n"
+	if _, err := VerifySignature([]byte("Hello, World!\n"), []byte(signifySig), []byte(pubKey)); err != nil {
+		t.Errorf("VerifySignature(signify signature) err = %v; want nil", err)
+	}
+	if _, err := VerifySignature([]byte("Hello, World?\n"), []byte(signifySig), []byte(pubKey)); err == nil {
+		t.Errorf("VerifySignature() of a changed message err = nil; want error")
+	}
+}
+
+// TestSSHSignature checks SSHSIG signatures against a signature made by ssh-keygen -Y sign -n file
+func TestSSHSignature(t *testing.T) {
+	const pubKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIGRjjF3kduqLgq1alGcG9vpnK8YK036ySGnj+bPoMi+8 me\n"
+	const sshKeygenSig = `-----BEGIN SSH SIGNATURE-----
+U1NIU0lHAAAAAQAAADMAAAALc3NoLWVkMjU1MTkAAAAgZGOMXeR26ouCrVqUZwb2+mcrxg
+rTfrJIaeP5s+gyL7wAAAAEZmlsZQAAAAAAAAAGc2hhNTEyAAAAUwAAAAtzc2gtZWQyNTUx
+OQAAAEBTkreVQCAUGiL7Lvh92MdjzdjAH4Nq4lLad1jX8dlkYFCP90yB0tS5Ev7tWt4co4
+QCo/zDQO2a4nbO4wDahfIK
+-----END SSH SIGNATURE-----
+`
+	message := []byte("hashcheck interop\n")
+	if _, err := VerifySignature(message, []byte(sshKeygenSig), []byte("me@example.com "+pubKey)); err != nil {
+		t.Errorf("VerifySignature(ssh-keygen signature) err = %v; want nil", err)
+	}
+	if _, err := VerifySignature([]byte("changed\n"), []byte(sshKeygenSig), []byte(pubKey)); err == nil {
+		t.Errorf("VerifySignature() of a changed message err = nil; want error")
+	}
+
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("ed25519.GenerateKey() err = %v; want nil", err)
+	}
+	block, err := ssh.MarshalPrivateKey(private, "")
+	if err != nil {
+		t.Fatalf("ssh.MarshalPrivateKey() err = %v; want nil", err)
+	}
+	key, err := ParseSigningKey(pem.EncodeToMemory(block), "")
+	if err != nil || key.Format != SigSSH {
+		t.Fatalf("ParseSigningKey() = %v, %v; want an SSH key", key, err)
+	}
+	sig, err := key.Sign(message, "")
+	if err != nil {
+		t.Fatalf("Sign() err = %v; want nil", err)
+	}
+	allowed := pubKey + string(ssh.MarshalAuthorizedKey(key.signer.PublicKey()))
+	if _, err := VerifySignature(message, sig, []byte(allowed)); err != nil {
+		t.Errorf("VerifySignature() err = %v; want nil", err)
+	}
+	if _, err := VerifySignature(message, sig, []byte(pubKey)); err == nil {
+		t.Errorf("VerifySignature() with another key err = nil; want error")
+	}
+}
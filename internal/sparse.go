@@ -0,0 +1,259 @@
+package internal
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	blockSize       = 512     // Size of a tar block
+	sparseChunkSize = 1 << 16 // Granularity used to find zero runs when restoring
+)
+
+// PAX records describing a GNU 1.0 sparse entry.
+const (
+	paxGNUSparseMajor    = "GNU.sparse.major"
+	paxGNUSparseMinor    = "GNU.sparse.minor"
+	paxGNUSparseName     = "GNU.sparse.name"
+	paxGNUSparseRealSize = "GNU.sparse.realsize"
+)
+
+// sparseSegment is a run of data inside a file with holes.
+type sparseSegment struct {
+	Offset int64
+	Length int64
+}
+
+// isSparse reports whether the data segments cover less than the whole file.
+func isSparse(segments []sparseSegment, size int64) bool {
+	if segments == nil {
+		return false
+	}
+	var data int64
+	for _, s := range segments {
+		data += s.Length
+	}
+	return data < size
+}
+
+// isSparseHeader reports whether a tar entry was stored as a GNU sparse file.
+func isSparseHeader(header *tar.Header) bool {
+	_, ok := header.PAXRecords[paxGNUSparseMajor]
+	return ok || header.PAXRecords["GNU.sparse.map"] != "" || header.PAXRecords["GNU.sparse.offset"] != ""
+}
+
+// archiveSparseFile writes a file with holes as a PAX 1.0 sparse entry, storing only its data segments.
+// archive/tar reads this format but cannot write it, so the headers are encoded by hand
+// directly to the stream underneath the tar writer.
+func (a *archiver) archiveSparseFile(header *tar.Header, f *os.File, segments []sparseSegment, throttle *Throttle) error {
+	// Pad the previous entry so the raw blocks start on a block boundary
+	if err := a.tw.Flush(); err != nil {
+		return err
+	}
+
+	realSize := header.Size
+	if n := len(segments); n == 0 || segments[n-1].Offset+segments[n-1].Length < realSize {
+		// GNU tar expects a final empty segment when the file ends in a hole
+		segments = append(segments, sparseSegment{Offset: realSize})
+	}
+
+	// The sparse map is stored at the start of the entry data, padded to a full block
+	var sparseMap strings.Builder
+	var dataSize int64
+	fmt.Fprintf(&sparseMap, "%d\n", len(segments))
+	for _, s := range segments {
+		fmt.Fprintf(&sparseMap, "%d\n%d\n", s.Offset, s.Length)
+		dataSize += s.Length
+	}
+	mapBytes := padBlock([]byte(sparseMap.String()))
+
+	name := filepath.ToSlash(header.Name)
+	records := map[string]string{
+		paxGNUSparseMajor:    "1",
+		paxGNUSparseMinor:    "0",
+		paxGNUSparseName:     name,
+		paxGNUSparseRealSize: strconv.FormatInt(realSize, 10),
+	}
+
+	main := *header
+	main.Typeflag = tar.TypeReg
+	main.Name = truncateName(path.Join(path.Dir(name), "GNUSparseFile.0", path.Base(name)))
+	main.Size = int64(len(mapBytes)) + dataSize
+	moveToPAX(&main, records)
+
+	// Extended header carrying the sparse records
+	paxBody := formatPAXRecords(records)
+	paxHeader := &tar.Header{
+		Typeflag: tar.TypeXHeader,
+		Name:     truncateName(path.Join(path.Dir(name), "PaxHeaders.0", path.Base(name))),
+		Mode:     0644,
+		Size:     int64(len(paxBody)),
+		ModTime:  header.ModTime,
+	}
+
+	for _, block := range [][]byte{encodeUSTARBlock(paxHeader), padBlock(paxBody), encodeUSTARBlock(&main), mapBytes} {
+		if _, err := a.raw.Write(block); err != nil {
+			return err
+		}
+	}
+
+	// Copy every data segment in order, a file that shrinks is padded to keep the entry whole
+	src := throttle.reader(f)
+	var shrank error
+	for _, s := range segments {
+		if s.Length == 0 {
+			continue
+		}
+		if _, err := f.Seek(s.Offset, io.SeekStart); err != nil {
+			return err
+		}
+		if err := copyContents(a.raw, src, s.Length); err == errFileShrank {
+			shrank = err
+		} else if err != nil {
+			return err
+		}
+	}
+	if _, err := a.raw.Write(make([]byte, padding(dataSize))); err != nil {
+		return err
+	}
+	return shrank
+}
+
+// moveToPAX moves header fields that do not fit a ustar block into PAX records.
+func moveToPAX(h *tar.Header, records map[string]string) {
+	if h.Size > 077777777777 {
+		records["size"] = strconv.FormatInt(h.Size, 10)
+		h.Size = 0
+	}
+	if h.Uid > 07777777 {
+		records["uid"] = strconv.Itoa(h.Uid)
+		h.Uid = 0
+	}
+	if h.Gid > 07777777 {
+		records["gid"] = strconv.Itoa(h.Gid)
+		h.Gid = 0
+	}
+	if len(h.Uname) > 31 {
+		records["uname"] = h.Uname
+		h.Uname = ""
+	}
+	if len(h.Gname) > 31 {
+		records["gname"] = h.Gname
+		h.Gname = ""
+	}
+	if h.ModTime.Unix() < 0 || h.ModTime.Unix() > 077777777777 {
+		records["mtime"] = strconv.FormatInt(h.ModTime.Unix(), 10)
+	}
+}
+
+// encodeUSTARBlock encodes the basic fields of a header as a single ustar block.
+func encodeUSTARBlock(h *tar.Header) []byte {
+	block := make([]byte, blockSize)
+	copy(block[0:100], h.Name)
+	formatOctal(block[100:108], h.Mode&07777)
+	formatOctal(block[108:116], int64(h.Uid))
+	formatOctal(block[116:124], int64(h.Gid))
+	formatOctal(block[124:136], h.Size)
+	if mtime := h.ModTime.Unix(); mtime >= 0 && mtime <= 077777777777 {
+		formatOctal(block[136:148], mtime)
+	}
+	block[156] = h.Typeflag
+	copy(block[257:265], "ustar\x0000")
+	copy(block[265:297], h.Uname)
+	copy(block[297:329], h.Gname)
+
+	// The checksum is computed with the checksum field set to spaces
+	copy(block[148:156], "        ")
+	var sum int64
+	for _, b := range block {
+		sum += int64(b)
+	}
+	copy(block[148:156], fmt.Sprintf("%06o\x00 ", sum))
+	return block
+}
+
+// formatOctal writes a zero padded, NUL terminated octal number filling the field.
+func formatOctal(field []byte, v int64) {
+	copy(field, fmt.Sprintf("%0*o\x00", len(field)-1, v))
+}
+
+// formatPAXRecords encodes PAX records in sorted key order.
+func formatPAXRecords(records map[string]string) []byte {
+	keys := make([]string, 0, len(records))
+	for k := range records {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	for _, k := range keys {
+		// Each record is "<length> <key>=<value>\n", where length counts itself
+		size := len(k) + len(records[k]) + 3
+		size += len(strconv.Itoa(size))
+		record := fmt.Sprintf("%d %s=%s\n", size, k, records[k])
+		if len(record) != size {
+			record = fmt.Sprintf("%d %s=%s\n", len(record), k, records[k])
+		}
+		buf.WriteString(record)
+	}
+	return buf.Bytes()
+}
+
+// truncateName shortens a name to fit the 100 byte ustar name field.
+func truncateName(name string) string {
+	if len(name) > 99 {
+		return name[len(name)-99:]
+	}
+	return name
+}
+
+// padding returns the number of bytes needed to fill up the last block.
+func padding(n int64) int64 {
+	return -n & (blockSize - 1)
+}
+
+// padBlock pads data with zeros to a multiple of the block size.
+func padBlock(data []byte) []byte {
+	return append(data, make([]byte, padding(int64(len(data))))...)
+}
+
+// copySparse copies src to dst, seeking over runs of zeros instead of writing them
+// so the destination keeps its holes.
+func copySparse(dst *os.File, src io.Reader, size int64) error {
+	// Start from an empty file so skipped regions read back as zeros
+	if err := dst.Truncate(0); err != nil {
+		return err
+	}
+
+	buf := make([]byte, sparseChunkSize)
+	zeros := make([]byte, sparseChunkSize)
+	for {
+		n, err := io.ReadFull(src, buf)
+		if n > 0 {
+			if bytes.Equal(buf[:n], zeros[:n]) {
+				if _, serr := dst.Seek(int64(n), io.SeekCurrent); serr != nil {
+					return serr
+				}
+			} else if _, werr := dst.Write(buf[:n]); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// Extend the file over a trailing hole
+	return dst.Truncate(size)
+}
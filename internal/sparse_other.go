@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || freebsd)
+
+package internal
+
+import "os"
+
+// sparseSegments reports no segments, hole detection is not supported on this platform.
+func sparseSegments(f *os.File, size int64) ([]sparseSegment, error) {
+	return nil, nil
+}
@@ -0,0 +1,119 @@
+package internal
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// sparseTestSize is the apparent size of the sparse test input (4 GiB, so the
+// stored size exceeds what a naive copy could handle quickly)
+const sparseTestSize = 4 << 30
+
+// sparseTestData lists the data written into the sparse test input
+var sparseTestData = map[int64][]byte{
+	0:                     []byte("header at the start"),
+	1 << 30:               bytes.Repeat([]byte{0xAB}, 128*1024),
+	sparseTestSize - 4096: []byte("trailer near the end"),
+	3<<30 + 12345:         []byte("unaligned data"),
+}
+
+// createSparseFile creates a large file that is mostly holes, skipping the test
+// if the filesystem does not report them
+func createSparseFile(t *testing.T, path string) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create sparse file: %v", err)
+	}
+	defer f.Close()
+
+	if err := f.Truncate(sparseTestSize); err != nil {
+		t.Fatalf("Failed to extend sparse file: %v", err)
+	}
+	for off, data := range sparseTestData {
+		if _, err := f.WriteAt(data, off); err != nil {
+			t.Fatalf("Failed to write sparse file: %v", err)
+		}
+	}
+
+	segments, err := sparseSegments(f, sparseTestSize)
+	if err != nil {
+		t.Fatalf("sparseSegments() err = %v; want nil", err)
+	}
+	if !isSparse(segments, sparseTestSize) {
+		t.Skip("filesystem does not report holes")
+	}
+}
+
+// TestBackupSparseFile checks that holes are neither stored in the archive nor filled on restore
+func TestBackupSparseFile(t *testing.T) {
+	srcDir := t.TempDir()
+	createSparseFile(t, filepath.Join(srcDir, "disk.img"))
+	if err := os.WriteFile(filepath.Join(srcDir, "after.txt"), []byte("next entry"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	archive := filepath.Join(t.TempDir(), "backup.tar.zst")
+	if _, err := Backup(srcDir, archive, 1, false, "", nil, ChangeFail, ""); err != nil {
+		t.Fatalf("Backup() err = %v; want nil", err)
+	}
+
+	info, err := os.Stat(archive)
+	if err != nil {
+		t.Fatalf("Failed to stat archive: %v", err)
+	}
+	if info.Size() > 1<<20 {
+		t.Errorf("archive size = %d bytes; want holes to be skipped", info.Size())
+	}
+
+	// The index reads the sparse entry back at its apparent size
+	idx, err := IndexArchive(archive, "")
+	if err != nil {
+		t.Fatalf("IndexArchive() err = %v; want nil", err)
+	}
+	defer idx.Close()
+	e, ok := idx.Lookup("disk.img")
+	if !ok || e.Header.Size != sparseTestSize {
+		t.Fatalf("Lookup(disk.img) = %v; want entry of size %d", e, int64(sparseTestSize))
+	}
+	if e, ok := idx.Lookup("after.txt"); !ok {
+		t.Error("Lookup(after.txt) not found after sparse entry")
+	} else if got, _ := io.ReadAll(idx.Open(e)); string(got) != "next entry" {
+		t.Errorf("after.txt = %q; want %q", got, "next entry")
+	}
+
+	destDir := t.TempDir()
+	if err := Restore(archive, destDir, ""); err != nil {
+		t.Fatalf("Restore() err = %v; want nil", err)
+	}
+
+	restored, err := os.Open(filepath.Join(destDir, "disk.img"))
+	if err != nil {
+		t.Fatalf("Failed to open restored file: %v", err)
+	}
+	defer restored.Close()
+
+	rinfo, err := restored.Stat()
+	if err != nil {
+		t.Fatalf("Failed to stat restored file: %v", err)
+	}
+	if rinfo.Size() != sparseTestSize {
+		t.Errorf("restored size = %d; want %d", rinfo.Size(), int64(sparseTestSize))
+	}
+	if st, ok := rinfo.Sys().(*syscall.Stat_t); ok && st.Blocks*512 > 16<<20 {
+		t.Errorf("restored file allocates %d bytes; want holes to be kept", st.Blocks*512)
+	}
+
+	for off, data := range sparseTestData {
+		got := make([]byte, len(data))
+		if _, err := restored.ReadAt(got, off); err != nil {
+			t.Fatalf("ReadAt(%d) err = %v; want nil", off, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("restored data at %d does not match", off)
+		}
+	}
+}
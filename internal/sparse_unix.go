@@ -0,0 +1,44 @@
+//go:build linux || darwin || freebsd
+
+package internal
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// sparseSegments finds the data segments of a file using SEEK_DATA/SEEK_HOLE.
+// It returns nil if the filesystem cannot report holes.
+func sparseSegments(f *os.File, size int64) ([]sparseSegment, error) {
+	segments := []sparseSegment{}
+	for off := int64(0); off < size; {
+		data, err := f.Seek(off, unix.SEEK_DATA)
+		if errors.Is(err, unix.ENXIO) {
+			break // Only a hole remains
+		}
+		if err != nil {
+			return nil, rewind(f)
+		}
+		hole, err := f.Seek(data, unix.SEEK_HOLE)
+		if err != nil {
+			return nil, rewind(f)
+		}
+		if hole > size {
+			hole = size // The file grew while we were looking
+		}
+		if hole > data {
+			segments = append(segments, sparseSegment{Offset: data, Length: hole - data})
+		}
+		off = hole
+	}
+	return segments, rewind(f)
+}
+
+// rewind moves the file offset back to the start.
+func rewind(f *os.File) error {
+	_, err := f.Seek(0, io.SeekStart)
+	return err
+}
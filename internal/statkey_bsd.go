@@ -0,0 +1,23 @@
+//go:build darwin || freebsd
+
+package internal
+
+import (
+	"os"
+	"syscall"
+)
+
+// statKey identifies the state of a file from its metadata, see hashCacheKey
+func statKey(info os.FileInfo) (hashCacheKey, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return hashCacheKey{}, false
+	}
+	return hashCacheKey{
+		Dev:   uint64(st.Dev),
+		Ino:   uint64(st.Ino),
+		Size:  info.Size(),
+		MTime: info.ModTime().UnixNano(),
+		CTime: st.Ctimespec.Nano(),
+	}, true
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd
+
+package internal
+
+import "os"
+
+// statKey reports that files cannot be identified reliably, so nothing is cached
+func statKey(info os.FileInfo) (hashCacheKey, bool) {
+	return hashCacheKey{}, false
+}
@@ -0,0 +1,497 @@
+package internal
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Constants of zxcvbn's guess estimates
+const (
+	bruteforceCardinality  = 10    // Guesses per character not explained by a pattern
+	minSubmatchGuessesChar = 10    // Least guesses for a single character within a password
+	minSubmatchGuessesWord = 50    // Least guesses for longer parts within a password
+	minGuessesBeforeGrow   = 10000 // Cost of every further part of a password
+	minYearSpace           = 20    // Years guessed around the reference year at least
+)
+
+// maxStrengthLength is the number of characters analysed. Longer passwords are at least as
+// strong as their beginning.
+const maxStrengthLength = 100
+
+// CrackTime is the time needed to guess a password in one attack scenario
+type CrackTime struct {
+	Scenario         string
+	GuessesPerSecond float64
+	Seconds          float64
+}
+
+// crackScenarios are zxcvbn's attack scenarios
+var crackScenarios = []struct {
+	name  string
+	speed float64
+}{
+	{"online, throttled (100/hour)", 100.0 / 3600},
+	{"online, unthrottled (10/second)", 10},
+	{"offline, slow hash (10k/second)", 1e4},
+	{"offline, fast hash (10B/second)", 1e10},
+}
+
+// StrengthResult is the estimated strength of a password
+type StrengthResult struct {
+	Guesses     float64
+	Score       int             // 0 (too guessable) to 4 (very unguessable)
+	Sequence    []StrengthMatch // The parts of the password, together the easiest way to guess it
+	CrackTimes  []CrackTime
+	Warning     string
+	Suggestions []string
+}
+
+// scoreNames describes each score
+var scoreNames = [...]string{"too guessable", "very guessable", "somewhat guessable", "safely unguessable", "very unguessable"}
+
+// ScoreName describes the score in words
+func (r StrengthResult) ScoreName() string {
+	return scoreNames[r.Score]
+}
+
+// Entropy returns the estimated entropy in bits, the base 2 logarithm of the guesses
+func (r StrengthResult) Entropy() float64 {
+	return math.Log2(r.Guesses)
+}
+
+// EstimateStrength estimates how many guesses an attacker needs to find the password, the
+// way zxcvbn does: the password is split into dictionary words (also reversed, capitalized
+// or with l33t substitutions), keyboard patterns, repeats, sequences, years and dates,
+// and characters left over are guessed one by one. The split needing the fewest guesses
+// is the estimate.
+func EstimateStrength(password string) StrengthResult {
+	runes := []rune(password)
+	if len(runes) > maxStrengthLength {
+		runes = runes[:maxStrengthLength]
+	}
+	r := estimateStrength(runes)
+
+	for _, s := range crackScenarios {
+		r.CrackTimes = append(r.CrackTimes, CrackTime{Scenario: s.name, GuessesPerSecond: s.speed, Seconds: r.Guesses / s.speed})
+	}
+	switch delta := 5.0; {
+	case r.Guesses < 1e3+delta:
+		r.Score = 0
+	case r.Guesses < 1e6+delta:
+		r.Score = 1
+	case r.Guesses < 1e8+delta:
+		r.Score = 2
+	case r.Guesses < 1e10+delta:
+		r.Score = 3
+	default:
+		r.Score = 4
+	}
+	r.Warning, r.Suggestions = strengthFeedback(r.Score, r.Sequence)
+	return r
+}
+
+// estimateStrength finds the sequence of matches needing the fewest guesses. For every end
+// position k and number of parts l it keeps the best sequence, whose guesses are
+// l! * (product of the parts' guesses) + minGuessesBeforeGrow^(l-1): the factorial as the
+// parts may come in any order, the additive term to favour fewer parts.
+func estimateStrength(password []rune) StrengthResult {
+	n := len(password)
+	if n == 0 {
+		return StrengthResult{Guesses: 1}
+	}
+
+	byEnd := make([][]StrengthMatch, n)
+	for _, m := range findMatches(password) {
+		byEnd[m.J] = append(byEnd[m.J], m)
+	}
+
+	type step struct {
+		match   StrengthMatch
+		product float64 // Product of the guesses of the parts
+		guesses float64
+	}
+	best := make([]map[int]step, n) // best[k][l]: best sequence of l parts ending at k
+
+	update := func(m StrengthMatch, l int) {
+		k := m.J
+		m.Guesses = matchGuesses(m, n)
+		product := m.Guesses
+		if l > 1 {
+			product *= best[m.I-1][l-1].product
+		}
+		guesses := factorial(l)*product + math.Pow(minGuessesBeforeGrow, float64(l-1))
+		// A sequence with fewer parts and fewer guesses is always better
+		for otherL, other := range best[k] {
+			if otherL <= l && other.guesses <= guesses {
+				return
+			}
+		}
+		best[k][l] = step{m, product, guesses}
+	}
+	bruteforce := func(i, k int) StrengthMatch {
+		return StrengthMatch{Pattern: PatternBruteforce, Token: string(password[i : k+1]), I: i, J: k}
+	}
+
+	for k := 0; k < n; k++ {
+		best[k] = make(map[int]step)
+		for _, m := range byEnd[k] {
+			if m.I == 0 {
+				update(m, 1)
+				continue
+			}
+			for l := range best[m.I-1] {
+				update(m, l+1)
+			}
+		}
+
+		// Characters guessed one by one, never right after other such characters
+		update(bruteforce(0, k), 1)
+		for i := 1; i <= k; i++ {
+			for l, last := range best[i-1] {
+				if last.match.Pattern != PatternBruteforce {
+					update(bruteforce(i, k), l+1)
+				}
+			}
+		}
+	}
+
+	// Unwind the best sequence ending at the last character
+	l, guesses := 0, math.Inf(1)
+	for candidate, s := range best[n-1] {
+		if s.guesses < guesses || (s.guesses == guesses && candidate < l) {
+			l, guesses = candidate, s.guesses
+		}
+	}
+	sequence := make([]StrengthMatch, l)
+	for k := n - 1; k >= 0; l-- {
+		m := best[k][l].match
+		sequence[l-1] = m
+		k = m.I - 1
+	}
+	return StrengthResult{Guesses: guesses, Sequence: sequence}
+}
+
+// matchGuesses estimates the guesses needed for a match within a password of n characters
+func matchGuesses(m StrengthMatch, n int) float64 {
+	length := m.J - m.I + 1
+	var guesses float64
+	switch m.Pattern {
+	case PatternBruteforce:
+		guesses = math.Pow(bruteforceCardinality, float64(length))
+		if math.IsInf(guesses, 1) {
+			guesses = math.MaxFloat64
+		}
+		// Never less than a single character or word would need
+		if length == 1 {
+			guesses = max(guesses, minSubmatchGuessesChar+1)
+		} else {
+			guesses = max(guesses, minSubmatchGuessesWord+1)
+		}
+	case PatternDictionary:
+		guesses = float64(m.Rank) * uppercaseVariations(m.Token) * l33tVariations(m)
+		if m.Reversed {
+			guesses *= 2
+		}
+	case PatternSpatial:
+		guesses = spatialGuesses(m)
+	case PatternRepeat:
+		guesses = m.BaseGuesses * float64(m.Repeats)
+	case PatternSequence:
+		first := []rune(m.Token)[0]
+		switch {
+		case strings.ContainsRune("aAzZ019", first):
+			guesses = 4 // Obvious starting points
+		case unicode.IsDigit(first):
+			guesses = 10
+		default:
+			guesses = 26
+		}
+		if !m.Ascending {
+			guesses *= 2
+		}
+		guesses *= float64(length)
+	case PatternYear:
+		guesses = float64(max(abs(m.Year-referenceYear), minYearSpace))
+	case PatternDate:
+		guesses = float64(max(abs(m.Year-referenceYear), minYearSpace)) * 365
+		if m.Separator != "" {
+			guesses *= 4
+		}
+	}
+
+	if length < n && m.Pattern != PatternBruteforce {
+		if length == 1 {
+			guesses = max(guesses, minSubmatchGuessesChar)
+		} else {
+			guesses = max(guesses, minSubmatchGuessesWord)
+		}
+	}
+	return max(guesses, 1)
+}
+
+// uppercaseVariations returns the ways the word's letters could have been capitalized.
+// Capitalizing the first or last letter or all of them counts as two guesses.
+func uppercaseVariations(token string) float64 {
+	upper, lower := 0, 0
+	for _, r := range token {
+		if unicode.IsUpper(r) {
+			upper++
+		} else if unicode.IsLower(r) {
+			lower++
+		}
+	}
+	if upper == 0 {
+		return 1
+	}
+	runes := []rune(token)
+	firstOnly := unicode.IsUpper(runes[0]) && upper == 1
+	lastOnly := unicode.IsUpper(runes[len(runes)-1]) && upper == 1
+	if firstOnly || lastOnly || lower == 0 {
+		return 2
+	}
+	variations := 0.0
+	for i := 1; i <= min(upper, lower); i++ {
+		variations += binomial(upper+lower, i)
+	}
+	return variations
+}
+
+// l33tVariations returns the ways the l33t substitutions could have been made
+func l33tVariations(m StrengthMatch) float64 {
+	if len(m.L33t) == 0 {
+		return 1
+	}
+	variations := 1.0
+	token := []rune(strings.ToLower(m.Token))
+	for sub, letter := range m.L33t {
+		subbed, unsubbed := 0, 0
+		for _, r := range token {
+			if r == sub {
+				subbed++
+			} else if r == letter {
+				unsubbed++
+			}
+		}
+		if subbed == 0 || unsubbed == 0 {
+			variations *= 2 // All or none of the letters substituted
+			continue
+		}
+		possible := 0.0
+		for i := 1; i <= min(subbed, unsubbed); i++ {
+			possible += binomial(subbed+unsubbed, i)
+		}
+		variations *= possible
+	}
+	return variations
+}
+
+// spatialGuesses counts keyboard patterns up to the token's length and number of turns
+// starting on any key, and the ways shift could have been held
+func spatialGuesses(m StrengthMatch) float64 {
+	var g keyboardGraph
+	for _, graph := range keyboardGraphs() {
+		if graph.name == m.Graph {
+			g = graph
+		}
+	}
+	length := len([]rune(m.Token))
+	guesses := 0.0
+	for i := 2; i <= length; i++ {
+		for j := 1; j <= min(m.Turns, i-1); j++ {
+			guesses += binomial(i-1, j-1) * float64(g.keys) * math.Pow(g.degree, float64(j))
+		}
+	}
+	if m.Shifted > 0 {
+		unshifted := length - m.Shifted
+		if unshifted == 0 {
+			guesses *= 2
+		} else {
+			variations := 0.0
+			for i := 1; i <= min(m.Shifted, unshifted); i++ {
+				variations += binomial(length, i)
+			}
+			guesses *= variations
+		}
+	}
+	return guesses
+}
+
+// binomial returns n choose k
+func binomial(n, k int) float64 {
+	if k > n {
+		return 0
+	}
+	r := 1.0
+	for d := 1; d <= k; d++ {
+		r = r * float64(n-k+d) / float64(d)
+	}
+	return r
+}
+
+// factorial returns n!
+func factorial(n int) float64 {
+	r := 1.0
+	for i := 2; i <= n; i++ {
+		r *= float64(i)
+	}
+	return r
+}
+
+// strengthFeedback explains the weakest part of a guessable password and how to do better
+func strengthFeedback(score int, sequence []StrengthMatch) (string, []string) {
+	if len(sequence) == 0 {
+		return "", []string{"Use a few words, avoid common phrases", "No need for symbols, digits, or uppercase letters"}
+	}
+	if score > 2 {
+		return "", nil
+	}
+
+	longest := sequence[0]
+	for _, m := range sequence[1:] {
+		if len([]rune(m.Token)) > len([]rune(longest.Token)) {
+			longest = m
+		}
+	}
+	warning, suggestions := matchFeedback(longest, len(sequence) == 1)
+	return warning, append([]string{"Add another word or two. Uncommon words are better."}, suggestions...)
+}
+
+// matchFeedback returns the warning and suggestions for one match
+func matchFeedback(m StrengthMatch, sole bool) (string, []string) {
+	switch m.Pattern {
+	case PatternDictionary:
+		return dictionaryFeedback(m, sole)
+	case PatternSpatial:
+		warning := "Short keyboard patterns are easy to guess"
+		if m.Turns == 1 {
+			warning = "Straight rows of keys are easy to guess"
+		}
+		return warning, []string{"Use a longer keyboard pattern with more turns"}
+	case PatternRepeat:
+		warning := `Repeats like "abcabcabc" are only slightly harder to guess than "abc"`
+		if len([]rune(m.BaseToken)) == 1 {
+			warning = `Repeats like "aaa" are easy to guess`
+		}
+		return warning, []string{"Avoid repeated words and characters"}
+	case PatternSequence:
+		return "Sequences like abc or 6543 are easy to guess", []string{"Avoid sequences"}
+	case PatternYear:
+		return "Recent years are easy to guess", []string{"Avoid recent years", "Avoid years that are associated with you"}
+	case PatternDate:
+		return "Dates are often easy to guess", []string{"Avoid dates and years that are associated with you"}
+	}
+	return "", nil
+}
+
+// dictionaryFeedback explains why a dictionary word is weak
+func dictionaryFeedback(m StrengthMatch, sole bool) (string, []string) {
+	var warning string
+	switch m.Dictionary {
+	case "passwords":
+		switch {
+		case sole && len(m.L33t) == 0 && !m.Reversed && m.Rank <= 10:
+			warning = "This is a top-10 common password"
+		case sole && len(m.L33t) == 0 && !m.Reversed && m.Rank <= 100:
+			warning = "This is a top-100 common password"
+		case sole && len(m.L33t) == 0 && !m.Reversed:
+			warning = "This is a very common password"
+		case math.Log10(m.Guesses) <= 4:
+			warning = "This is similar to a commonly used password"
+		}
+	case "english", "diceware":
+		if sole {
+			warning = "A word by itself is easy to guess"
+		}
+	case "surnames", "male_names", "female_names":
+		warning = "Common names and surnames are easy to guess"
+		if sole {
+			warning = "Names and surnames by themselves are easy to guess"
+		}
+	}
+
+	var suggestions []string
+	runes := []rune(m.Token)
+	switch {
+	case unicode.IsUpper(runes[0]) && strings.ToLower(m.Token) != m.Token:
+		suggestions = append(suggestions, "Capitalization doesn't help very much")
+	case strings.ToUpper(m.Token) == m.Token && strings.ToLower(m.Token) != m.Token:
+		suggestions = append(suggestions, "All-uppercase is almost as easy to guess as all-lowercase")
+	}
+	if m.Reversed && len(runes) >= 4 {
+		suggestions = append(suggestions, "Reversed words aren't much harder to guess")
+	}
+	if len(m.L33t) > 0 {
+		suggestions = append(suggestions, "Predictable substitutions like '@' instead of 'a' don't help very much")
+	}
+	return warning, suggestions
+}
+
+// FormatCrackTime describes a number of seconds in the largest fitting unit
+func FormatCrackTime(seconds float64) string {
+	const (
+		minute  = 60.0
+		hour    = 60 * minute
+		day     = 24 * hour
+		month   = 31 * day
+		year    = 12 * month
+		century = 100 * year
+	)
+	units := []struct {
+		name string
+		size float64
+	}{{"year", year}, {"month", month}, {"day", day}, {"hour", hour}, {"minute", minute}, {"second", 1}}
+
+	switch {
+	case seconds < 1:
+		return "less than a second"
+	case seconds >= century:
+		return "centuries"
+	}
+	for _, u := range units {
+		if seconds >= u.size {
+			n := math.Round(seconds / u.size)
+			if n == 1 {
+				return fmt.Sprintf("1 %s", u.name)
+			}
+			return fmt.Sprintf("%.0f %ss", n, u.name)
+		}
+	}
+	return "less than a second"
+}
+
+// Describe explains the match in a few words
+func (m StrengthMatch) Describe() string {
+	switch m.Pattern {
+	case PatternDictionary:
+		s := fmt.Sprintf("%s word %q, rank %d", m.Dictionary, m.Word, m.Rank)
+		if m.Reversed {
+			s += ", reversed"
+		}
+		if len(m.L33t) > 0 {
+			var subs []string
+			for sub, letter := range m.L33t {
+				subs = append(subs, fmt.Sprintf("%c->%c", sub, letter))
+			}
+			sort.Strings(subs)
+			s += ", l33t " + strings.Join(subs, " ")
+		}
+		return s
+	case PatternSpatial:
+		return fmt.Sprintf("%s keyboard pattern, %d turns, %d shifted", m.Graph, m.Turns, m.Shifted)
+	case PatternRepeat:
+		return fmt.Sprintf("%q repeated %d times", m.BaseToken, m.Repeats)
+	case PatternSequence:
+		if m.Ascending {
+			return "ascending sequence"
+		}
+		return "descending sequence"
+	case PatternYear:
+		return fmt.Sprintf("year %d", m.Year)
+	case PatternDate:
+		return fmt.Sprintf("date %04d-%02d-%02d", m.Year, m.Month, m.Day)
+	}
+	return "characters guessed one by one"
+}
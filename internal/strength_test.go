@@ -0,0 +1,119 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestStrengthMatches checks that each kind of pattern is recognised
+func TestStrengthMatches(t *testing.T) {
+	saved := referenceYear
+	referenceYear = 2024
+	defer func() { referenceYear = saved }()
+
+	tests := []struct {
+		password string
+		pattern  string
+		token    string
+		check    func(StrengthMatch) bool
+	}{
+		{"password", PatternDictionary, "password", func(m StrengthMatch) bool { return m.Dictionary == "passwords" && m.Rank == 1 }},
+		{"drowssap", PatternDictionary, "drowssap", func(m StrengthMatch) bool { return m.Reversed && m.Word == "password" }},
+		{"p@ssw0rd", PatternDictionary, "p@ssw0rd", func(m StrengthMatch) bool { return m.L33t['@'] == 'a' && m.L33t['0'] == 'o' }},
+		{"zxcvbnm", PatternSpatial, "zxcvbnm", func(m StrengthMatch) bool { return m.Graph == "qwerty" && m.Turns == 1 }},
+		{"7895123", PatternSpatial, "7895123", func(m StrengthMatch) bool { return m.Graph == "keypad" }},
+		{"aaaaaa", PatternRepeat, "aaaaaa", func(m StrengthMatch) bool { return m.BaseToken == "a" && m.Repeats == 6 }},
+		{"xyzxyzxyz", PatternRepeat, "xyzxyzxyz", func(m StrengthMatch) bool { return m.BaseToken == "xyz" && m.Repeats == 3 }},
+		{"jklmnop", PatternSequence, "jklmnop", func(m StrengthMatch) bool { return m.Ascending }},
+		{"97531", PatternSequence, "97531", func(m StrengthMatch) bool { return !m.Ascending }},
+		{"1987", PatternYear, "1987", func(m StrengthMatch) bool { return m.Year == 1987 }},
+		{"13/05/1987", PatternDate, "13/05/1987", func(m StrengthMatch) bool {
+			return m.Year == 1987 && m.Month == 5 && m.Day == 13 && m.Separator == "/"
+		}},
+		{"130587", PatternDate, "130587", func(m StrengthMatch) bool { return m.Year == 1987 && m.Separator == "" }},
+	}
+	for _, tt := range tests {
+		found := false
+		for _, m := range findMatches([]rune(tt.password)) {
+			if m.Pattern == tt.pattern && m.Token == tt.token && tt.check(m) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("findMatches(%q) has no %s match of %q: %+v", tt.password, tt.pattern, tt.token, findMatches([]rune(tt.password)))
+		}
+	}
+}
+
+// TestEstimateStrength checks guesses, scores and feedback of well known passwords
+func TestEstimateStrength(t *testing.T) {
+	tests := []struct {
+		password string
+		score    int
+		warning  string
+	}{
+		{"password", 0, "This is a top-10 common password"},
+		{"P@ssw0rd", 0, "This is similar to a commonly used password"},
+		{"aaaaaaaaaa", 0, `Repeats like "aaa" are easy to guess`},
+		{"qazwsxedc", 1, ""},
+		{"correcthorsebatterystaple", 4, ""},
+		{"kL4!Jq$w8k3I0RsLO", 4, ""},
+	}
+	for _, tt := range tests {
+		r := EstimateStrength(tt.password)
+		if r.Score != tt.score {
+			t.Errorf("EstimateStrength(%q) score = %d; want %d", tt.password, r.Score, tt.score)
+		}
+		if tt.warning != "" && r.Warning != tt.warning {
+			t.Errorf("EstimateStrength(%q) warning = %q; want %q", tt.password, r.Warning, tt.warning)
+		}
+		if tt.score <= 2 && len(r.Suggestions) == 0 {
+			t.Errorf("EstimateStrength(%q) has no suggestions", tt.password)
+		}
+
+		// The parts of the password cover it from start to end
+		var tokens []string
+		for _, m := range r.Sequence {
+			tokens = append(tokens, m.Token)
+		}
+		if joined := strings.Join(tokens, ""); joined != tt.password {
+			t.Errorf("EstimateStrength(%q) sequence is %q; want the whole password", tt.password, tokens)
+		}
+	}
+
+	// Capitalization and l33t take a few more guesses, never fewer
+	plain, capital, leet := EstimateStrength("monkey"), EstimateStrength("Monkey"), EstimateStrength("m0nkey")
+	if !(plain.Guesses < capital.Guesses && plain.Guesses < leet.Guesses) {
+		t.Errorf("EstimateStrength() guesses monkey %v, Monkey %v, m0nkey %v; want monkey the lowest",
+			plain.Guesses, capital.Guesses, leet.Guesses)
+	}
+
+	// Only the beginning of very long passwords is analysed
+	if r := EstimateStrength(strings.Repeat("x7#Kq", 1000)); r.Guesses <= 0 || len(r.Sequence) == 0 {
+		t.Errorf("EstimateStrength() of a long password = %+v; want an estimate", r)
+	}
+	if r := EstimateStrength(""); r.Guesses != 1 || r.Score != 0 {
+		t.Errorf("EstimateStrength(\"\") = %+v; want 1 guess", r)
+	}
+}
+
+// TestFormatCrackTime checks the units crack times are described in
+func TestFormatCrackTime(t *testing.T) {
+	tests := []struct {
+		seconds float64
+		want    string
+	}{
+		{0.2, "less than a second"},
+		{1, "1 second"},
+		{90, "2 minutes"},
+		{3 * 3600, "3 hours"},
+		{40 * 86400, "1 month"},
+		{5 * 12 * 31 * 86400, "5 years"},
+		{1e12, "centuries"},
+	}
+	for _, tt := range tests {
+		if got := FormatCrackTime(tt.seconds); got != tt.want {
+			t.Errorf("FormatCrackTime(%v) = %q; want %q", tt.seconds, got, tt.want)
+		}
+	}
+}
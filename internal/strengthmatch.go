@@ -0,0 +1,687 @@
+package internal
+
+import (
+	_ "embed"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Patterns the strength estimator explains passwords with
+const (
+	PatternDictionary = "dictionary"
+	PatternSpatial    = "spatial"
+	PatternRepeat     = "repeat"
+	PatternSequence   = "sequence"
+	PatternYear       = "year"
+	PatternDate       = "date"
+	PatternBruteforce = "bruteforce"
+)
+
+// StrengthMatch is a part of a password explained by one pattern. Which of the pattern
+// specific fields are set depends on the pattern.
+type StrengthMatch struct {
+	Pattern string
+	Token   string
+	I, J    int     // Indices of the first and last character of the token
+	Guesses float64 // Guesses needed to find the token with this pattern
+
+	Dictionary string        // dictionary: the dictionary holding the word
+	Word       string        // dictionary: the word matched, lower case and without l33t
+	Rank       int           // dictionary: the word's rank by frequency, starting at 1
+	Reversed   bool          // dictionary: the token is the word backwards
+	L33t       map[rune]rune // dictionary: substituted characters and the letters they stand for
+
+	Graph   string // spatial: the keyboard layout
+	Turns   int    // spatial: changes of direction
+	Shifted int    // spatial: characters typed with shift
+
+	BaseToken   string  // repeat: the repeated part
+	BaseGuesses float64 // repeat: guesses needed for the repeated part
+	Repeats     int     // repeat: how often the part is repeated
+
+	Ascending bool // sequence: whether the characters go up
+
+	Year, Month, Day int    // year and date: the date, only Year for years
+	Separator        string // date: the character between the date parts
+}
+
+// Frequency lists of zxcvbn (https://github.com/dropbox/zxcvbn, MIT license), most common first:
+// leaked passwords, English words from TV and film subtitles, US first names and surnames
+var (
+	//go:embed wordlists/passwords.txt
+	passwordsList string
+	//go:embed wordlists/english.txt
+	englishList string
+	//go:embed wordlists/female_names.txt
+	femaleNamesList string
+	//go:embed wordlists/male_names.txt
+	maleNamesList string
+	//go:embed wordlists/surnames.txt
+	surnamesList string
+)
+
+// rankedDictionary maps words to their rank in a dictionary
+type rankedDictionary struct {
+	name  string
+	ranks map[string]int
+}
+
+// strengthDictionaries returns the dictionaries words are looked up in
+var strengthDictionaries = sync.OnceValue(func() []rankedDictionary {
+	ranked := func(name, list string) rankedDictionary {
+		d := rankedDictionary{name: name, ranks: make(map[string]int)}
+		for i, word := range strings.Split(strings.TrimSpace(list), "\n") {
+			if _, ok := d.ranks[word]; !ok {
+				d.ranks[word] = i + 1
+			}
+		}
+		return d
+	}
+	// Words of generated diceware passphrases are equally likely, so every one of them
+	// takes as many guesses as there are words
+	diceware := rankedDictionary{name: "diceware", ranks: make(map[string]int)}
+	for _, word := range dicewareWords() {
+		diceware.ranks[word] = len(dicewareWords())
+	}
+	return []rankedDictionary{
+		ranked("passwords", passwordsList),
+		ranked("english", englishList),
+		ranked("female_names", femaleNamesList),
+		ranked("male_names", maleNamesList),
+		ranked("surnames", surnamesList),
+		diceware,
+	}
+})
+
+// l33tTable lists the characters commonly substituted for each letter
+var l33tTable = map[rune]string{
+	'a': "4@",
+	'b': "8",
+	'c': "({[<",
+	'e': "3",
+	'g': "69",
+	'i': "1!|",
+	'l': "1|7",
+	'o': "0",
+	's': "$5",
+	't': "+7",
+	'x': "%",
+	'z': "2",
+}
+
+// maxL33tSubs bounds the substitution combinations tried for one password
+const maxL33tSubs = 256
+
+// Keyboard layouts as in zxcvbn: each key lists its unshifted and shifted character. Rows of
+// slanted keyboards are offset by half a key, so keys have six neighbours; keypads have eight.
+const (
+	qwertyLayout = "" +
+		"`~ 1! 2@ 3# 4$ 5% 6^ 7& 8* 9( 0) -_ =+\n" +
+		"    qQ wW eE rR tT yY uU iI oO pP [{ ]} \\|\n" +
+		"     aA sS dD fF gG hH jJ kK lL ;: '\"\n" +
+		"      zZ xX cC vV bB nN mM ,< .> /?"
+	keypadLayout = "" +
+		"  / * -\n" +
+		"7 8 9 +\n" +
+		"4 5 6\n" +
+		"1 2 3\n" +
+		"  0 ."
+)
+
+// keyboardGraph holds the neighbouring keys of every character in a fixed direction order
+type keyboardGraph struct {
+	name      string
+	neighbors map[rune][]string // "" where there is no neighbour
+	keys      int               // Starting positions
+	degree    float64           // Average number of neighbours
+	shifted   map[rune]bool
+}
+
+// buildKeyboardGraph positions each key of the layout and links it to its neighbours
+func buildKeyboardGraph(name, layout string, slanted bool) keyboardGraph {
+	type pos struct{ x, y int }
+	positions := make(map[pos]string)
+	unit := 0
+	for y, line := range strings.Split(layout, "\n") {
+		slant := 0
+		if slanted {
+			slant = y
+		}
+		for _, token := range strings.Fields(line) {
+			if unit == 0 {
+				unit = len(token) + 1
+			}
+			positions[pos{(strings.Index(line, token) - slant) / unit, y}] = token
+		}
+	}
+
+	g := keyboardGraph{name: name, neighbors: make(map[rune][]string), shifted: make(map[rune]bool)}
+	offsets := []pos{{-1, 0}, {-1, -1}, {0, -1}, {1, -1}, {1, 0}, {1, 1}, {0, 1}, {-1, 1}}
+	if slanted {
+		offsets = []pos{{-1, 0}, {0, -1}, {1, -1}, {1, 0}, {0, 1}, {-1, 1}}
+	}
+	neighbors := 0
+	for p, token := range positions {
+		adjacent := make([]string, len(offsets))
+		for i, o := range offsets {
+			adjacent[i] = positions[pos{p.x + o.x, p.y + o.y}]
+			if adjacent[i] != "" {
+				neighbors += len([]rune(token))
+			}
+		}
+		for i, r := range token {
+			g.neighbors[r] = adjacent
+			g.shifted[r] = i == 1
+		}
+	}
+	g.keys = len(g.neighbors)
+	g.degree = float64(neighbors) / float64(g.keys)
+	return g
+}
+
+var keyboardGraphs = sync.OnceValue(func() []keyboardGraph {
+	return []keyboardGraph{
+		buildKeyboardGraph("qwerty", qwertyLayout, true),
+		buildKeyboardGraph("keypad", keypadLayout, false),
+	}
+})
+
+// referenceYear is the year dates and years are compared with, replaced by tests
+var referenceYear = time.Now().Year()
+
+// Years outside this range are not taken as dates
+const (
+	dateMinYear = 1000
+	dateMaxYear = 2050
+)
+
+// dateSplits lists where digit runs of each length are split into day, month and year
+var dateSplits = map[int][][2]int{
+	4: {{1, 2}, {2, 3}},         // 1 1 91, 11 1 1
+	5: {{1, 3}, {2, 3}},         // 1 11 91, 11 1 91
+	6: {{1, 2}, {2, 4}, {4, 5}}, // 1 1 1991, 11 11 91, 1991 1 1
+	7: {{1, 3}, {2, 3}, {4, 5}, {4, 6}},
+	8: {{2, 4}, {4, 6}}, // 11 11 1991, 1991 11 11
+}
+
+// dateSeparators are the characters allowed between the parts of a date
+const dateSeparators = " /\\_.-"
+
+// findMatches returns every pattern match found in the password, sorted by position
+func findMatches(password []rune) []StrengthMatch {
+	var matches []StrengthMatch
+	matches = append(matches, dictionaryMatches(password)...)
+	matches = append(matches, reversedDictionaryMatches(password)...)
+	matches = append(matches, l33tMatches(password)...)
+	matches = append(matches, spatialMatches(password)...)
+	matches = append(matches, repeatMatches(password)...)
+	matches = append(matches, sequenceMatches(password)...)
+	matches = append(matches, yearMatches(password)...)
+	matches = append(matches, dateMatches(password)...)
+	sort.SliceStable(matches, func(a, b int) bool {
+		if matches[a].I != matches[b].I {
+			return matches[a].I < matches[b].I
+		}
+		return matches[a].J < matches[b].J
+	})
+	return matches
+}
+
+// lowerRunes returns the password in lower case, keeping one rune per character
+func lowerRunes(password []rune) []rune {
+	lower := make([]rune, len(password))
+	for i, r := range password {
+		lower[i] = unicode.ToLower(r)
+	}
+	return lower
+}
+
+// dictionaryMatches finds every substring that is a dictionary word, ignoring case
+func dictionaryMatches(password []rune) []StrengthMatch {
+	lower := lowerRunes(password)
+	var matches []StrengthMatch
+	for i := range lower {
+		for j := i; j < len(lower); j++ {
+			word := string(lower[i : j+1])
+			for _, dict := range strengthDictionaries() {
+				if rank, ok := dict.ranks[word]; ok {
+					matches = append(matches, StrengthMatch{
+						Pattern:    PatternDictionary,
+						Token:      string(password[i : j+1]),
+						I:          i,
+						J:          j,
+						Dictionary: dict.name,
+						Word:       word,
+						Rank:       rank,
+					})
+				}
+			}
+		}
+	}
+	return matches
+}
+
+// reversedDictionaryMatches finds dictionary words written backwards
+func reversedDictionaryMatches(password []rune) []StrengthMatch {
+	n := len(password)
+	reversed := make([]rune, n)
+	for i, r := range password {
+		reversed[n-1-i] = r
+	}
+	matches := dictionaryMatches(reversed)
+	for k := range matches {
+		m := &matches[k]
+		m.I, m.J = n-1-m.J, n-1-m.I
+		m.Token = string(password[m.I : m.J+1])
+		m.Reversed = true
+	}
+	return matches
+}
+
+// l33tMatches finds dictionary words with letters replaced by look-alike characters
+func l33tMatches(password []rune) []StrengthMatch {
+	// Letters each character of the password may stand for
+	candidates := make(map[rune][]rune)
+	var subbed []rune
+	for letter, subs := range l33tTable {
+		for _, sub := range subs {
+			if strings.ContainsRune(string(password), sub) {
+				if len(candidates[sub]) == 0 {
+					subbed = append(subbed, sub)
+				}
+				candidates[sub] = append(candidates[sub], letter)
+			}
+		}
+	}
+	if len(subbed) == 0 {
+		return nil
+	}
+	sort.Slice(subbed, func(a, b int) bool { return subbed[a] < subbed[b] })
+	for _, letters := range candidates {
+		sort.Slice(letters, func(a, b int) bool { return letters[a] < letters[b] })
+	}
+
+	// Every combination of one letter per substituted character
+	subMaps := []map[rune]rune{{}}
+	for _, sub := range subbed {
+		var next []map[rune]rune
+		for _, m := range subMaps {
+			for _, letter := range candidates[sub] {
+				if len(next) == maxL33tSubs {
+					break
+				}
+				extended := make(map[rune]rune, len(m)+1)
+				for k, v := range m {
+					extended[k] = v
+				}
+				extended[sub] = letter
+				next = append(next, extended)
+			}
+		}
+		subMaps = next
+	}
+
+	var matches []StrengthMatch
+	seen := make(map[[2]int]map[string]bool)
+	for _, subs := range subMaps {
+		translated := make([]rune, len(password))
+		for i, r := range password {
+			if letter, ok := subs[r]; ok {
+				translated[i] = letter
+			} else {
+				translated[i] = r
+			}
+		}
+		for _, m := range dictionaryMatches(translated) {
+			token := password[m.I : m.J+1]
+			if len(token) <= 1 {
+				continue // Single characters are better explained otherwise
+			}
+			used := make(map[rune]rune)
+			for _, r := range token {
+				if letter, ok := subs[r]; ok {
+					used[r] = letter
+				}
+			}
+			if len(used) == 0 {
+				continue // The plain dictionary match covers it
+			}
+			key := [2]int{m.I, m.J}
+			if seen[key] == nil {
+				seen[key] = make(map[string]bool)
+			}
+			if seen[key][m.Dictionary+" "+m.Word] {
+				continue
+			}
+			seen[key][m.Dictionary+" "+m.Word] = true
+			m.Token = string(token)
+			m.L33t = used
+			matches = append(matches, m)
+		}
+	}
+	return matches
+}
+
+// spatialMatches finds runs of three or more neighbouring keys
+func spatialMatches(password []rune) []StrengthMatch {
+	var matches []StrengthMatch
+	for _, g := range keyboardGraphs() {
+		for i := 0; i < len(password)-1; {
+			j := i + 1
+			lastDirection, turns, shifted := -1, 0, 0
+			if g.name == "qwerty" && g.shifted[password[i]] {
+				shifted = 1
+			}
+			for {
+				found := false
+				if j < len(password) {
+					for direction, adjacent := range g.neighbors[password[j-1]] {
+						k := strings.IndexRune(adjacent, password[j])
+						if adjacent == "" || k < 0 {
+							continue
+						}
+						found = true
+						if k == 1 && g.name == "qwerty" {
+							shifted++
+						}
+						if direction != lastDirection {
+							turns++
+							lastDirection = direction
+						}
+						break
+					}
+				}
+				if found {
+					j++
+					continue
+				}
+				if j-i > 2 {
+					matches = append(matches, StrengthMatch{
+						Pattern: PatternSpatial,
+						Token:   string(password[i:j]),
+						I:       i,
+						J:       j - 1,
+						Graph:   g.name,
+						Turns:   turns,
+						Shifted: shifted,
+					})
+				}
+				i = j
+				break
+			}
+		}
+	}
+	return matches
+}
+
+// repeatMatches finds parts repeated back to back, preferring the longest run and then the
+// shortest repeated part, so "aaaa" is "a" four times rather than "aa" twice
+func repeatMatches(password []rune) []StrengthMatch {
+	var matches []StrengthMatch
+	for i := 0; i < len(password)-1; {
+		bestLen, bestBase := 0, 0
+		for base := 1; i+2*base <= len(password); base++ {
+			count := 1
+			for end := i + (count+1)*base; end <= len(password) &&
+				string(password[end-base:end]) == string(password[i:i+base]); end += base {
+				count++
+			}
+			if count >= 2 && count*base > bestLen {
+				bestLen, bestBase = count*base, base
+			}
+		}
+		if bestLen == 0 {
+			i++
+			continue
+		}
+		base := string(password[i : i+bestBase])
+		matches = append(matches, StrengthMatch{
+			Pattern:     PatternRepeat,
+			Token:       string(password[i : i+bestLen]),
+			I:           i,
+			J:           i + bestLen - 1,
+			BaseToken:   base,
+			BaseGuesses: estimateStrength([]rune(base)).Guesses,
+			Repeats:     bestLen / bestBase,
+		})
+		i += bestLen
+	}
+	return matches
+}
+
+// maxSequenceDelta is the largest step between characters of a sequence, as in "aceg"
+const maxSequenceDelta = 5
+
+// sequenceMatches finds runs of characters with a constant step, like "abcd" or "9753"
+func sequenceMatches(password []rune) []StrengthMatch {
+	if len(password) < 2 {
+		return nil
+	}
+	var matches []StrengthMatch
+	add := func(i, j, delta int) {
+		if (j-i > 1 || abs(delta) == 1) && abs(delta) > 0 && abs(delta) <= maxSequenceDelta {
+			matches = append(matches, StrengthMatch{
+				Pattern:   PatternSequence,
+				Token:     string(password[i : j+1]),
+				I:         i,
+				J:         j,
+				Ascending: delta > 0,
+			})
+		}
+	}
+
+	i, lastDelta := 0, int(password[1]-password[0])
+	for k := 1; k < len(password); k++ {
+		delta := int(password[k] - password[k-1])
+		if delta == lastDelta {
+			continue
+		}
+		add(i, k-1, lastDelta)
+		i, lastDelta = k-1, delta
+	}
+	add(i, len(password)-1, lastDelta)
+	return matches
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// yearMatches finds years from 1900 to 2039
+func yearMatches(password []rune) []StrengthMatch {
+	var matches []StrengthMatch
+	for i := 0; i+4 <= len(password); i++ {
+		year, ok := digitsValue(password[i : i+4])
+		if ok && year >= 1900 && year <= 2039 {
+			matches = append(matches, StrengthMatch{
+				Pattern: PatternYear,
+				Token:   string(password[i : i+4]),
+				I:       i,
+				J:       i + 3,
+				Year:    year,
+			})
+		}
+	}
+	return matches
+}
+
+// digitsValue returns the number written by a run of ASCII digits
+func digitsValue(digits []rune) (int, bool) {
+	if len(digits) == 0 {
+		return 0, false
+	}
+	for _, r := range digits {
+		if r < '0' || r > '9' {
+			return 0, false
+		}
+	}
+	n, err := strconv.Atoi(string(digits))
+	return n, err == nil
+}
+
+// dateMatches finds dates of four to eight digits, like 13051987, and dates with separators,
+// like 13/05/1987 or 1987-5-13. Dates inside longer dates are left out.
+func dateMatches(password []rune) []StrengthMatch {
+	var matches []StrengthMatch
+	n := len(password)
+
+	for i := 0; i+4 <= n; i++ {
+		for j := i + 3; j <= i+7 && j < n; j++ {
+			token := password[i : j+1]
+			if _, ok := digitsValue(token); !ok {
+				break
+			}
+			// Of the possible readings take the one nearest the reference year
+			var best *StrengthMatch
+			for _, split := range dateSplits[len(token)] {
+				a, _ := digitsValue(token[:split[0]])
+				b, _ := digitsValue(token[split[0]:split[1]])
+				c, _ := digitsValue(token[split[1]:])
+				if y, m, d, ok := intsToDate(a, b, c); ok {
+					if best == nil || abs(y-referenceYear) < abs(best.Year-referenceYear) {
+						best = &StrengthMatch{Year: y, Month: m, Day: d}
+					}
+				}
+			}
+			if best != nil {
+				best.Pattern, best.Token, best.I, best.J = PatternDate, string(token), i, j
+				matches = append(matches, *best)
+			}
+		}
+	}
+
+	for i := 0; i+6 <= n; i++ {
+		for j := i + 5; j <= i+9 && j < n; j++ {
+			if m, ok := separatedDate(password[i : j+1]); ok {
+				m.I, m.J = i, j
+				matches = append(matches, m)
+			}
+		}
+	}
+
+	// Drop dates inside other dates, like 1/1/91 in 11/1/91
+	var kept []StrengthMatch
+	for a, m := range matches {
+		inside := false
+		for b, other := range matches {
+			if a != b && other.I <= m.I && other.J >= m.J && (other.I != m.I || other.J != m.J) {
+				inside = true
+				break
+			}
+		}
+		if !inside {
+			kept = append(kept, m)
+		}
+	}
+	return kept
+}
+
+// separatedDate parses 1 to 4 digits, a separator, 1 or 2 digits, the same separator and
+// 1 to 4 digits as a date
+func separatedDate(token []rune) (StrengthMatch, bool) {
+	var parts [][]rune
+	sep := rune(-1)
+	start := 0
+	for k, r := range token {
+		if !strings.ContainsRune(dateSeparators, r) {
+			continue
+		}
+		if sep != -1 && r != sep {
+			return StrengthMatch{}, false
+		}
+		sep = r
+		parts = append(parts, token[start:k])
+		start = k + 1
+	}
+	parts = append(parts, token[start:])
+	if len(parts) != 3 || len(parts[0]) > 4 || len(parts[1]) > 2 || len(parts[2]) > 4 {
+		return StrengthMatch{}, false
+	}
+	var ints [3]int
+	for k, part := range parts {
+		v, ok := digitsValue(part)
+		if !ok {
+			return StrengthMatch{}, false
+		}
+		ints[k] = v
+	}
+	y, m, d, ok := intsToDate(ints[0], ints[1], ints[2])
+	if !ok {
+		return StrengthMatch{}, false
+	}
+	return StrengthMatch{
+		Pattern:   PatternDate,
+		Token:     string(token),
+		Year:      y,
+		Month:     m,
+		Day:       d,
+		Separator: string(sep),
+	}, true
+}
+
+// intsToDate reads three numbers as day, month and year in any usual order, with the year
+// first or last. Two digit years are taken as 1951 to 2050.
+func intsToDate(a, b, c int) (year, month, day int, ok bool) {
+	if b > 31 || b <= 0 {
+		return 0, 0, 0, false // The middle number is always a day or a month
+	}
+	over12, over31, under1 := 0, 0, 0
+	for _, n := range []int{a, b, c} {
+		if (n > 99 && n < dateMinYear) || n > dateMaxYear {
+			return 0, 0, 0, false
+		}
+		if n > 31 {
+			over31++
+		}
+		if n > 12 {
+			over12++
+		}
+		if n <= 0 {
+			under1++
+		}
+	}
+	if over31 >= 2 || over12 == 3 || under1 >= 2 {
+		return 0, 0, 0, false
+	}
+
+	splits := [][3]int{{c, a, b}, {a, b, c}} // Year last, year first
+	for _, s := range splits {
+		if s[0] >= dateMinYear && s[0] <= dateMaxYear {
+			if m, d, ok := intsToDayMonth(s[1], s[2]); ok {
+				return s[0], m, d, true
+			}
+			return 0, 0, 0, false // A four digit year with no valid day and month
+		}
+	}
+	for _, s := range splits {
+		if m, d, ok := intsToDayMonth(s[1], s[2]); ok {
+			y := s[0]
+			switch {
+			case y > 99:
+			case y > 50:
+				y += 1900
+			default:
+				y += 2000
+			}
+			return y, m, d, true
+		}
+	}
+	return 0, 0, 0, false
+}
+
+// intsToDayMonth reads two numbers as day and month in either order
+func intsToDayMonth(a, b int) (month, day int, ok bool) {
+	for _, dm := range [][2]int{{a, b}, {b, a}} {
+		if dm[0] >= 1 && dm[0] <= 31 && dm[1] >= 1 && dm[1] <= 12 {
+			return dm[1], dm[0], true
+		}
+	}
+	return 0, 0, false
+}
@@ -0,0 +1,193 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// loadCheckInterval is how often the copy loop re-reads the system load.
+const loadCheckInterval = time.Second
+
+// loadPauseInterval is how long to sleep before re-checking an overloaded system.
+const loadPauseInterval = 5 * time.Second
+
+// Throttle limits the disk, network and CPU resources a backup may consume.
+// A nil *Throttle applies no limits.
+type Throttle struct {
+	ReadRate      int64   // Bytes per second read from source files, 0 for unlimited
+	WriteRate     int64   // Bytes per second written to the archive, 0 for unlimited
+	Burst         int64   // Bytes allowed at once above the rate, defaults to one second worth
+	LowPriority   bool    // Run with idle I/O scheduling class and lowest CPU priority
+	LoadThreshold float64 // Pause while the 1-minute load average exceeds this value, 0 disables
+
+	readLimiter   *rate.Limiter
+	writeLimiter  *rate.Limiter
+	lastLoadCheck time.Time
+	loadWarned    bool // Whether a failure to read the load was reported
+}
+
+// loadAverage returns the 1-minute system load average, replaced by tests
+var loadAverage = procLoadAverage
+
+// NewThrottle creates a Throttle with the given limits, or nil if no limit is set.
+// A load threshold fails on systems whose load average cannot be read.
+func NewThrottle(readRate, writeRate, burst int64, lowPriority bool, loadThreshold float64) (*Throttle, error) {
+	if readRate <= 0 && writeRate <= 0 && !lowPriority && loadThreshold <= 0 {
+		return nil, nil
+	}
+	if loadThreshold > 0 {
+		if _, err := loadAverage(); err != nil {
+			return nil, fmt.Errorf("cannot pause on system load, the load average is unavailable: %v", err)
+		}
+	}
+	t := &Throttle{
+		ReadRate:      readRate,
+		WriteRate:     writeRate,
+		Burst:         burst,
+		LowPriority:   lowPriority,
+		LoadThreshold: loadThreshold,
+	}
+	t.readLimiter = newByteLimiter(readRate, burst)
+	t.writeLimiter = newByteLimiter(writeRate, burst)
+	return t, nil
+}
+
+// newByteLimiter creates a token bucket over bytes, or nil when unlimited.
+func newByteLimiter(bytesPerSec, burst int64) *rate.Limiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = bytesPerSec
+	}
+	return rate.NewLimiter(rate.Limit(bytesPerSec), int(burst))
+}
+
+// apply lowers the scheduling priority of the process if requested.
+func (t *Throttle) apply() error {
+	if t == nil || !t.LowPriority {
+		return nil
+	}
+	return setLowPriority()
+}
+
+// reader wraps r so reads are limited to the configured read rate.
+func (t *Throttle) reader(r io.Reader) io.Reader {
+	if t == nil {
+		return r
+	}
+	return &throttledReader{r: r, t: t}
+}
+
+// writer wraps w so writes are limited to the configured write rate.
+func (t *Throttle) writer(w io.Writer) io.Writer {
+	if t == nil || t.writeLimiter == nil {
+		return w
+	}
+	return &throttledWriter{w: w, limiter: t.writeLimiter}
+}
+
+// waitForLoad blocks while the system load average exceeds the threshold.
+func (t *Throttle) waitForLoad() {
+	if t == nil || t.LoadThreshold <= 0 {
+		return
+	}
+	t.lastLoadCheck = time.Now()
+
+	paused := false
+	for {
+		load, err := loadAverage()
+		if err != nil {
+			// The load was readable when the throttle was created, keep going on a passing failure
+			if !t.loadWarned {
+				fmt.Fprintf(os.Stderr, "Warning: cannot read the system load, not pausing: %v\n", err)
+				t.loadWarned = true
+			}
+			return
+		}
+		if load <= t.LoadThreshold {
+			if paused {
+				fmt.Printf("System load %.2f is below %.2f, resuming backup\n", load, t.LoadThreshold)
+			}
+			return
+		}
+		if !paused {
+			fmt.Printf("System load %.2f exceeds %.2f, pausing backup\n", load, t.LoadThreshold)
+			paused = true
+		}
+		time.Sleep(loadPauseInterval)
+	}
+}
+
+// throttledReader charges every read against the read limiter and periodically checks the load.
+type throttledReader struct {
+	r io.Reader
+	t *Throttle
+}
+
+func (tr *throttledReader) Read(p []byte) (int, error) {
+	if time.Since(tr.t.lastLoadCheck) >= loadCheckInterval {
+		tr.t.waitForLoad()
+	}
+	limiter := tr.t.readLimiter
+	if limiter == nil {
+		return tr.r.Read(p)
+	}
+	// Never ask for more tokens than the bucket can hold
+	if len(p) > limiter.Burst() {
+		p = p[:limiter.Burst()]
+	}
+	n, err := tr.r.Read(p)
+	if n > 0 {
+		if werr := limiter.WaitN(context.Background(), n); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
+
+// throttledWriter splits writes into burst sized chunks and waits for each.
+type throttledWriter struct {
+	w       io.Writer
+	limiter *rate.Limiter
+}
+
+func (tw *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > tw.limiter.Burst() {
+			chunk = chunk[:tw.limiter.Burst()]
+		}
+		if err := tw.limiter.WaitN(context.Background(), len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := tw.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// procLoadAverage reads the 1-minute system load average from /proc/loadavg.
+func procLoadAverage() (float64, error) {
+	data, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("unexpected /proc/loadavg format")
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
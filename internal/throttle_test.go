@@ -0,0 +1,123 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// chunkRecorder records the size of every read or write it sees
+type chunkRecorder struct {
+	r      io.Reader
+	w      bytes.Buffer
+	chunks []int
+}
+
+func (c *chunkRecorder) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if n > 0 {
+		c.chunks = append(c.chunks, n)
+	}
+	return n, err
+}
+
+func (c *chunkRecorder) Write(p []byte) (int, error) {
+	c.chunks = append(c.chunks, len(p))
+	return c.w.Write(p)
+}
+
+// useLoadAverage replaces the load average source for the rest of the test
+func useLoadAverage(t *testing.T, load func() (float64, error)) {
+	t.Helper()
+	saved := loadAverage
+	loadAverage = load
+	t.Cleanup(func() { loadAverage = saved })
+}
+
+// TestThrottleNil checks that a throttle without limits passes everything through
+func TestThrottleNil(t *testing.T) {
+	throttle, err := NewThrottle(0, 0, 0, false, 0)
+	if err != nil || throttle != nil {
+		t.Fatalf("NewThrottle() without limits = %v, %v; want nil, nil", throttle, err)
+	}
+	r, w := bytes.NewReader(nil), &bytes.Buffer{}
+	if throttle.reader(r) != io.Reader(r) || throttle.writer(w) != io.Writer(w) {
+		t.Errorf("nil Throttle wraps readers or writers; want them passed through")
+	}
+	if err := throttle.apply(); err != nil {
+		t.Errorf("nil Throttle apply() err = %v; want nil", err)
+	}
+	throttle.waitForLoad()
+}
+
+// TestThrottleRates checks that reads and writes are split into bursts and take as long as
+// their rate requires
+func TestThrottleRates(t *testing.T) {
+	const rate, burst = 64 << 10, 16 << 10
+	data := bytes.Repeat([]byte("x"), 48<<10)
+
+	// The first burst is free, the remaining 32 KiB take half a second at 64 KiB/s
+	for _, direction := range []string{"read", "write"} {
+		var throttle *Throttle
+		var err error
+		if direction == "read" {
+			throttle, err = NewThrottle(rate, 0, burst, false, 0)
+		} else {
+			throttle, err = NewThrottle(0, rate, burst, false, 0)
+		}
+		if err != nil {
+			t.Fatalf("NewThrottle() err = %v; want nil", err)
+		}
+
+		rec := &chunkRecorder{r: bytes.NewReader(data)}
+		start := time.Now()
+		if direction == "read" {
+			_, err = io.Copy(io.Discard, throttle.reader(rec))
+		} else {
+			_, err = throttle.writer(rec).Write(data)
+		}
+		elapsed := time.Since(start)
+		if err != nil {
+			t.Fatalf("throttled %s err = %v; want nil", direction, err)
+		}
+		if elapsed < 400*time.Millisecond || elapsed > 2*time.Second {
+			t.Errorf("throttled %s of 48 KiB took %v; want about 500ms", direction, elapsed)
+		}
+		total := 0
+		for _, n := range rec.chunks {
+			if n > burst {
+				t.Errorf("throttled %s passed %d bytes at once; want at most the %d byte burst", direction, n, burst)
+			}
+			total += n
+		}
+		if total != len(data) {
+			t.Errorf("throttled %s passed %d bytes; want %d", direction, total, len(data))
+		}
+	}
+}
+
+// TestThrottleLoad checks that a load threshold needs a readable load average
+func TestThrottleLoad(t *testing.T) {
+	useLoadAverage(t, func() (float64, error) { return 0, errors.New("no /proc/loadavg") })
+	if _, err := NewThrottle(0, 0, 0, false, 4); err == nil {
+		t.Errorf("NewThrottle() with an unreadable load err = nil; want an error")
+	}
+
+	useLoadAverage(t, func() (float64, error) { return 1.5, nil })
+	throttle, err := NewThrottle(0, 0, 0, false, 4)
+	if err != nil {
+		t.Fatalf("NewThrottle() err = %v; want nil", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		throttle.waitForLoad()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("waitForLoad() blocked with the load below the threshold")
+	}
+}
@@ -0,0 +1,285 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// WalkOptions controls which files WalkFiles returns and how it traverses directories
+type WalkOptions struct {
+	Include         []string // Only list files matching one of these globs, if any are given
+	Exclude         []string // Skip files and directories matching one of these globs
+	MinSize         int64    // Skip files smaller than this
+	MaxSize         int64    // Skip files larger than this, unless 0
+	MaxDepth        int      // Directory levels to descend below the root, -1 for no limit
+	OneFileSystem   bool     // Do not descend into directories on other filesystems
+	FollowSymlinks  bool     // Follow symlinks to files and directories
+	SkipHidden      bool     // Skip files and directories whose name starts with a dot
+	ContinueOnError bool     // Collect access errors and keep walking instead of stopping
+}
+
+// fileIdentity identifies a file independently of the path it was reached through
+type fileIdentity struct {
+	dev, ino uint64
+}
+
+// walker holds the state of a single WalkFiles call, or of a Watcher for its lifetime
+type walker struct {
+	opts    WalkOptions
+	root    string
+	rootDev uint64
+	hasDev  bool // Whether the platform reports device numbers
+	files   []ScannedFile
+	errs    []FileError
+	seen    map[fileIdentity]string // Directories and files already listed with their path, when following symlinks
+	onDir   func(dir string) error  // Called for the root and every directory descended into, if set
+}
+
+// WalkFiles returns the regular files below root that pass the filters, with their sizes.
+// Glob patterns use filepath.Match syntax and are matched against both the name and the slash
+// separated path relative to root. When following symlinks every file and directory is only
+// listed once, and links pointing back to a parent directory are reported as loops. Errors for
+// single files are returned separately if opts.ContinueOnError is set, otherwise the first one
+// ends the walk.
+func WalkFiles(root string, opts WalkOptions) ([]ScannedFile, []FileError, error) {
+	w, err := newWalker(root, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := w.walk(); err != nil {
+		return nil, nil, err
+	}
+	return w.files, w.errs, nil
+}
+
+// newWalker checks the patterns of opts
+func newWalker(root string, opts WalkOptions) (*walker, error) {
+	for _, p := range append(append([]string{}, opts.Include...), opts.Exclude...) {
+		if _, err := filepath.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", p, err)
+		}
+	}
+	return &walker{opts: opts, root: root, seen: make(map[fileIdentity]string)}, nil
+}
+
+// walk lists every file below the root
+func (w *walker) walk() error {
+	info, err := os.Stat(w.root)
+	if err != nil {
+		return err
+	}
+	if key, ok := statKey(info); ok {
+		w.rootDev, w.hasDev = key.Dev, true
+	}
+
+	if !info.IsDir() {
+		w.addFile(w.root, info)
+		return nil
+	}
+	if w.onDir != nil {
+		if err := w.onDir(w.root); err != nil {
+			return err
+		}
+	}
+	w.markSeen(w.root, info)
+	if err := w.walkDir(w.root, 0, []os.FileInfo{info}); err != nil {
+		return err
+	}
+	sortFileErrors(w.errs)
+	return nil
+}
+
+// walkDir lists a directory at the given depth below the root; ancestors holds every directory
+// on the way down to detect symlink loops
+func (w *walker) walkDir(dir string, depth int, ancestors []os.FileInfo) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return w.fail(dir, err)
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		info, err := entry.Info()
+		if err != nil {
+			if w.opts.SkipHidden && strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			if err := w.fail(path, err); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := w.visit(path, info, depth, ancestors); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// visit lists a file, or walks a directory, found at the given depth below the root. info
+// is the Lstat result of path.
+func (w *walker) visit(path string, info os.FileInfo, depth int, ancestors []os.FileInfo) error {
+	if w.opts.SkipHidden && strings.HasPrefix(filepath.Base(path), ".") {
+		return nil
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		if !w.opts.FollowSymlinks {
+			return nil
+		}
+		var err error
+		if info, err = os.Stat(path); err != nil {
+			return w.fail(path, err)
+		}
+	}
+
+	if !info.IsDir() {
+		w.addFile(path, info)
+		return nil
+	}
+
+	// Decide whether to descend into the directory
+	if w.excluded(path) || (w.opts.MaxDepth >= 0 && depth >= w.opts.MaxDepth) || !w.sameFileSystem(info) {
+		return nil
+	}
+	if loopsBack(info, ancestors) {
+		w.errs = append(w.errs, FileError{Path: path, Err: fmt.Errorf("symlink loop, not followed")})
+		return nil
+	}
+	if !w.markSeen(path, info) {
+		return nil // Reached before through another symlink
+	}
+	if w.onDir != nil {
+		if err := w.onDir(path); err != nil {
+			return w.fail(path, err)
+		}
+	}
+	return w.walkDir(path, depth+1, append(ancestors, info))
+}
+
+// visitNew lists a file, or walks a directory, that appeared below the root after the walk
+func (w *walker) visitNew(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(w.root, filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+
+	// The directories from the root down to path, for the depth limit and loop detection
+	dir := w.root
+	var parts []string
+	if rel != "." {
+		parts = strings.Split(rel, string(filepath.Separator))
+	}
+	ancestors := make([]os.FileInfo, 0, len(parts)+1)
+	for i := 0; i <= len(parts); i++ {
+		if i > 0 {
+			dir = filepath.Join(dir, parts[i-1])
+		}
+		dirInfo, err := os.Stat(dir)
+		if err != nil {
+			return err
+		}
+		ancestors = append(ancestors, dirInfo)
+	}
+	return w.visit(path, info, len(parts), ancestors)
+}
+
+// forget drops the files and directories at or below path from the symlink bookkeeping,
+// so they are listed again if they reappear
+func (w *walker) forget(path string) {
+	prefix := path + string(filepath.Separator)
+	for id, p := range w.seen {
+		if p == path || strings.HasPrefix(p, prefix) {
+			delete(w.seen, id)
+		}
+	}
+}
+
+// addFile lists a regular file if it passes the filters
+func (w *walker) addFile(path string, info os.FileInfo) {
+	if !info.Mode().IsRegular() || w.excluded(path) || !w.included(path) {
+		return
+	}
+	if info.Size() < w.opts.MinSize || (w.opts.MaxSize > 0 && info.Size() > w.opts.MaxSize) {
+		return
+	}
+	if !w.markSeen(path, info) {
+		return
+	}
+	w.files = append(w.files, ScannedFile{Path: path, Size: info.Size()})
+}
+
+// fail records an access error, or returns it if the walk should stop
+func (w *walker) fail(path string, err error) error {
+	if !w.opts.ContinueOnError {
+		return fmt.Errorf("error accessing file %s: %v", path, err)
+	}
+	w.errs = append(w.errs, FileError{Path: path, Err: err})
+	return nil
+}
+
+// markSeen records a file or directory reached through symlinks, returning false if it was seen before
+func (w *walker) markSeen(path string, info os.FileInfo) bool {
+	if !w.opts.FollowSymlinks {
+		return true // Without symlinks every path is reached once
+	}
+	key, ok := statKey(info)
+	if !ok {
+		return true
+	}
+	id := fileIdentity{dev: key.Dev, ino: key.Ino}
+	if _, ok := w.seen[id]; ok {
+		return false
+	}
+	w.seen[id] = path
+	return true
+}
+
+// sameFileSystem reports whether a directory may be entered under the OneFileSystem option
+func (w *walker) sameFileSystem(info os.FileInfo) bool {
+	if !w.opts.OneFileSystem || !w.hasDev {
+		return true
+	}
+	key, ok := statKey(info)
+	return !ok || key.Dev == w.rootDev
+}
+
+// excluded reports whether a path matches an exclude pattern
+func (w *walker) excluded(path string) bool {
+	return w.matchAny(w.opts.Exclude, path)
+}
+
+// included reports whether a file matches an include pattern, or no include patterns are set
+func (w *walker) included(path string) bool {
+	return len(w.opts.Include) == 0 || w.matchAny(w.opts.Include, path)
+}
+
+// matchAny matches the patterns against the name and the relative path
+func (w *walker) matchAny(patterns []string, path string) bool {
+	rel := manifestPath(w.root, path)
+	name := filepath.Base(path)
+	for _, p := range patterns {
+		if ok, _ := filepath.Match(p, name); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(p, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// loopsBack reports whether a directory is one of its own ancestors
+func loopsBack(info os.FileInfo, ancestors []os.FileInfo) bool {
+	for _, a := range ancestors {
+		if os.SameFile(info, a) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestWalkFiles checks the traversal filters and symlink loop detection
+func TestWalkFiles(t *testing.T) {
+	dir := t.TempDir()
+	for name, size := range map[string]int{
+		"a.txt":          10,
+		"big.txt":        1000,
+		"b.log":          10,
+		".hidden":        10,
+		"sub/c.txt":      10,
+		"sub/deep/d.txt": 10,
+		"skip/e.txt":     10,
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+	// A link back to the root and a second path to a file
+	if err := os.Symlink(dir, filepath.Join(dir, "sub", "loop")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	if err := os.Symlink(filepath.Join(dir, "a.txt"), filepath.Join(dir, "link.txt")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		opts  WalkOptions
+		want  []string
+		loops int
+	}{
+		{"all", WalkOptions{MaxDepth: -1}, []string{".hidden", "a.txt", "b.log", "big.txt", "skip/e.txt", "sub/c.txt", "sub/deep/d.txt"}, 0},
+		{"include", WalkOptions{MaxDepth: -1, Include: []string{"*.txt"}, Exclude: []string{"skip", "big*"}}, []string{"a.txt", "sub/c.txt", "sub/deep/d.txt"}, 0},
+		{"relative path", WalkOptions{MaxDepth: -1, Exclude: []string{"sub/deep"}, SkipHidden: true}, []string{"a.txt", "b.log", "big.txt", "skip/e.txt", "sub/c.txt"}, 0},
+		{"size", WalkOptions{MaxDepth: -1, MinSize: 100, MaxSize: 2000}, []string{"big.txt"}, 0},
+		{"depth", WalkOptions{MaxDepth: 1, SkipHidden: true}, []string{"a.txt", "b.log", "big.txt", "skip/e.txt", "sub/c.txt"}, 0},
+		{"follow", WalkOptions{MaxDepth: -1, FollowSymlinks: true}, []string{".hidden", "a.txt", "b.log", "big.txt", "skip/e.txt", "sub/c.txt", "sub/deep/d.txt"}, 1},
+	}
+	for _, tt := range tests {
+		files, errs, err := WalkFiles(dir, tt.opts)
+		if err != nil {
+			t.Fatalf("%s: WalkFiles() err = %v; want nil", tt.name, err)
+		}
+		var got []string
+		for _, f := range files {
+			got = append(got, manifestPath(dir, f.Path))
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: WalkFiles() = %v; want %v", tt.name, got, tt.want)
+		}
+		if len(errs) != tt.loops {
+			t.Errorf("%s: WalkFiles() errs = %v; want %d loop errors", tt.name, errs, tt.loops)
+		}
+	}
+}
+
+// TestWalkFilesKeepGoing checks that unreadable directories stop the walk unless errors are collected
+func TestWalkFilesKeepGoing(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can read every directory")
+	}
+	dir := t.TempDir()
+	locked := filepath.Join(dir, "locked")
+	if err := os.Mkdir(locked, 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "ok"), []byte("ok"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := os.Chmod(locked, 0); err != nil {
+		t.Fatalf("Failed to change permissions: %v", err)
+	}
+	defer os.Chmod(locked, 0755)
+
+	if _, _, err := WalkFiles(dir, WalkOptions{MaxDepth: -1}); err == nil {
+		t.Errorf("WalkFiles() err = nil; want permission error")
+	}
+	files, errs, err := WalkFiles(dir, WalkOptions{MaxDepth: -1, ContinueOnError: true})
+	if err != nil || len(files) != 1 || len(errs) != 1 || errs[0].Path != locked {
+		t.Errorf("WalkFiles() = %v, %v, %v; want one file and one error for %s", files, errs, err, locked)
+	}
+}
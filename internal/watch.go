@@ -0,0 +1,280 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// WatchEventKind is the kind of change reported by a Watcher
+type WatchEventKind string
+
+const (
+	WatchCreated   WatchEventKind = "CREATED"   // A new file appeared
+	WatchModified  WatchEventKind = "MODIFIED"  // The contents of a file changed
+	WatchDeleted   WatchEventKind = "DELETED"   // A file was removed or renamed away
+	WatchDuplicate WatchEventKind = "DUPLICATE" // A created or modified file now has the same contents as others
+	WatchError     WatchEventKind = "ERROR"     // A file could not be hashed or watched
+)
+
+// WatchEvent describes a change below the watched directory
+type WatchEvent struct {
+	Time       time.Time
+	Kind       WatchEventKind
+	Path       string
+	OldHash    string   // Previous hash, for modified and deleted files
+	NewHash    string   // Current hash, for created, modified and duplicate files
+	Duplicates []string // Other files with the same contents, for duplicate events
+	Err        error
+}
+
+// Watcher re-hashes files below a directory as they change, using inotify on Linux
+type Watcher struct {
+	root     string
+	workers  int
+	algo     string
+	cache    *HashCache
+	debounce time.Duration
+	fsw      *fsnotify.Watcher
+	walker   *walker                    // Applies the walk options to the initial scan and to new files
+	hashes   map[string]string          // Current hash of every file
+	byHash   map[string]map[string]bool // Files sharing each hash, for duplicate detection
+	pending  map[string]bool            // Files changed since the last batch was hashed
+}
+
+// NewWatcher hashes every file below root that passes the walk options as a baseline and
+// starts watching the directories the walk descends into. Files created later are filtered
+// the same way. Changes are collected until no event arrived for the debounce interval, then
+// hashed together.
+func NewWatcher(root string, workers int, algo string, cache *HashCache, debounce time.Duration, opts WalkOptions) (*Watcher, []FileError, error) {
+	wk, err := newWalker(root, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating watcher: %v", err)
+	}
+	wk.onDir = func(dir string) error {
+		if err := fsw.Add(dir); err != nil {
+			return fmt.Errorf("error watching %s: %v", dir, err)
+		}
+		return nil
+	}
+	w := &Watcher{
+		root:     root,
+		workers:  workers,
+		algo:     algo,
+		cache:    cache,
+		debounce: debounce,
+		fsw:      fsw,
+		walker:   wk,
+		hashes:   make(map[string]string),
+		byHash:   make(map[string]map[string]bool),
+		pending:  make(map[string]bool),
+	}
+
+	if err := wk.walk(); err != nil {
+		fsw.Close()
+		return nil, nil, err
+	}
+	files := make([]string, len(wk.files))
+	for i, f := range wk.files {
+		files[i] = f.Path
+	}
+	hashes, errs := HashFiles(files, workers, func(path string) (string, error) {
+		return cache.FileHash(path, algo)
+	})
+	for path, hash := range hashes {
+		w.setHash(path, hash)
+	}
+	errs = append(errs, wk.errs...)
+	wk.files, wk.errs = nil, nil
+	sortFileErrors(errs)
+	return w, errs, nil
+}
+
+// Files returns the number of files being tracked
+func (w *Watcher) Files() int {
+	return len(w.hashes)
+}
+
+// Close stops watching
+func (w *Watcher) Close() error {
+	return w.fsw.Close()
+}
+
+// addNew filters a created file, or walks a created directory and watches it, marking the
+// files found as pending
+func (w *Watcher) addNew(path string, emit func(WatchEvent)) {
+	err := w.walker.visitNew(path)
+	if err != nil && !os.IsNotExist(err) { // Gone again, its remove event follows
+		emit(WatchEvent{Time: time.Now(), Kind: WatchError, Path: path, Err: err})
+	}
+	for _, e := range w.walker.errs {
+		emit(WatchEvent{Time: time.Now(), Kind: WatchError, Path: e.Path, Err: e.Err})
+	}
+	for _, f := range w.walker.files {
+		w.pending[f.Path] = true
+	}
+	w.walker.files, w.walker.errs = nil, nil
+}
+
+// Run reports changes to emit until stop is closed
+func (w *Watcher) Run(stop <-chan struct{}, emit func(WatchEvent)) error {
+	timer := time.NewTimer(w.debounce)
+	timer.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return nil
+
+		case event, ok := <-w.fsw.Events:
+			if !ok {
+				return nil
+			}
+			w.handle(event, emit)
+			if len(w.pending) > 0 {
+				timer.Reset(w.debounce)
+			}
+
+		case err, ok := <-w.fsw.Errors:
+			if !ok {
+				return nil
+			}
+			emit(WatchEvent{Time: time.Now(), Kind: WatchError, Path: w.root, Err: err})
+
+		case <-timer.C:
+			w.flush(emit)
+		}
+	}
+}
+
+// handle updates the watch state for a single filesystem event
+func (w *Watcher) handle(event fsnotify.Event, emit func(WatchEvent)) {
+	path := filepath.Clean(event.Name) // Matches the paths found by filepath.Walk
+	switch {
+	case event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename):
+		// A rename is reported on the old name, the new name gets its own create event
+		w.remove(path, emit)
+
+	case event.Has(fsnotify.Create):
+		// Files may have been created in a new directory before it was watched
+		w.addNew(path, emit)
+
+	case event.Has(fsnotify.Write):
+		if _, known := w.hashes[path]; known || w.pending[path] {
+			w.pending[path] = true
+		} else {
+			w.addNew(path, emit) // It may pass the size filters now
+		}
+	}
+}
+
+// remove forgets a deleted file, or every file below a deleted directory
+func (w *Watcher) remove(path string, emit func(WatchEvent)) {
+	var gone []string
+	prefix := path + string(filepath.Separator)
+	for p := range w.hashes {
+		if p == path || strings.HasPrefix(p, prefix) {
+			gone = append(gone, p)
+		}
+	}
+	sort.Strings(gone)
+	w.walker.forget(path)
+	for _, p := range gone {
+		old := w.hashes[p]
+		w.unsetHash(p)
+		delete(w.pending, p)
+		emit(WatchEvent{Time: time.Now(), Kind: WatchDeleted, Path: p, OldHash: old})
+	}
+	delete(w.pending, path)
+}
+
+// flush hashes the files changed since the last batch and reports what differs
+func (w *Watcher) flush(emit func(WatchEvent)) {
+	paths := make([]string, 0, len(w.pending))
+	for p := range w.pending {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	w.pending = make(map[string]bool)
+
+	hashes, errs := HashFiles(paths, w.workers, func(path string) (string, error) {
+		return w.cache.FileHash(path, w.algo)
+	})
+	failed := make(map[string]error, len(errs))
+	for _, e := range errs {
+		failed[e.Path] = e.Err
+	}
+
+	for _, path := range paths {
+		if err, ok := failed[path]; ok {
+			if !os.IsNotExist(err) { // Deleted again before it was hashed, its remove event follows
+				emit(WatchEvent{Time: time.Now(), Kind: WatchError, Path: path, Err: err})
+			}
+			continue
+		}
+
+		hash := hashes[path]
+		old, known := w.hashes[path]
+		if known && old == hash {
+			continue // Rewritten with the same contents
+		}
+		w.setHash(path, hash)
+
+		kind := WatchCreated
+		if known {
+			kind = WatchModified
+		}
+		emit(WatchEvent{Time: time.Now(), Kind: kind, Path: path, OldHash: old, NewHash: hash})
+
+		if others := w.duplicatesOf(path); len(others) > 0 {
+			emit(WatchEvent{Time: time.Now(), Kind: WatchDuplicate, Path: path, NewHash: hash, Duplicates: others})
+		}
+	}
+}
+
+// duplicatesOf returns the other non-empty files with the same contents as path
+func (w *Watcher) duplicatesOf(path string) []string {
+	if info, err := os.Stat(path); err != nil || info.Size() == 0 {
+		return nil // Empty files waste no space
+	}
+	var others []string
+	for p := range w.byHash[w.hashes[path]] {
+		if p != path {
+			others = append(others, p)
+		}
+	}
+	sort.Strings(others)
+	return others
+}
+
+// setHash records the current hash of a file
+func (w *Watcher) setHash(path, hash string) {
+	w.unsetHash(path)
+	w.hashes[path] = hash
+	if w.byHash[hash] == nil {
+		w.byHash[hash] = make(map[string]bool)
+	}
+	w.byHash[hash][path] = true
+}
+
+// unsetHash forgets the hash of a file
+func (w *Watcher) unsetHash(path string) {
+	old, ok := w.hashes[path]
+	if !ok {
+		return
+	}
+	delete(w.hashes, path)
+	delete(w.byHash[old], path)
+	if len(w.byHash[old]) == 0 {
+		delete(w.byHash, old)
+	}
+}
@@ -0,0 +1,120 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestWatcher checks that modifications, new duplicates and deletions are reported
+func TestWatcher(t *testing.T) {
+	dir := t.TempDir()
+	original := filepath.Join(dir, "config")
+	if err := os.WriteFile(original, []byte("setting=1"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	w, errs, err := NewWatcher(dir, 2, "sha256", nil, 50*time.Millisecond, WalkOptions{MaxDepth: -1})
+	if err != nil || len(errs) != 0 {
+		t.Fatalf("NewWatcher() err = %v, %v; want nil", err, errs)
+	}
+	defer w.Close()
+
+	events := make(chan WatchEvent, 16)
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() { done <- w.Run(stop, func(e WatchEvent) { events <- e }) }()
+
+	expect := func(kind WatchEventKind, path string) WatchEvent {
+		t.Helper()
+		select {
+		case e := <-events:
+			if e.Kind != kind || e.Path != path {
+				t.Fatalf("event = %s %s (%v); want %s %s", e.Kind, e.Path, e.Err, kind, path)
+			}
+			return e
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no event; want %s %s", kind, path)
+			return WatchEvent{}
+		}
+	}
+
+	if err := os.WriteFile(original, []byte("setting=2"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if e := expect(WatchModified, original); e.OldHash == e.NewHash {
+		t.Errorf("modified event hashes are both %s; want different", e.OldHash)
+	}
+
+	copied := filepath.Join(dir, "sub", "config.bak")
+	if err := os.Mkdir(filepath.Dir(copied), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(copied, []byte("setting=2"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	expect(WatchCreated, copied)
+	if e := expect(WatchDuplicate, copied); len(e.Duplicates) != 1 || e.Duplicates[0] != original {
+		t.Errorf("duplicate event lists %v; want [%s]", e.Duplicates, original)
+	}
+
+	if err := os.Remove(original); err != nil {
+		t.Fatalf("Failed to remove file: %v", err)
+	}
+	expect(WatchDeleted, original)
+
+	close(stop)
+	if err := <-done; err != nil {
+		t.Errorf("Run() err = %v; want nil", err)
+	}
+}
+
+// TestWatcherFilters checks that the walk options apply to the baseline and to new files
+func TestWatcherFilters(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"kept.log", "ignored.tmp"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	opts := WalkOptions{Exclude: []string{"*.tmp", "a-cache"}, MaxDepth: -1}
+	w, errs, err := NewWatcher(dir, 2, "sha256", nil, 50*time.Millisecond, opts)
+	if err != nil || len(errs) != 0 {
+		t.Fatalf("NewWatcher() err = %v, %v; want nil", err, errs)
+	}
+	defer w.Close()
+	if w.Files() != 1 {
+		t.Errorf("Files() = %d; want 1 without the excluded file", w.Files())
+	}
+
+	events := make(chan WatchEvent, 16)
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() { done <- w.Run(stop, func(e WatchEvent) { events <- e }) }()
+
+	// Excluded files sort before the included one, so they would be reported first
+	if err := os.Mkdir(filepath.Join(dir, "a-cache"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	created := filepath.Join(dir, "c.log")
+	for _, path := range []string{filepath.Join(dir, "a-cache", "x.log"), filepath.Join(dir, "b.tmp"), created} {
+		if err := os.WriteFile(path, []byte(path), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+	select {
+	case e := <-events:
+		if e.Kind != WatchCreated || e.Path != created {
+			t.Errorf("event = %s %s (%v); want %s %s", e.Kind, e.Path, e.Err, WatchCreated, created)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("no event; want %s %s", WatchCreated, created)
+	}
+
+	close(stop)
+	if err := <-done; err != nil {
+		t.Errorf("Run() err = %v; want nil", err)
+	}
+}